@@ -0,0 +1,77 @@
+// Package cache provides read-through and write-through caching of users and objects on redis or memory,
+// saved entities are written to cache and deleted ones are invalidated
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrMiss is returned when key isn't in cache
+var ErrMiss = errors.New("cache miss")
+
+// loadTimeout bounds a load shared by concurrent misses, it doesn't depend on the caller which starts it
+const loadTimeout = 10 * time.Second
+
+// Cache stores json encoded values by key
+type Cache interface {
+	// Get decode the value of key into value, ErrMiss is returned if key doesn't exist
+	Get(ctx context.Context, key string, value interface{}) error
+	// Set value of key which expires after ttl
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	// Delete keys
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Loader reads through cache, concurrent misses of a key are loaded once so the store isn't stampeded
+type Loader struct {
+	cache Cache
+	group singleflight.Group
+}
+
+// NewLoader create loader on cache
+func NewLoader(c Cache) *Loader {
+	return &Loader{cache: c}
+}
+
+// Cache return the cache of loader
+func (l *Loader) Cache() Cache {
+	return l.cache
+}
+
+// Load decode the value of key into value, the value is loaded by load and cached for ttl on miss.
+// load runs with its own context limited by loadTimeout since it's shared by concurrent misses,
+// cancelling ctx only stops waiting for it. Failures of cache fall back to load, errors of load aren't cached
+func (l *Loader) Load(ctx context.Context, key string, ttl time.Duration, value interface{}, load func(ctx context.Context) (interface{}, error)) error {
+	if err := l.cache.Get(ctx, key, value); err == nil {
+		return nil
+	}
+
+	ch := l.group.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+		defer cancel()
+		v, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		l.cache.Set(ctx, key, json.RawMessage(data), ttl)
+		return data, nil
+	})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r := <-ch:
+		if r.Err != nil {
+			return r.Err
+		}
+		return json.Unmarshal(r.Val.([]byte), value)
+	}
+}
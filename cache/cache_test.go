@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/slover2000/beego_demo/events"
+	"github.com/slover2000/beego_demo/models"
+)
+
+type countingObjectRepository struct {
+	models.ObjectRepository
+	gets  int32
+	delay time.Duration
+}
+
+func (r *countingObjectRepository) Get(ctx context.Context, id string) (*models.Object, error) {
+	atomic.AddInt32(&r.gets, 1)
+	time.Sleep(r.delay)
+	return r.ObjectRepository.Get(ctx, id)
+}
+
+func TestLoaderSingleflight(t *testing.T) {
+	store := &countingObjectRepository{
+		ObjectRepository: models.NewMemoryObjectRepository(models.Object{ObjectId: "o1", Score: 100, PlayerName: "astaxie"}),
+		delay:            50 * time.Millisecond,
+	}
+	repo := NewObjectRepository(store, NewLoader(NewMemoryCache()), time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if o, err := repo.Get(context.Background(), "o1"); err != nil || o.Score != 100 {
+				t.Errorf("get object failed, object:%v err:%v", o, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := repo.Get(context.Background(), "o1"); err != nil {
+		t.Fatalf("get cached object failed:%v", err)
+	}
+	if store.gets != 1 {
+		t.Errorf("object should be loaded once, got %d", store.gets)
+	}
+
+	if _, err := repo.Get(context.Background(), "o2"); err != models.ErrObjectNotFound {
+		t.Errorf("missing object should be not found, got %v", err)
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	store := &countingObjectRepository{ObjectRepository: models.NewMemoryObjectRepository()}
+	repo := NewObjectRepository(store, NewLoader(NewMemoryCache()), time.Minute)
+	ctx := context.Background()
+
+	o := models.Object{Score: 1, PlayerName: "astaxie"}
+	if err := repo.Create(ctx, &o); err != nil {
+		t.Fatalf("create object failed:%v", err)
+	}
+	if got, err := repo.Get(ctx, o.ObjectId); err != nil || got.Score != 1 || store.gets != 0 {
+		t.Errorf("created object should be cached, object:%v err:%v loads:%d", got, err, store.gets)
+	}
+	o.Score = 2
+	if err := repo.Update(ctx, &o); err != nil {
+		t.Fatalf("update object failed:%v", err)
+	}
+	if got, err := repo.Get(ctx, o.ObjectId); err != nil || got.Score != 2 || got.Version != o.Version || store.gets != 0 {
+		t.Errorf("updated object should be cached, object:%v err:%v loads:%d", got, err, store.gets)
+	}
+
+	stale := o
+	stale.Version--
+	stale.Score = 3
+	if err := repo.Update(ctx, &stale); err != models.ErrVersionConflict {
+		t.Fatalf("update of stale object should conflict, got %v", err)
+	}
+	if got, err := repo.Get(ctx, o.ObjectId); err != nil || got.Score != 2 || store.gets != 1 {
+		t.Errorf("object should be reloaded after failed update, object:%v err:%v loads:%d", got, err, store.gets)
+	}
+
+	if err := repo.Delete(ctx, o.ObjectId, models.AnyVersion); err != nil {
+		t.Fatalf("delete object failed:%v", err)
+	}
+	if _, err := repo.Get(ctx, o.ObjectId); err != models.ErrObjectNotFound {
+		t.Errorf("deleted object should be not found, got %v", err)
+	}
+}
+
+func TestLoadOutlivesCaller(t *testing.T) {
+	store := &countingObjectRepository{
+		ObjectRepository: models.NewMemoryObjectRepository(models.Object{ObjectId: "o1", Score: 100, PlayerName: "astaxie"}),
+		delay:            50 * time.Millisecond,
+	}
+	repo := NewObjectRepository(store, NewLoader(NewMemoryCache()), time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := repo.Get(ctx, "o1")
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		_, err := repo.Get(context.Background(), "o1")
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	errs := []error{<-done, <-done}
+	if errs[0] != context.Canceled || errs[1] != nil {
+		t.Errorf("only the cancelled caller should fail, got %v", errs)
+	}
+	if store.gets != 1 {
+		t.Errorf("object should be loaded once, got %d", store.gets)
+	}
+}
+
+func TestGetOnPrimary(t *testing.T) {
+	store := &countingObjectRepository{
+		ObjectRepository: models.NewMemoryObjectRepository(models.Object{ObjectId: "o1", Score: 100, PlayerName: "astaxie"}),
+	}
+	repo := NewObjectRepository(store, NewLoader(NewMemoryCache()), time.Minute)
+	ctx := context.Background()
+
+	if _, err := repo.Get(ctx, "o1"); err != nil {
+		t.Fatalf("get object failed:%v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if o, err := repo.Get(models.OnPrimary(ctx), "o1"); err != nil || o.Score != 100 {
+			t.Errorf("get object on primary failed, object:%v err:%v", o, err)
+		}
+	}
+	if store.gets != 3 {
+		t.Errorf("gets on primary should bypass cache, got %d loads", store.gets)
+	}
+}
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	c := NewMemoryCache()
+	ctx := context.Background()
+	c.Set(ctx, "k", "v", 10*time.Millisecond)
+	var v string
+	if err := c.Get(ctx, "k", &v); err != nil || v != "v" {
+		t.Fatalf("value should be cached, value:%s err:%v", v, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := c.Get(ctx, "k", &v); err != ErrMiss {
+		t.Errorf("expired value should miss, got %v", err)
+	}
+}
+
+func TestSyncUsers(t *testing.T) {
+	bus := events.NewBus()
+	c := NewMemoryCache()
+	unsubscribe := SyncUsers(bus, c, time.Minute)
+	ctx := context.Background()
+
+	bus.Publish(&events.UserChanged{Op: events.OpUpdate, ID: 1, User: &models.User{Id: 1, Name: "astaxie", Password: "hash"}})
+	bus.Publish(&events.UserChanged{Op: events.OpInsert, ID: 2, User: &models.User{Id: 2, Name: "slene"}})
+	bus.Publish(&events.UserChanged{Op: events.OpDelete, ID: 2})
+	unsubscribe()
+
+	var u models.User
+	if err := c.Get(ctx, userKey(1), &u); err != nil || u.Name != "astaxie" || u.Password != "" {
+		t.Errorf("changed user should be cached without password, user:%v err:%v", u, err)
+	}
+	if err := c.Get(ctx, userKey(2), &u); err != ErrMiss {
+		t.Errorf("deleted user should be dropped, got %v", err)
+	}
+}
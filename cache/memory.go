@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// MemoryCache keeps values in memory, it's used by tests and single instance deployments
+type MemoryCache struct {
+	lock    sync.RWMutex
+	entries map[string]memoryEntry
+}
+
+// NewMemoryCache create an empty memory cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryEntry)}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string, value interface{}) error {
+	c.lock.RLock()
+	entry, ok := c.entries[key]
+	c.lock.RUnlock()
+	if !ok || time.Now().After(entry.expiresAt) {
+		return ErrMiss
+	}
+	return json.Unmarshal(entry.data, value)
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[key] = memoryEntry{data: data, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (c *MemoryCache) Delete(ctx context.Context, keys ...string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+	"golang.org/x/net/context"
+)
+
+// RedisConfig is the settings of redis, timeouts are in seconds. A cluster is used if there are several addrs
+type RedisConfig struct {
+	Enable       bool
+	Addrs        []string `required:"true"`
+	Password     string
+	DialTimeout  int `default:"5"`
+	ReadTimeout  int `default:"3"`
+	WriteTimeout int `default:"3"`
+	PoolSize     int `default:"10"`
+	PoolTimeout  int `default:"4"`
+	// TTL in seconds of cached users and objects
+	TTL int `default:"300"`
+	// Prefix of keys, so several applications can share redis
+	Prefix string `default:"beego_demo:"`
+}
+
+// RedisCache stores values in redis. Commands of go-redis take no context,
+// so they are bounded by the read and write timeouts of config
+type RedisCache struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisCache connect redis and create cache on it
+func NewRedisCache(cfg *RedisConfig) (*RedisCache, error) {
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:        cfg.Addrs,
+		Password:     cfg.Password,
+		DialTimeout:  time.Duration(cfg.DialTimeout) * time.Second,
+		ReadTimeout:  time.Duration(cfg.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.WriteTimeout) * time.Second,
+		PoolSize:     cfg.PoolSize,
+		PoolTimeout:  time.Duration(cfg.PoolTimeout) * time.Second,
+	})
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisCache{client: client, prefix: cfg.Prefix}, nil
+}
+
+// Close the connections of redis
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string, value interface{}) error {
+	data, err := c.client.Get(c.prefix + key).Bytes()
+	if err == redis.Nil {
+		return ErrMiss
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(c.prefix+key, data, ttl).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	prefixed := make([]string, len(keys))
+	for i := range keys {
+		prefixed[i] = c.prefix + keys[i]
+	}
+	return c.client.Del(prefixed...).Err()
+}
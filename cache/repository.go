@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/slover2000/beego_demo/events"
+	"github.com/slover2000/beego_demo/models"
+)
+
+func userKey(id int64) string {
+	return "user:" + strconv.FormatInt(id, 10)
+}
+
+func objectKey(id string) string {
+	return "object:" + id
+}
+
+// UserRepository caches users got by id, saved users are written to cache and deleted ones are invalidated.
+// Misses are loaded from primary, so lagging replicas are never cached. A load racing with a write may still
+// keep the old user until ttl. Update writes the given user to cache, so it must be the whole user, e.g. got
+// on primary. Password hashes aren't cached, lists, lookups by name and gets on primary go to the store
+type UserRepository struct {
+	models.UserRepository
+	loader *Loader
+	ttl    time.Duration
+}
+
+// NewUserRepository cache the users of repo for ttl
+func NewUserRepository(repo models.UserRepository, loader *Loader, ttl time.Duration) *UserRepository {
+	return &UserRepository{UserRepository: repo, loader: loader, ttl: ttl}
+}
+
+// withoutPassword copy u for caching
+func withoutPassword(u *models.User) *models.User {
+	cached := *u
+	cached.Password = ""
+	return &cached
+}
+
+// save write saved u through to cache, the cached user is dropped when writing fails
+func (r *UserRepository) save(ctx context.Context, u *models.User, err error) error {
+	if err != nil {
+		r.loader.Cache().Delete(ctx, userKey(u.Id))
+		return err
+	}
+	if e := r.loader.Cache().Set(ctx, userKey(u.Id), withoutPassword(u), r.ttl); e != nil {
+		r.loader.Cache().Delete(ctx, userKey(u.Id))
+	}
+	return nil
+}
+
+func (r *UserRepository) Get(ctx context.Context, id int64) (*models.User, error) {
+	if models.ReadsPrimary(ctx) {
+		return r.UserRepository.Get(ctx, id)
+	}
+	var user models.User
+	err := r.loader.Load(ctx, userKey(id), r.ttl, &user, func(ctx context.Context) (interface{}, error) {
+		u, err := r.UserRepository.Get(models.OnPrimary(ctx), id)
+		if err != nil {
+			return nil, err
+		}
+		return withoutPassword(u), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) Create(ctx context.Context, u *models.User) error {
+	err := r.UserRepository.Create(ctx, u)
+	if err != nil {
+		return err
+	}
+	return r.save(ctx, u, nil)
+}
+
+func (r *UserRepository) CreateHashed(ctx context.Context, u *models.User) error {
+	err := r.UserRepository.CreateHashed(ctx, u)
+	if err != nil {
+		return err
+	}
+	return r.save(ctx, u, nil)
+}
+
+func (r *UserRepository) Update(ctx context.Context, u *models.User) error {
+	return r.save(ctx, u, r.UserRepository.Update(ctx, u))
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id int64, version int64) error {
+	err := r.UserRepository.Delete(ctx, id, version)
+	r.loader.Cache().Delete(ctx, userKey(id))
+	return err
+}
+
+// Restore invalidates the user, the restored user is loaded on next get since only its id is known
+func (r *UserRepository) Restore(ctx context.Context, id int64) error {
+	err := r.UserRepository.Restore(ctx, id)
+	r.loader.Cache().Delete(ctx, userKey(id))
+	return err
+}
+
+func (r *UserRepository) Purge(ctx context.Context, id int64) error {
+	err := r.UserRepository.Purge(ctx, id)
+	r.loader.Cache().Delete(ctx, userKey(id))
+	return err
+}
+
+func (r *UserRepository) Import(ctx context.Context, u *models.User) error {
+	err := r.UserRepository.Import(ctx, u)
+	r.loader.Cache().Delete(ctx, userKey(u.Id))
+	return err
+}
+
+// SyncUsers write the users changed on bus to cache for ttl and drop the removed ones, so changes made by others,
+// e.g. other instances seen by tailing mongo, are cached as well. The returned function unsubscribes
+func SyncUsers(bus *events.Bus, c Cache, ttl time.Duration) func() {
+	return bus.Subscribe(events.UserTopic, 256, func(e events.Event) {
+		changed, ok := e.(*events.UserChanged)
+		if !ok {
+			return
+		}
+		ctx := context.Background()
+		if changed.Removed() || c.Set(ctx, userKey(changed.ID), withoutPassword(changed.User), ttl) != nil {
+			c.Delete(ctx, userKey(changed.ID))
+		}
+	})
+}
+
+// ObjectRepository caches objects got by id, saved objects are written to cache and deleted ones are invalidated.
+// Misses are loaded from primary, gets on primary go to the store
+type ObjectRepository struct {
+	models.ObjectRepository
+	loader *Loader
+	ttl    time.Duration
+}
+
+// NewObjectRepository cache the objects of repo for ttl
+func NewObjectRepository(repo models.ObjectRepository, loader *Loader, ttl time.Duration) *ObjectRepository {
+	return &ObjectRepository{ObjectRepository: repo, loader: loader, ttl: ttl}
+}
+
+func (r *ObjectRepository) Get(ctx context.Context, id string) (*models.Object, error) {
+	if models.ReadsPrimary(ctx) {
+		return r.ObjectRepository.Get(ctx, id)
+	}
+	var object models.Object
+	err := r.loader.Load(ctx, objectKey(id), r.ttl, &object, func(ctx context.Context) (interface{}, error) {
+		return r.ObjectRepository.Get(models.OnPrimary(ctx), id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &object, nil
+}
+
+// save write saved o through to cache, the cached object is dropped when writing fails
+func (r *ObjectRepository) save(ctx context.Context, o *models.Object, err error) error {
+	if err != nil {
+		r.loader.Cache().Delete(ctx, objectKey(o.ObjectId))
+		return err
+	}
+	if e := r.loader.Cache().Set(ctx, objectKey(o.ObjectId), o, r.ttl); e != nil {
+		r.loader.Cache().Delete(ctx, objectKey(o.ObjectId))
+	}
+	return nil
+}
+
+func (r *ObjectRepository) Create(ctx context.Context, o *models.Object) error {
+	err := r.ObjectRepository.Create(ctx, o)
+	if err != nil {
+		return err
+	}
+	return r.save(ctx, o, nil)
+}
+
+func (r *ObjectRepository) Update(ctx context.Context, o *models.Object) error {
+	return r.save(ctx, o, r.ObjectRepository.Update(ctx, o))
+}
+
+func (r *ObjectRepository) Delete(ctx context.Context, id string, version int64) error {
+	err := r.ObjectRepository.Delete(ctx, id, version)
+	r.loader.Cache().Delete(ctx, objectKey(id))
+	return err
+}
@@ -3,10 +3,13 @@ package controllers
 import (
 	"strconv"
 	"strings"
+	"time"
 	"html/template"
 
+	"github.com/astaxie/beego/validation"
 	"github.com/sirupsen/logrus"
 
+	"github.com/slover2000/beego_demo/errcode"
 	"github.com/slover2000/beego_demo/models"
 )
 
@@ -21,32 +24,142 @@ type RoleData struct {
 	Have  bool
 }
 
+// pageForm is the paging parameters of layui table
+type pageForm struct {
+	Page  int `form:"page" valid:"Min(1)"`
+	Limit int `form:"limit" valid:"Range(1,100)"`
+}
+
+func (f *pageForm) offset() int {
+	return (f.Page - 1) * f.Limit
+}
+
+// idForm identifies the user, role, permission or api key to operate
+type idForm struct {
+	ID int64 `form:"id" valid:"Min(1)"`
+}
+
+type userForm struct {
+	Name     string `form:"name" valid:"Required;MinSize(3);MaxSize(32)"`
+	Password string `form:"password" valid:"Required;MinSize(6);MaxSize(64)"`
+	Age      int    `form:"age" valid:"Range(0,150)"`
+	Gender   int    `form:"gender" valid:"Range(0,1)"`
+	Email    string `form:"email" valid:"Required;Email;MaxSize(100)"`
+	Address  string `form:"addr" valid:"MaxSize(200)"`
+}
+
+// userEditForm is the profile of user, name can't be changed. Email is optional since users of
+// identity providers and directory may have none.
+// Version is the update time of user when the form is loaded, the change is rejected if it's modified since then
+type userEditForm struct {
+	ID      int64  `form:"id" valid:"Min(1)"`
+	Version int64  `form:"version"`
+	Name    string `form:"name"`
+	Age     int    `form:"age" valid:"Range(0,150)"`
+	Gender  int    `form:"gender" valid:"Range(0,1)"`
+	Email   string `form:"email" valid:"MaxSize(100)"`
+	Address string `form:"addr" valid:"MaxSize(200)"`
+}
+
+// Valid check email when it's provided
+func (f *userEditForm) Valid(v *validation.Validation) {
+	if f.Email != "" {
+		v.Email(f.Email, "Email")
+	}
+}
+
+type roleForm struct {
+	Name string `form:"name" valid:"Required;MaxSize(64)"`
+}
+
+// roleEditForm is the permissions of role, checked is comma separated permission ids
+type roleEditForm struct {
+	ID      int64  `form:"id" valid:"Min(1)"`
+	Checked string `form:"checked"`
+}
+
+// permissionForm is a permission of group, action is the index of GET, POST, PUT, DELETE, * and PATCH
+type permissionForm struct {
+	Name     string `form:"name" valid:"Required;MaxSize(64)"`
+	Resource string `form:"resource" valid:"Required;MaxSize(256)"`
+	Action   int    `form:"action" valid:"Range(0,5)"`
+	Group    int64  `form:"group" valid:"Min(1)"`
+}
+
+type groupForm struct {
+	Name string `form:"name" valid:"Required;MaxSize(64)"`
+}
+
+// trashForm identifies the soft deleted entity to restore or purge
+type trashForm struct {
+	Kind string `form:"kind" valid:"Required;Match(/^(user|role|permission)$/)"`
+	ID   int64  `form:"id" valid:"Min(1)"`
+}
+
+// searchForm is the keyword of global search in admin console
+type searchForm struct {
+	Keyword string `form:"keyword" valid:"Required;MaxSize(64)"`
+}
+
+// searchLimit is the number of items of every kind found by global search
+const searchLimit = 5
+
+// searchResult is the first items of every kind found by global search, totals tell how many are matched
+type searchResult struct {
+	Users           []models.UserResp         `json:"users"`
+	UserTotal       int                       `json:"user_total"`
+	Roles           []models.CasbinRole       `json:"roles"`
+	RoleTotal       int                       `json:"role_total"`
+	Permissions     []models.CasbinPermission `json:"permissions"`
+	PermissionTotal int                       `json:"permission_total"`
+}
+
+type groupIDForm struct {
+	Group int64 `form:"group" valid:"Min(1)"`
+}
+
+// apiKeyForm is the client of api key, expire is in days and zero means never expire
+type apiKeyForm struct {
+	Name   string `form:"name" valid:"Required;MaxSize(64)"`
+	Expire int    `form:"expire" valid:"Range(0,3650)"`
+}
+
+func genderName(gender int) string {
+	if gender == 1 {
+		return "female"
+	}
+	return "male"
+}
+
 func (c *AdminController) UserList() {
 	c.Data["pageTitle"] = "用户列表"
 	c.Data["xsrf_token"] = c.XSRFToken()
+	c.Data["keyword"] = c.GetString("keyword")
 	c.renderNestedTemplate("admin/users")
 }
 
-func (c *AdminController) GetUsers() {
-	page, err := c.GetInt("page")
+// listQuery parse the keyword, filter and sort parameters of listing, the page is the one of form
+func (c *AdminController) listQuery(schema *models.QuerySchema, form *pageForm) *models.Query {
+	q, err := models.ParseQuery(c.Ctx.Request.URL.Query(), schema)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"user": c.userName,
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("invalid page parameter '%s'", c.GetString("page"))
-		c.Abort("400")
+		c.serveError(errcode.New(errcode.InvalidArgument, "invalid_query").WithDetails(err.Error()))
 	}
-	limit, err := c.GetInt("limit")
+	q.Offset, q.Limit = form.offset(), form.Limit
+	return q
+}
+
+func (c *AdminController) GetUsers() {
+	var form pageForm
+	c.bindForm(&form)
+
+	users, total, err := models.Users().List(c.Ctx.Request.Context(), c.listQuery(models.AdminUserQuerySchema, &form))
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"user": c.userName,
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("invalid limit parameter '%s'", c.GetString("limit"))		
-		c.Abort("400")
+		c.serveError(err)
 	}
+	c.serveTable(userResps(users), total)
+}
 
-	offset := (page - 1) * limit
-	users, total := models.GetUsers(offset, limit)
+func userResps(users []models.User) []models.UserResp {
 	userResp := make([]models.UserResp, len(users))
 	for i := range users {
 		u := users[i]
@@ -55,22 +168,10 @@ func (c *AdminController) GetUsers() {
 			Name: u.Name,
 			CreateTime: models.JSONTime(u.CreateTime),
 			UpdateTime: models.JSONTime(u.UpdateTime),
-			Profile: models.Profile{
-				Gender: u.Profile2.Gender,
-				Age: u.Profile2.Age,
-				Address: u.Profile2.Address,
-				Email: u.Profile2.Email,
-			},
+			Profile: u.Profile,
 		}
 	}
-	resp := &tableData{
-		Status: 0,
-		Message: "ok",
-		Total: total,
-		Rows: userResp,
-	}	
-	c.Data["json"] = resp
-	c.ServeJSON()
+	return userResp
 }
 
 func (c *AdminController) GetUser() {
@@ -81,7 +182,7 @@ func (c *AdminController) GetUser() {
 		c.Data["roles"] = roles
 		tpl = "admin/user_add"
 	} else {
-		user, err := models.GetUser2(id)
+		user, err := models.Users().Get(c.Ctx.Request.Context(), id)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"id"  : id,
@@ -104,11 +205,12 @@ func (c *AdminController) GetUser() {
 		}
 		c.Data["roles"] = roleData
 		c.Data["uid"] = user.Id
+		c.Data["version"] = user.Version()
 		c.Data["username"] = user.Name
-		c.Data["age"] = user.Profile2.Age
-		c.Data["gender"] = user.Profile2.Gender
-		c.Data["email"] = user.Profile2.Email
-		c.Data["addr"] = user.Profile2.Address
+		c.Data["age"] = user.Profile.Age
+		c.Data["gender"] = user.Profile.Gender
+		c.Data["email"] = user.Profile.Email
+		c.Data["addr"] = user.Profile.Address
 		tpl = "admin/user_edit"
 	}
 	
@@ -117,151 +219,95 @@ func (c *AdminController) GetUser() {
 }
 
 func (c *AdminController) SaveUser() {
-	id, err := c.GetInt64("id")
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path":   c.Ctx.Request.URL.Path,
-		}).Errorf("can't get id parameter '%s'", c.GetString("id"))
-		c.Abort("400")
-	}
+	var form userEditForm
+	c.bindForm(&form)
 
-	age, err := c.GetInt("age")
+	// only profile is edited, the version of form is checked unless it's zero
+	ctx := c.Ctx.Request.Context()
+	user, err := models.Users().Get(models.OnPrimary(ctx), form.ID)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("age parameter must be integer '%s'", c.GetString("age"))
-		c.Abort("400")
-	}
-
-	var gender = "male"
-	genderType, _ := c.GetInt("gender")
-	if genderType == 1 {
-		gender = "female"
-	}
-
-	user := &models.User2{
-		Id: id,
-		Name: c.GetString("name"),
-		Profile2: models.Profile{
-			Gender: gender,
-			Age: age,
-			Address: c.GetString("addr"),
-			Email: c.GetString("email"),
-		},
+		c.serveError(errcode.New(errcode.Internal, "save_user_failed").WithCause(err))
 	}
-
-	resp := &responseData{
-		Status: 0,
-		Message: "ok",
+	if form.Version != 0 {
+		user.UpdateTime = time.Unix(0, form.Version)
+	}
+	user.Password = ""
+	user.Profile = models.Profile{
+		Gender: genderName(form.Gender),
+		Age: form.Age,
+		Address: form.Address,
+		Email: form.Email,
 	}
-	if err := models.SaveUser2(user); err != nil {
-		resp.Status = 100
-		resp.Message = "failed"
+
+	if err := models.Users().Update(ctx, user); err != nil {
+		if err != models.ErrVersionConflict {
+			err = errcode.New(errcode.Internal, "save_user_failed").WithCause(err)
+		}
+		c.serveError(err)
 	}
 
 	roles := make([]uint, 0)
 	c.Ctx.Input.Bind(&roles, "role")	
 	enforcer.SaveUser(&models.CasbinUser{ID: user.Id, Name: user.Name}, roles)
-	c.Data["json"] = resp
-	c.ServeJSON()
+	c.ajaxSuccess(nil)
 }
 
 func (c *AdminController) CreateUser() {
-	name := c.GetString("name")
-	if name == "" {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("name must be provided")
-		c.Abort("400")
-	}
-
-	password := c.GetString("password")
-	if password == "" {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("password must be provided")
-		c.Abort("400")
-	}
-
-	age, err := c.GetInt("age")
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("age must be integer '%s'", c.GetString("age"))
-		c.Abort("400")
-	}
-	
-	gender := "male"
-	genderType, err := c.GetInt("gender")
-	if genderType != 0 {
-		gender = "female"
-	}
-	
-	user := models.User2{
-		Name: name,
-		Password: password,
-		Profile2: models.Profile{
-			Age: age,
-			Gender: gender,
-			Email: c.GetString("email"),
-			Address: c.GetString("addr"),
+	var form userForm
+	c.bindForm(&form)
+
+	user := models.User{
+		Name: form.Name,
+		Password: form.Password,
+		Profile: models.Profile{
+			Age: form.Age,
+			Gender: genderName(form.Gender),
+			Email: form.Email,
+			Address: form.Address,
 		},
 	}
 
-	resp := &responseData{
-		Status: 0,
-		Message: "ok",
-	}	
-	err = models.CreateUser2(&user)
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("create user failed:%v", err)
-		resp.Status = 100
-		resp.Message = "用户名重复"
+	err := models.Users().Create(c.Ctx.Request.Context(), &user)
+	if err == models.ErrInvalidUserName {
+		c.serveError(err)
+	} else if err != nil {
+		c.serveError(errcode.New(errcode.AlreadyExists, "user_name_exists").WithCause(err))
 	}
 
 	roles := make([]uint, 0)
 	c.Ctx.Input.Bind(&roles, "role")
 	enforcer.SaveUser(&models.CasbinUser{ID: user.Id, Name: user.Name}, roles)
 
-	c.Data["json"] = resp
-	c.ServeJSON()
+	c.ajaxSuccess(nil)
 }
 
 func (c *AdminController) DeleteUser() {
-	id, err := c.GetInt64("id")
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("can't get id parameter '%s'", c.GetString("id"))
-		c.Abort("400")
-	}
+	var form idForm
+	c.bindForm(&form)
 
-	resp := &responseData{
-		Status: 0,
-		Message: "ok",
+	ctx := c.Ctx.Request.Context()
+	user, err := models.Users().Get(models.OnPrimary(ctx), form.ID)
+	if err == nil {
+		err = models.Users().Delete(ctx, form.ID, models.AnyVersion)
 	}
-	user2, err := models.GetUser2(id)
-	if err != nil {
-		err = models.DeleteUser2(id)
-		enforcer.DeleteUser(user2.Id, user2.Name)
+	if err == nil {
+		err = enforcer.DeleteUser(user.Id, user.Name)
+	}
+	if err == nil {
+		// user in trash can't keep signed in
+		err = models.SignOutEverywhere(user.Id)
 	}
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("delete user failed:%v", err)
-		resp.Status = 101
-		resp.Message = "删除用户失败"
+		c.serveError(errcode.New(errcode.Internal, "delete_user_failed").WithCause(err))
 	}
 
-	c.Data["json"] = resp
-	c.ServeJSON()	
+	c.ajaxSuccess(nil)
 }
 
 func (c *AdminController) RoleList() {
 	c.Data["pageTitle"] = "角色列表"
 	c.Data["xsrf_token"] = c.XSRFToken()
+	c.Data["keyword"] = c.GetString("keyword")
 	c.renderNestedTemplate("admin/roles")
 }
 
@@ -271,7 +317,7 @@ func (c *AdminController) GetRole() {
 	if err != nil {
 		tpl = "admin/role_add"
 	} else {
-		groups := enforcer.GetPermissionsWithoutEmpty()
+		groups := enforcer.GetPermissionsWithoutEmpty(c.Ctx.Request.Context())
 		role, err := enforcer.GetRole(uint(id))
 		if err == nil {
 			hadPermissions := make([]uint, 0)
@@ -308,46 +354,21 @@ func (c *AdminController) GetRole() {
 }
 
 func (c *AdminController) GetRoles() {
-	page, err := c.GetInt("page")
+	var form pageForm
+	c.bindForm(&form)
+
+	roles, total, err := enforcer.GetRoles(c.Ctx.Request.Context(), c.listQuery(models.RoleQuerySchema, &form))
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"user": c.userName,
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("invalid page parameter '%s'", c.GetString("page"))
-		c.Abort("400")
+		c.serveError(err)
 	}
-	limit, err := c.GetInt("limit")
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"user": c.userName,
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("invalid limit parameter '%s'", c.GetString("limit"))		
-		c.Abort("400")
-	}
-
-	offset := (page - 1) * limit
-	roles, total := enforcer.GetRoles(offset, limit)
-	resp := &tableData{
-		Status: 0,
-		Message: "ok",
-		Total: total,
-		Rows: roles,
-	}	
-	c.Data["json"] = resp
-	c.ServeJSON()	
+	c.serveTable(roles, total)
 }
 
 func (c *AdminController) SaveRole() {
-	roleid, err := c.GetUint32("id")
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("role id must be provided '%s'", c.GetString("id"))
-		c.Abort("400")
-	}
+	var form roleEditForm
+	c.bindForm(&form)
 
-	checkedArray := c.GetString("checked")
-	idArray := strings.Split(checkedArray, ",")
+	idArray := strings.Split(form.Checked, ",")
 	ids := make([]uint, len(idArray))
 	for i := range idArray {
 		if id, err := strconv.Atoi(idArray[i]); err == nil {
@@ -355,85 +376,80 @@ func (c *AdminController) SaveRole() {
 		}
 	}
 
-	resp := &responseData{
-		Status: 0,
-		Message: "ok",
-	}		
-	err = enforcer.SaveRole(uint(roleid), ids)
+	err := enforcer.SaveRole(uint(form.ID), ids)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("save role's permissions failed:%v", err)
-		resp.Status = 100
-		resp.Message = "保存角色权限失败"
+		c.serveError(errcode.New(errcode.Internal, "save_role_failed").WithCause(err))
 	}
-	c.Data["json"] = resp
-	c.ServeJSON()	
+	c.ajaxSuccess(nil)
 }
 
 func (c *AdminController) CreateRole() {
-	name := c.GetString("name")
-	if name == "" {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("role name must be provided")
-		c.Abort("400")
-	}
+	var form roleForm
+	c.bindForm(&form)
 
 	role := models.CasbinRole{
-		Name: name,
+		Name: form.Name,
 	}
-	resp := &responseData{
-		Status: 0,
-		Message: "ok",
-	}	
 	err := enforcer.CreateRole(&role)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("create role failed:%v", err)
-		resp.Status = 100
-		resp.Message = "角色名重复"
+		c.serveError(errcode.New(errcode.AlreadyExists, "role_name_exists").WithCause(err))
 	}
 
-	c.Data["json"] = resp
-	c.ServeJSON()
+	c.ajaxSuccess(nil)
 }
 
 func (c *AdminController) DeleteRole() {
-	id, err := c.GetUint32("id")
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("can't get role id parameter '%s'", c.GetString("id"))
-		c.Abort("400")
-	}
+	var form idForm
+	c.bindForm(&form)
 
-	resp := &responseData{
-		Status: 0,
-		Message: "ok",
-	}	
-	err = enforcer.DeleteRole(uint(id))
+	err := enforcer.DeleteRole(uint(form.ID))
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("delete role failed:%v", err)
-		resp.Status = 101
-		resp.Message = "删除角色失败"
+		c.serveError(errcode.New(errcode.Internal, "delete_role_failed").WithCause(err))
 	}
 
-	c.Data["json"] = resp
-	c.ServeJSON()	
+	c.ajaxSuccess(nil)
 }
 
 func (c *AdminController) PermissionList() {
-	groups := enforcer.GetPermissions()
+	keyword := strings.TrimSpace(c.GetString("keyword"))
+	groups := filterPermissions(enforcer.GetPermissions(c.Ctx.Request.Context()), keyword)
+	c.Data["keyword"] = keyword
 	c.Data["permissGroup"] = groups
 	c.Data["pageTitle"] = "权限列表"
 	c.Data["xsrf_token"] = c.XSRFToken()
 	c.renderNestedTemplate("admin/permissions")
 }
 
+// filterPermissions keep the permissions whose name or resource contains keyword case insensitively,
+// all permissions of group are kept when the group name contains it
+func filterPermissions(groups []models.CasbinPermission, keyword string) []models.CasbinPermission {
+	if keyword == "" {
+		return groups
+	}
+	keyword = strings.ToLower(keyword)
+	contains := func(p *models.CasbinPermission) bool {
+		return strings.Contains(strings.ToLower(p.Name), keyword) || strings.Contains(strings.ToLower(p.Resource), keyword)
+	}
+
+	filtered := make([]models.CasbinPermission, 0)
+	for _, group := range groups {
+		if !contains(&group) {
+			children := make([]models.CasbinPermission, 0)
+			for i := range group.Children {
+				if contains(&group.Children[i]) {
+					children = append(children, group.Children[i])
+				}
+			}
+			if len(children) == 0 {
+				continue
+			}
+			group.Children = children
+		}
+		filtered = append(filtered, group)
+	}
+	return filtered
+}
+
 func (c *AdminController) GetPermission() {
 	tpl := "admin/permission_add"
 	gid, err := c.GetInt64("group")
@@ -446,41 +462,12 @@ func (c *AdminController) GetPermission() {
 }
 
 func (c *AdminController) CreatePermission() {
-	name := c.GetString("name")
-	if name == "" {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("name must be provided")
-		c.Abort("400")
-	}
-
-	resource := c.GetString("resource")
-	if resource == "" {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("resource must be provided")
-		c.Abort("400")
-	}
-
-	actionID, err := c.GetInt("action")
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("action must be provided")
-		c.Abort("400")
-	}
-
-	gid, err := c.GetUint32("group")
-	if err != nil || gid == 0 {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("group id must be provided")
-		c.Abort("400")
-	}
+	var form permissionForm
+	c.bindForm(&form)
 
 	// convert action id to name
 	action := ""
-	switch actionID {
+	switch form.Action {
 	case 0:
 		action = "GET"
 	case 1:
@@ -491,55 +478,34 @@ func (c *AdminController) CreatePermission() {
 		action = "DELETE"
 	case 4:
 		action = "*"
+	case 5:
+		action = "PATCH"
 	}
 	
 	permission := models.CasbinPermission{
-		Parent: uint(gid),
-		Name: name,
-		Resource: resource,
+		Parent: uint(form.Group),
+		Name: form.Name,
+		Resource: form.Resource,
 		Action: action,
 	}	
-	resp := &responseData{
-		Status: 0,
-		Message: "ok",
-	}	
-	err = enforcer.CreatePermission(&permission)
+	err := enforcer.CreatePermission(&permission)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("create permission failed:%v", err)
-		resp.Status = 100
-		resp.Message = "权限名重复"
+		c.serveError(errcode.New(errcode.AlreadyExists, "permission_name_exists").WithCause(err))
 	}
 
-	c.Data["json"] = resp
-	c.ServeJSON()
+	c.ajaxSuccess(nil)
 }
 
 func (c *AdminController) DeletePermission() {
-	id, err := c.GetUint32("id")
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("can't get permission id")
-		c.Abort("400")
-	}
+	var form idForm
+	c.bindForm(&form)
 
-	resp := &responseData{
-		Status: 0,
-		Message: "ok",
-	}	
-	err = enforcer.DeletePermission(uint(id))
+	err := enforcer.DeletePermission(uint(form.ID))
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("delete permission failed:%v", err)
-		resp.Status = 101
-		resp.Message = "删除权限失败"
+		c.serveError(errcode.New(errcode.Internal, "delete_permission_failed").WithCause(err))
 	}
 
-	c.Data["json"] = resp
-	c.ServeJSON()
+	c.ajaxSuccess(nil)
 }
 
 func (c *AdminController) GetGroup() {
@@ -550,65 +516,230 @@ func (c *AdminController) GetGroup() {
 		c.renderAjaxTemplate(tpl)
 	} else {
 		permissions := enforcer.GetChildPermissions(uint(gid))
-		resp := &tableData{
-			Status: 0,
-			Message: "ok",
-			Total: len(permissions),
-			Rows: permissions,
-		}
-		c.Data["json"] = resp
-		c.ServeJSON()
+		c.serveTable(permissions, len(permissions))
 	}
 }
 
 func (c *AdminController) CreateGroup() {
-	name := c.GetString("name")
-	if name == "" {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("name must be provided")
-		c.Abort("400")
-	}
+	var form groupForm
+	c.bindForm(&form)
 	
-	resp := &responseData{
-		Status: 0,
-		Message: "ok",
-	}	
-	err := enforcer.CreatePermission(&models.CasbinPermission{Name: name})
+	err := enforcer.CreatePermission(&models.CasbinPermission{Name: form.Name})
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("create group failed:%v", err)
-		resp.Status = 100
-		resp.Message = "组名重复"
+		c.serveError(errcode.New(errcode.AlreadyExists, "group_name_exists").WithCause(err))
 	}
 
-	c.Data["json"] = resp
-	c.ServeJSON()	
+	c.ajaxSuccess(nil)
 }
 
 func (c *AdminController) DeleteGroup() {
-	group, err := c.GetUint32("group")
+	var form groupIDForm
+	c.bindForm(&form)
+
+	err := enforcer.DeletePermission(uint(form.Group))
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("group id must be provided")
-		c.Abort("400")
+		c.serveError(errcode.New(errcode.Internal, "delete_group_failed").WithCause(err))
+	}
+
+	c.ajaxSuccess(nil)
+}
+
+func (c *AdminController) APIKeyList() {
+	c.Data["pageTitle"] = "API密钥列表"
+	c.Data["xsrf_token"] = c.XSRFToken()
+	c.renderNestedTemplate("admin/apikeys")
+}
+
+func (c *AdminController) GetAPIKeys() {
+	var form pageForm
+	c.bindForm(&form)
+
+	keys, total := enforcer.GetAPIKeys(form.offset(), form.Limit)
+	c.serveTable(keys, total)
+}
+
+func (c *AdminController) GetAPIKey() {
+	c.Data["roles"] = enforcer.GetAllRoles()
+	c.Data["xsrfdata"] = template.HTML(c.XSRFFormHTML())
+	c.renderAjaxTemplate("admin/apikey_add")
+}
+
+func (c *AdminController) CreateAPIKey() {
+	var form apiKeyForm
+	c.bindForm(&form)
+
+	k := &models.APIKey{Name: form.Name}
+	if form.Expire > 0 {
+		expiresAt := time.Now().AddDate(0, 0, form.Expire)
+		k.ExpiresAt = &expiresAt
 	}
 
-	err = enforcer.DeletePermission(uint(group))
-	resp := &responseData{
-		Status: 0,
-		Message: "ok",
+	key, err := models.GenerateAPIKey(k)
+	if err == nil {
+		roles := make([]uint, 0)
+		c.Ctx.Input.Bind(&roles, "role")
+		err = enforcer.CreateAPIKey(k, roles)
+	}
+	if err == models.ErrAPIKeyNameExists {
+		c.serveError(errcode.New(errcode.AlreadyExists, "client_name_exists").WithCause(err))
+	} else if err != nil {
+		c.serveError(errcode.New(errcode.Internal, "create_apikey_failed").WithCause(err))
 	}
+
+	// the plain key is only showed once
+	c.ajaxSuccess(key)
+}
+
+func (c *AdminController) DeleteAPIKey() {
+	var form idForm
+	c.bindForm(&form)
+
+	err := enforcer.DeleteAPIKey(uint(form.ID))
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"path": c.Ctx.Request.URL.Path,
-		}).Errorf("deletre group failed:%v", err)
-		resp.Status = 100
-		resp.Message = "删除权限组失败"
+		c.serveError(errcode.New(errcode.Internal, "delete_apikey_failed").WithCause(err))
 	}
 
-	c.Data["json"] = resp
-	c.ServeJSON()		
-}
\ No newline at end of file
+	c.ajaxSuccess(nil)
+}
+
+func (c *AdminController) RegistrationList() {
+	c.Data["pageTitle"] = "注册审核"
+	c.Data["xsrf_token"] = c.XSRFToken()
+	c.renderNestedTemplate("admin/registrations")
+}
+
+func (c *AdminController) GetRegistrations() {
+	var form pageForm
+	c.bindForm(&form)
+
+	registrations, total := models.GetRegistrations(models.RegistrationPendingApproval, form.offset(), form.Limit)
+	c.serveTable(registrations, total)
+}
+
+func (c *AdminController) ApproveRegistration() {
+	var form idForm
+	c.bindForm(&form)
+
+	r, err := models.GetRegistration(uint(form.ID))
+	if err == nil && r.State != models.RegistrationPendingApproval {
+		err = models.ErrInvalidRegistration
+	}
+	if err == nil {
+		_, err = activateRegistration(r)
+	}
+	if err != nil {
+		c.serveError(errcode.New(errcode.Internal, "approve_registration_failed").WithCause(err))
+	}
+
+	c.ajaxSuccess(nil)
+}
+
+func (c *AdminController) RejectRegistration() {
+	var form idForm
+	c.bindForm(&form)
+
+	if err := models.RejectRegistration(uint(form.ID)); err != nil {
+		c.serveError(errcode.New(errcode.Internal, "reject_registration_failed").WithCause(err))
+	}
+
+	c.ajaxSuccess(nil)
+}
+
+func (c *AdminController) TrashList() {
+	c.Data["pageTitle"] = "回收站"
+	c.Data["xsrf_token"] = c.XSRFToken()
+	c.renderNestedTemplate("admin/trash")
+}
+
+func (c *AdminController) GetTrash() {
+	var form pageForm
+	c.bindForm(&form)
+
+	items, total, err := models.GetTrash(c.Ctx.Request.Context(), form.offset(), form.Limit)
+	if err != nil {
+		c.serveError(err)
+	}
+	c.serveTable(items, total)
+}
+
+func (c *AdminController) RestoreTrash() {
+	var form trashForm
+	c.bindForm(&form)
+
+	var err error
+	switch form.Kind {
+	case models.TrashUser:
+		if err = models.Users().Restore(c.Ctx.Request.Context(), form.ID); err == nil {
+			// user may have no roles
+			if err = enforcer.RestoreUser(form.ID); err == models.ErrNotInTrash {
+				err = nil
+			}
+		}
+	case models.TrashRole:
+		err = enforcer.RestoreRole(uint(form.ID))
+	case models.TrashPermission:
+		err = enforcer.RestorePermission(uint(form.ID))
+	}
+	if err == models.ErrNotInTrash {
+		c.serveError(err)
+	} else if err != nil {
+		c.serveError(errcode.New(errcode.Internal, "restore_failed").WithCause(err))
+	}
+
+	c.ajaxSuccess(nil)
+}
+
+func (c *AdminController) PurgeTrash() {
+	var form trashForm
+	c.bindForm(&form)
+
+	var err error
+	switch form.Kind {
+	case models.TrashUser:
+		if err = models.PurgeUser(c.Ctx.Request.Context(), form.ID); err == nil {
+			if err = enforcer.PurgeUser(form.ID); err == models.ErrNotInTrash {
+				err = nil
+			}
+		}
+	case models.TrashRole:
+		err = enforcer.PurgeRole(uint(form.ID))
+	case models.TrashPermission:
+		err = enforcer.PurgePermission(uint(form.ID))
+	}
+	if err == models.ErrNotInTrash {
+		c.serveError(err)
+	} else if err != nil {
+		c.serveError(errcode.New(errcode.Internal, "purge_failed").WithCause(err))
+	}
+
+	c.ajaxSuccess(nil)
+}
+
+// Search find users by name and email, roles by name and permissions by name and resource
+func (c *AdminController) Search() {
+	var form searchForm
+	c.bindForm(&form)
+
+	ctx := c.Ctx.Request.Context()
+	var result searchResult
+	q := models.NewQuery(models.AdminUserQuerySchema, 0, searchLimit)
+	q.Keyword = form.Keyword
+	users, total, err := models.Users().List(ctx, q)
+	if err != nil {
+		c.serveError(err)
+	}
+	result.Users, result.UserTotal = userResps(users), total
+
+	q = models.NewQuery(models.RoleQuerySchema, 0, searchLimit)
+	q.Keyword = form.Keyword
+	if result.Roles, result.RoleTotal, err = enforcer.GetRoles(ctx, q); err != nil {
+		c.serveError(err)
+	}
+
+	q = models.NewQuery(models.PermissionQuerySchema, 0, searchLimit)
+	q.Keyword = form.Keyword
+	if result.Permissions, result.PermissionTotal, err = enforcer.SearchPermissions(ctx, q); err != nil {
+		c.serveError(err)
+	}
+	c.ajaxSuccess(result)
+}
@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/sirupsen/logrus"
+
+	"github.com/slover2000/beego_demo/errcode"
+	"github.com/slover2000/beego_demo/models"
+	"github.com/slover2000/beego_demo/patch"
+)
+
+const (
+	claimsKey      = "claims"
+	apiKeyDataKey  = "apikey"
+	apiKeyHeader   = "x-api-key"
+	clientIDHeader = "x-client-id"
+)
+
+// apiPublicPaths can be accessed without access token
+var apiPublicPaths = map[string]bool{
+	"/v1/user/login":   true,
+	"/v1/user/refresh": true,
+	"/v1/api/captcha":  true,
+}
+
+// apiController is the base of /v1 rest controllers which are authenticated by bearer token
+type apiController struct {
+	beego.Controller
+}
+
+func (c *apiController) Prepare() {
+	// api requests carry bearer token instead of cookie, so xsrf check is meaningless
+	c.EnableXSRF = false
+}
+
+// claims return the claims of access token which authenticated current request
+func (c *apiController) claims() *models.TokenClaims {
+	if claims, ok := c.Ctx.Input.GetData(claimsKey).(*models.TokenClaims); ok {
+		return claims
+	}
+	return nil
+}
+
+// serveError respond err in the error schema and stop running the handler
+func (c *apiController) serveError(err error) {
+	writeError(c.Ctx, err)
+	c.StopRun()
+}
+
+// serveJSON respond data with status 200
+func (c *apiController) serveJSON(data interface{}) {
+	writeJSON(c.Ctx, http.StatusOK, data)
+}
+
+// serveEntity respond a single resource with its version in ETag header
+func (c *apiController) serveEntity(version int64, data interface{}) {
+	c.Ctx.Output.Header("ETag", etag(version))
+	c.serveJSON(data)
+}
+
+// checkIfMatch compare If-Match header with the version of resource, the precondition is met without the header.
+// 412 is responded with the current representation of resource when they don't match
+func (c *apiController) checkIfMatch(version int64, current interface{}) bool {
+	header := c.Ctx.Input.Header("If-Match")
+	if header == "" || matchETag(header, etag(version)) {
+		return true
+	}
+	c.serveConflict(version, current)
+	return false
+}
+
+// requiredVersion return the version which client requires by If-Match, it's AnyVersion without the header
+func (c *apiController) requiredVersion(version int64) int64 {
+	if header := c.Ctx.Input.Header("If-Match"); header == "" || strings.TrimSpace(header) == "*" {
+		return models.AnyVersion
+	}
+	return version
+}
+
+// serveConflict respond 412 with the current representation and version of resource
+func (c *apiController) serveConflict(version int64, current interface{}) {
+	c.Ctx.Output.Header("ETag", etag(version))
+	c.serveError(errcode.New(errcode.VersionConflict, "").WithDetails(current))
+}
+
+// bindPatch apply the merge patch or json patch in request body to the json representation of current,
+// the patched document is bound to body and validated, members which body doesn't have are rejected
+func (c *apiController) bindPatch(current, body interface{}) bool {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		c.serveError(err)
+		return false
+	}
+	patched, err := patch.Apply(c.Ctx.Input.Header("Content-Type"), doc, c.Ctx.Input.RequestBody)
+	if err == patch.ErrUnsupportedType {
+		c.serveError(errcode.New(errcode.UnsupportedMedia, "").WithDetails([]string{patch.MergePatchType, patch.JSONPatchType}))
+		return false
+	}
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(patched))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(body)
+	}
+	if err != nil {
+		c.serveError(errcode.New(errcode.InvalidArgument, "invalid_patch").WithDetails(err.Error()))
+		return false
+	}
+	if err := validate(body); err != nil {
+		c.serveError(err)
+		return false
+	}
+	return true
+}
+
+// bindForm parse and validate form or query parameters of request
+func (c *apiController) bindForm(form interface{}) bool {
+	if err := parseForm(&c.Controller, form); err != nil {
+		c.serveError(err)
+		return false
+	}
+	return true
+}
+
+// bindJSON parse and validate json body of request
+func (c *apiController) bindJSON(body interface{}) bool {
+	if err := parseJSON(c.Ctx, body); err != nil {
+		c.serveError(err)
+		return false
+	}
+	return true
+}
+
+// parseQuery parse the filter, sort, fields, limit and cursor parameters of collection request
+func (c *apiController) parseQuery(schema *models.QuerySchema) (*models.Query, bool) {
+	q, err := models.ParseQuery(c.Ctx.Request.URL.Query(), schema)
+	if err == models.ErrInvalidCursor {
+		c.serveError(err)
+		return nil, false
+	}
+	if err != nil {
+		c.serveError(errcode.New(errcode.InvalidArgument, "invalid_query").WithDetails(err.Error()))
+		return nil, false
+	}
+	return q, true
+}
+
+// pageLink create the url of current request at cursor
+func (c *apiController) pageLink(cursor string) string {
+	u := *c.Ctx.Request.URL
+	values := u.Query()
+	values.Set("cursor", cursor)
+	u.RawQuery = values.Encode()
+	return u.RequestURI()
+}
+
+// servePage respond a page of collection, the total count and links of adjacent pages are set in headers
+func (c *apiController) servePage(q *models.Query, items interface{}, total int) {
+	links := make([]string, 0, 2)
+	if cursor := q.NextCursor(total); cursor != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, c.pageLink(cursor)))
+	}
+	if cursor := q.PrevCursor(); cursor != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, c.pageLink(cursor)))
+	}
+	if len(links) > 0 {
+		c.Ctx.Output.Header("Link", strings.Join(links, ", "))
+	}
+	c.Ctx.Output.Header("X-Total-Count", strconv.Itoa(total))
+
+	data, err := q.Project(items)
+	if err != nil {
+		c.serveError(err)
+		return
+	}
+	c.serveJSON(data)
+}
+
+// etag quote version of resource as a strong entity tag
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// matchETag check whether the If-Match header lists the entity tag, weak tags never match
+func matchETag(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func bearerToken(ctx *context.Context) string {
+	auth := ctx.Input.Header("Authorization")
+	if len(auth) > len(models.TokenTypeBearer) && strings.EqualFold(auth[:len(models.TokenTypeBearer)], models.TokenTypeBearer) {
+		return strings.TrimSpace(auth[len(models.TokenTypeBearer):])
+	}
+	return ""
+}
+
+// AuthenticateAPI is a filter which verifies bearer token or api key of /v1 requests and checks permission by enforcer
+func AuthenticateAPI(ctx *context.Context) {
+	path := strings.TrimRight(ctx.Request.URL.Path, "/")
+	if apiPublicPaths[path] {
+		return
+	}
+
+	var principal string
+	if key := ctx.Input.Header(apiKeyHeader); key != "" {
+		// machine clients authenticate by api key
+		k, err := models.VerifyAPIKey(ctx.Input.Header(clientIDHeader), key)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		principal = k.Principal()
+		ctx.Input.SetData(apiKeyDataKey, k)
+	} else {
+		token := bearerToken(ctx)
+		if token == "" {
+			writeError(ctx, errcode.New(errcode.Unauthorized, "token_required"))
+			return
+		}
+		claims, err := models.VerifyAccessToken(token)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		principal = claims.Name
+		ctx.Input.SetData(claimsKey, claims)
+	}
+
+	if !enforcer.Enforce(principal, ctx.Request.URL.Path, ctx.Request.Method) {
+		logrus.WithFields(logrus.Fields{
+			"user":   principal,
+			"path":   ctx.Request.URL.Path,
+			"method": ctx.Request.Method,
+		}).Warn("permission deny")
+		writeError(ctx, errcode.New(errcode.PermissionDenied, ""))
+		return
+	}
+}
@@ -2,23 +2,20 @@ package controllers
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/session"
 	//"github.com/casbin/beego-orm-adapter"
 	//"github.com/casbin/casbin"
-	"github.com/jinzhu/gorm"
 	"github.com/gogap/logrus"
 
+	"github.com/slover2000/beego_demo/errcode"
 	"github.com/slover2000/beego_demo/models"
 )
 
-const (
-	STATUS_OK              = 0
-	STATUS_PERMISSION_DENY = -1
-)
-
 type responseData struct {
 	Status  int         `json:"status"`
 	Message string      `json:"msg"`
@@ -43,36 +40,53 @@ var enforcer models.Enforcer
 var globalSessions *session.Manager
 var layoutSections map[string]string
 
-func initCasbinPolicy() {
-	// Initialize a Beego ORM adapter and use it in a Casbin enforcer:
-	// The adapter will use the Postgres database named "casbin".
-	// If it doesn't exist, the adapter will create it automatically.
-	dataSource := fmt.Sprintf(
-		"dbname=%s user=%s password=%s host=%s port=%d sslmode=disable",
-		beego.AppConfig.String("postgres.database"),
-		beego.AppConfig.String("postgres.user"),
-		beego.AppConfig.String("postgres.password"),
-		beego.AppConfig.String("postgres.host"),
-		beego.AppConfig.DefaultInt("postgres.port", 5432))
-	db, err := gorm.Open("postgres", dataSource)
-	if err != nil {
-		panic(err)
-	}		
-	//a := beegoormadapter.NewAdapter("postgres", dataSource, true) // Your driver and data source.
-	//enforcer = casbin.NewSyncedEnforcer("./conf/rbac_model.conf", a)
-	enforcer = models.NewSyncedEnforcer(db, true)
-	// Load the policy from DB.
-	enforcer.LoadPolicy()
+// Init wire controllers with the enforcer shared by application, it must be called before serving requests.
+// Background work like directory sync stops when stop is closed
+func Init(e models.Enforcer, stop <-chan struct{}) {
+	enforcer = e
+	initAuthenticators(stop)
+}
+
+func initAuthenticators(stop <-chan struct{}) {
+	if !beego.AppConfig.DefaultBool("ldap.enable", false) {
+		return
+	}
+
+	ldapAuth := models.NewLDAPAuthenticator(&models.LDAPConfig{
+		Addr:          beego.AppConfig.String("ldap.addr"),
+		UseTLS:        beego.AppConfig.DefaultBool("ldap.usetls", false),
+		StartTLS:      beego.AppConfig.DefaultBool("ldap.starttls", false),
+		SkipVerify:    beego.AppConfig.DefaultBool("ldap.skipverify", false),
+		Timeout:       time.Duration(beego.AppConfig.DefaultInt("ldap.timeout", 5)) * time.Second,
+		BindDN:        beego.AppConfig.String("ldap.binddn"),
+		BindPassword:  beego.AppConfig.String("ldap.bindpassword"),
+		BaseDN:        beego.AppConfig.String("ldap.basedn"),
+		UserFilter:    beego.AppConfig.String("ldap.userfilter"),
+		NameAttr:      beego.AppConfig.String("ldap.nameattr"),
+		EmailAttr:     beego.AppConfig.String("ldap.emailattr"),
+		GroupBaseDN:   beego.AppConfig.String("ldap.groupbasedn"),
+		GroupFilter:   beego.AppConfig.String("ldap.groupfilter"),
+		GroupNameAttr: beego.AppConfig.String("ldap.groupnameattr"),
+		GroupRoles:    models.ParseGroupRoleMapping(beego.AppConfig.String("ldap.rolemapping")),
+	}, enforcer)
+	// local users take precedence over directory users
+	models.SetAuthenticators(&models.LocalAuthenticator{}, ldapAuth)
+
+	if interval := beego.AppConfig.DefaultInt("ldap.syncinterval", 0); interval > 0 {
+		go ldapAuth.SyncPeriodically(time.Duration(interval)*time.Second, stop)
+	}
 }
 
 func initSessionManager() {
+	// idle timeout is enforced by session provider, absolute timeout is checked at every request
+	idleTimeout := sessionIdleTimeout()
 	sessionConfig := &session.ManagerConfig{
-		CookieName:      "sessionid",
+		CookieName:      beego.AppConfig.DefaultString("session.cookiename", "sessionid"),
 		EnableSetCookie: true,
-		Gclifetime:      3600,
-		Maxlifetime:     3600,
-		Secure:          false,
-		CookieLifeTime:  3600,
+		Gclifetime:      idleTimeout,
+		Maxlifetime:     idleTimeout,
+		Secure:          sessionSecure(),
+		CookieLifeTime:  0,
 		ProviderConfig:  "./tmp",
 	}
 	globalSessions, _ = session.NewManager("memory", sessionConfig)
@@ -83,7 +97,6 @@ func init() {
 	layoutSections = make(map[string]string)
 	layoutSections["MenuContent"] = "menu.html"
 	initSessionManager()
-	initCasbinPolicy()
 }
 
 func (c *baseController) Prepare() {
@@ -96,22 +109,12 @@ func (c *baseController) Prepare() {
 
 func (c *baseController) authenticate() bool {
 	req := c.Ctx.Request
-	resp := c.Ctx.ResponseWriter.ResponseWriter
-	sess, err := globalSessions.SessionStart(resp, req)
-	if err != nil {
-		c.Redirect(beego.URLFor("LoginController.ShowPage"), 302)
-		return false
-	}
-	defer sess.SessionRelease(resp)
-
-	id := sess.Get("uid")
-	name := sess.Get("name")
-	if id != nil {
-		c.userID = id.(int64)
-	}
-	if name != nil {
-		c.userName = name.(string)
+	uid, name, ok := sessionUser(c.Ctx)
+	if !ok {
+		uid, name, _ = restoreRememberedLogin(c.Ctx)
 	}
+	c.userID = uid
+	c.userName = name
 
 	// check permission
 	if !enforcer.Enforce(c.userName, req.URL.Path, req.Method) {
@@ -122,7 +125,7 @@ func (c *baseController) authenticate() bool {
 		}).Warn("permission deny")
 		
 		if c.IsAjax() {
-			c.ajaxFailure(STATUS_PERMISSION_DENY, "permission deny")
+			c.serveError(errcode.New(errcode.PermissionDenied, ""))
 		} else {
 			c.Redirect(beego.URLFor("LoginController.ShowPage"), 302)
 		}
@@ -163,6 +166,24 @@ func (c *baseController) setupUserMenu() {
 				Icon: "fa-list",
 				URL: "/admin/permissions",
 			})
+			subMenuItems = append(subMenuItems, models.SubmenuItem{
+				ID: 4,
+				Name: "API密钥",
+				Icon: "fa-key",
+				URL: "/admin/apikeys",
+			})
+			subMenuItems = append(subMenuItems, models.SubmenuItem{
+				ID: 5,
+				Name: "注册审核",
+				Icon: "fa-user-plus",
+				URL: "/admin/registrations",
+			})
+			subMenuItems = append(subMenuItems, models.SubmenuItem{
+				ID: 6,
+				Name: "回收站",
+				Icon: "fa-trash",
+				URL: "/admin/trash",
+			})
 			permissionMenu.Children = subMenuItems
 			menus = append(menus, permissionMenu)
 		}
@@ -181,6 +202,7 @@ func (c *baseController) renderTemplate(tpl string) {
 	c.Layout = "layout.html"
 	c.TplName = tplname
 	c.LayoutSections = layoutSections
+	c.Data["xsrf_token"] = c.XSRFToken()
 }
 
 func (c *baseController) renderNestedTemplate(tpl string) {
@@ -207,13 +229,25 @@ func (c *baseController) renderAjaxTemplate(tpl string) {
 }
 
 func (c *baseController) ajaxSuccess(data interface{}) {
-	c.Data["json"] = responseData{Status: STATUS_OK, Data: data}
-	c.ServeJSON()
+	writeJSON(c.Ctx, http.StatusOK, responseData{Status: int(errcode.OK), Message: "ok", Data: data})
 }
 
-func (c *baseController) ajaxFailure(errno int, errmsg string) {
-	c.Data["json"] = responseData{Status: errno, Message: errmsg}
-	c.ServeJSON()
+// serveTable respond a page of rows to layui table
+func (c *baseController) serveTable(rows interface{}, total int) {
+	writeJSON(c.Ctx, http.StatusOK, tableData{Status: int(errcode.OK), Message: "ok", Total: total, Rows: rows})
+}
+
+// serveError respond err and stop running the handler
+func (c *baseController) serveError(err error) {
+	writeError(c.Ctx, err)
+	c.StopRun()
+}
+
+// bindForm parse and validate the form of request, the invalid fields are responded
+func (c *baseController) bindForm(form interface{}) {
+	if err := parseForm(&c.Controller, form); err != nil {
+		c.serveError(err)
+	}
 }
 
 func (c *baseController) getClientIP() string {
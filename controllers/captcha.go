@@ -1,7 +1,7 @@
 package controllers
 
 import (
-	"encoding/json"
+	"net/http"
 	
 	"github.com/mojocn/base64Captcha"
 	"github.com/astaxie/beego"
@@ -34,17 +34,15 @@ func init() {
 // @Title Generate base64 encoding image data
 // @Description get base64 encoding captcha
 // @Success 200 base64 encoding captcha image string
-// @Failure 404 can't generate captcha image string
+// @Failure 404 {object} errcode.Body can't generate captcha image string
 // @router /captcha [get]
 func (u *CaptchaController) GenerateCaptcha() {	
 	//GenerateCaptcha 第一个参数为空字符串,包会自动在服务器一个随机种子给你产生随机uiid.
 	captchaId, captchaData := base64Captcha.GenerateCaptcha("", *captchaConfig)
 	base64Png := base64Captcha.CaptchaWriteToBase64Encoding(captchaData)
 
-	resp := u.Ctx.ResponseWriter.ResponseWriter
-	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
 	body := map[string]interface{}{"code": 0, "data": base64Png, "captchaId": captchaId, "msg": "success"}
-	json.NewEncoder(resp).Encode(body)
+	writeJSON(u.Ctx, http.StatusOK, body)
 }
 
 func VerifyCaptcha(captchaId, captchaValue string) bool {
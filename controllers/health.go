@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/sirupsen/logrus"
+	netcontext "golang.org/x/net/context"
+
+	"github.com/slover2000/beego_demo/errcode"
+)
+
+// healthCheckTimeout bounds every ping of health check so a hung database doesn't hang load balancers
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck is the handler of health check used by load balancers, it fails when primary is unreachable
+// and reports degraded when only replicas are unreachable since reads still work on primary
+func HealthCheck(ping, pingReplicas func(netcontext.Context) error) beego.FilterFunc {
+	return func(ctx *context.Context) {
+		if err := withTimeout(ctx, ping); err != nil {
+			writeError(ctx, errcode.New(errcode.Internal, "").WithCause(err))
+			return
+		}
+		key := ""
+		if err := withTimeout(ctx, pingReplicas); err != nil {
+			logrus.WithField("request_id", requestID(ctx)).Warnf("health check degraded:%v", err)
+			key = "degraded"
+		}
+		writeJSON(ctx, 200, errcode.New(errcode.OK, key).Body(errcode.Language(ctx.Input.Header("Accept-Language")), requestID(ctx)))
+	}
+}
+
+// withTimeout run ping bound to the request which is cancelled after healthCheckTimeout
+func withTimeout(ctx *context.Context, ping func(netcontext.Context) error) error {
+	c, cancel := netcontext.WithTimeout(ctx.Request.Context(), healthCheckTimeout)
+	defer cancel()
+	return ping(c)
+}
@@ -1,11 +1,28 @@
 package controllers
 
 import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
 	"strings"
+	"time"
 	"html/template"
 
 	"github.com/astaxie/beego"
+	"github.com/sirupsen/logrus"
+
 	"github.com/slover2000/beego_demo/models"
+	"github.com/slover2000/beego_demo/services"
+)
+
+// userNamePattern is the same rule as the user forms of admin console
+var userNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_\p{Han}]{3,32}$`)
+
+const (
+	ssoStateKey    = "sso_state"
+	ssoNonceKey    = "sso_nonce"
+	ssoVerifierKey = "sso_verifier"
 )
 
 // LoginController login controller
@@ -28,11 +45,9 @@ func (c *LoginController) Login() {
 		if err != nil {
 			errorMsg = "帐号或密码错误"
 		} else {
-			sess, err := globalSessions.SessionStart(c.Ctx.ResponseWriter.ResponseWriter, c.Ctx.Request)
-			if err == nil {
-				defer sess.SessionRelease(c.Ctx.ResponseWriter.ResponseWriter)
-				sess.Set("uid", user.Id)
-				sess.Set("name", user.Name)				
+			startLoginSession(c.Ctx, user.Id, user.Name)
+			if remember, _ := c.GetBool("remember"); remember {
+				setRememberCookie(c.Ctx, user.Id, user.Name)
 			}
 		}
 
@@ -55,16 +70,266 @@ func (c *LoginController) Login() {
 
 // Logout user log out from system
 func (c *LoginController) Logout() {
+	clearRememberCookie(c.Ctx)
 	globalSessions.SessionDestroy(c.Ctx.ResponseWriter.ResponseWriter, c.Ctx.Request)	
 	c.Redirect(beego.URLFor("LoginController.ShowPage"), 302)
 }
 
+// LogoutEverywhere sign out all sessions and persistent logins of current user
+func (c *LoginController) LogoutEverywhere() {
+	if uid, name, ok := sessionUser(c.Ctx); ok {
+		if err := models.SignOutEverywhere(uid); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"user": name,
+				"path": c.Ctx.Request.URL.Path,
+			}).Errorf("sign out everywhere failed:%v", err)
+		}
+	}
+	c.Logout()
+}
+
 func (c *LoginController) ShowPage() {
 	beego.ReadFromRequest(&c.Controller)
 	c.Data["xsrfdata"] = template.HTML(c.XSRFFormHTML())
+	c.Data["ssoEnabled"] = services.OIDCEnabled()
+	c.Data["registerEnabled"] = registrationEnabled()
 	c.TplName = "login.html"
 }
 
+func registrationEnabled() bool {
+	return beego.AppConfig.DefaultBool("register.enable", false)
+}
+
+// ShowRegister show the self registration page
+func (c *LoginController) ShowRegister() {
+	if !registrationEnabled() {
+		c.Abort("404")
+	}
+	beego.ReadFromRequest(&c.Controller)
+	c.Data["xsrfdata"] = template.HTML(c.XSRFFormHTML())
+	c.TplName = "register.html"
+}
+
+func (c *LoginController) registerFailure(errmsg string) {
+	flash := beego.NewFlash()
+	flash.Error(errmsg)
+	flash.Store(&c.Controller)
+	c.Redirect(beego.URLFor("LoginController.ShowRegister"), 302)
+}
+
+// validEmail accept a bare address like "alice@example.com", names and line breaks are rejected
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Name == "" && addr.Address == email && len(email) <= 100
+}
+
+// Register create a registration and send verification mail to its email
 func (c *LoginController) Register() {
+	if !registrationEnabled() {
+		c.Abort("404")
+	}
+
+	username := strings.TrimSpace(c.GetString("username"))
+	password := c.GetString("password")
+	email := strings.TrimSpace(c.GetString("email"))
+	if !userNamePattern.MatchString(username) || len(password) < 6 || len(password) > 64 || !validEmail(email) {
+		c.registerFailure("请填写正确的用户名、密码和邮箱")
+		return
+	}
+
+	lifetime := time.Duration(beego.AppConfig.DefaultInt("register.expire", 24*3600)) * time.Second
+	r, token, err := models.CreateRegistration(username, password, email, lifetime)
+	if err == models.ErrUserNameExists {
+		c.registerFailure("用户名已存在")
+		return
+	} else if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user": username,
+			"path": c.Ctx.Request.URL.Path,
+		}).Errorf("create registration failed:%v", err)
+		c.registerFailure("注册失败")
+		return
+	}
+
+	link := beego.AppConfig.String("register.verifyurl") + "?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("%s，您好：\n\n请在%d小时内打开以下链接验证邮箱完成注册：\n%s\n", r.Name, int(lifetime/time.Hour), link)
+	if err := services.SendMail([]string{r.Email}, beego.AppConfig.String("site.name")+" 注册验证", body); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user": username,
+			"path": c.Ctx.Request.URL.Path,
+		}).Errorf("send verification mail failed:%v", err)
+		c.registerFailure("验证邮件发送失败")
+		return
+	}
+
+	flash := beego.NewFlash()
+	flash.Notice("验证邮件已发送至" + r.Email + "，请查收")
+	flash.Store(&c.Controller)
+	c.Redirect(beego.URLFor("LoginController.ShowRegister"), 302)
+}
+
+// VerifyRegistration verify email of registration, the user is activated unless admin approval is required
+func (c *LoginController) VerifyRegistration() {
+	if !registrationEnabled() {
+		c.Abort("404")
+	}
+
+	approval := beego.AppConfig.DefaultBool("register.approval", false)
+	r, err := models.VerifyRegistration(c.GetString("token"), approval)
+	if err != nil {
+		c.registerFailure("验证链接无效或已过期")
+		return
+	}
 
-}
\ No newline at end of file
+	flash := beego.NewFlash()
+	if approval {
+		flash.Notice("邮箱验证成功，请等待管理员审核")
+	} else {
+		if _, err := activateRegistration(r); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"user": r.Name,
+				"path": c.Ctx.Request.URL.Path,
+			}).Errorf("activate registration failed:%v", err)
+			c.registerFailure("激活用户失败")
+			return
+		}
+		flash.Notice("注册成功，请登录")
+	}
+	flash.Store(&c.Controller)
+	c.Redirect(beego.URLFor("LoginController.ShowRegister"), 302)
+}
+
+// activateRegistration create user of registration with the default role
+func activateRegistration(r *models.Registration) (*models.User, error) {
+	user, err := models.ActivateRegistration(r)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]uint, 0)
+	if name := beego.AppConfig.String("register.defaultrole"); name != "" {
+		for _, role := range enforcer.GetAllRoles() {
+			if role.Name == name {
+				roles = append(roles, role.ID)
+			}
+		}
+	}
+	return user, enforcer.SaveUser(&models.CasbinUser{ID: user.Id, Name: user.Name}, roles)
+}
+
+func (c *LoginController) ssoFailure(errmsg string) {
+	flash := beego.NewFlash()
+	flash.Error(errmsg)
+	flash.Store(&c.Controller)
+	c.Redirect(beego.URLFor("LoginController.ShowPage"), 302)
+}
+
+// SSOLogin redirect user to identity provider
+func (c *LoginController) SSOLogin() {
+	provider, err := services.GetOIDCProvider()
+	if err != nil {
+		c.ssoFailure("单点登录未开启")
+		return
+	}
+	req, err := provider.AuthCodeURL()
+	if err != nil {
+		c.ssoFailure("单点登录失败")
+		return
+	}
+
+	resp := c.Ctx.ResponseWriter.ResponseWriter
+	sess, err := globalSessions.SessionStart(resp, c.Ctx.Request)
+	if err != nil {
+		c.ssoFailure("单点登录失败")
+		return
+	}
+	sess.Set(ssoStateKey, req.State)
+	sess.Set(ssoNonceKey, req.Nonce)
+	sess.Set(ssoVerifierKey, req.CodeVerifier)
+	sess.SessionRelease(resp)
+	c.Redirect(req.URL, 302)
+}
+
+// SSOCallback finish single sign-on, the user is created at its first login
+func (c *LoginController) SSOCallback() {
+	provider, err := services.GetOIDCProvider()
+	if err != nil {
+		c.ssoFailure("单点登录未开启")
+		return
+	}
+
+	resp := c.Ctx.ResponseWriter.ResponseWriter
+	sess, err := globalSessions.SessionStart(resp, c.Ctx.Request)
+	if err != nil {
+		c.ssoFailure("单点登录失败")
+		return
+	}
+	state, _ := sess.Get(ssoStateKey).(string)
+	nonce, _ := sess.Get(ssoNonceKey).(string)
+	verifier, _ := sess.Get(ssoVerifierKey).(string)
+	sess.Delete(ssoStateKey)
+	sess.Delete(ssoNonceKey)
+	sess.Delete(ssoVerifierKey)
+	sess.SessionRelease(resp)
+	if state == "" || state != c.GetString("state") {
+		c.ssoFailure("单点登录状态无效")
+		return
+	}
+	if errcode := c.GetString("error"); errcode != "" {
+		c.ssoFailure(errcode)
+		return
+	}
+
+	identity, err := provider.Exchange(c.Ctx.Request.Context(), c.GetString("code"), &services.OIDCAuthRequest{
+		State: state,
+		Nonce: nonce,
+		CodeVerifier: verifier,
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"path": c.Ctx.Request.URL.Path,
+		}).Errorf("exchange authorization code failed:%v", err)
+		c.ssoFailure("单点登录验证失败")
+		return
+	}
+
+	// users are linked by the account of identity provider, the name can't claim a local user
+	user, created, err := models.GetOrCreateExternalUser(c.Ctx.Request.Context(), identity.Issuer, identity.Subject, identity.Name, identity.Email)
+	if err == models.ErrUserNameExists {
+		c.ssoFailure("用户名已被其他帐号占用，请联系管理员")
+		return
+	} else if err == models.ErrUserNotFound {
+		c.ssoFailure("帐号已被删除")
+		return
+	} else if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user": identity.Name,
+			"path": c.Ctx.Request.URL.Path,
+		}).Errorf("create sso user failed:%v", err)
+		c.ssoFailure("单点登录创建用户失败")
+		return
+	}
+
+	// roles follow the groups of identity provider when mapping is configured and any group is mapped
+	roles := ssoRoles(identity.Groups)
+	if created || roles != nil {
+		if roles == nil {
+			roles = []uint{}
+		}
+		enforcer.SaveUser(&models.CasbinUser{ID: user.Id, Name: user.Name}, roles)
+	}
+
+	startLoginSession(c.Ctx, user.Id, user.Name)
+	c.Redirect(beego.URLFor("HomeController.Index"), 302)
+}
+
+// ssoRoles map groups of identity provider to roles by oidc.rolemapping, e.g. "idp-admins:admin;devs:developer",
+// nil is returned without mapping or when no group is mapped
+func ssoRoles(groups []string) []uint {
+	mapping := beego.AppConfig.String("oidc.rolemapping")
+	if mapping == "" {
+		return nil
+	}
+
+	return models.MapGroupsToRoles(enforcer, models.ParseGroupRoleMapping(mapping), groups)
+}
@@ -2,91 +2,210 @@ package controllers
 
 import (
 	"github.com/slover2000/beego_demo/models"
-	"encoding/json"
-
-	"github.com/astaxie/beego"
 )
 
+// objectRepository backs the /v1/object endpoints, it's nil until SetObjectRepository is called
+var objectRepository models.ObjectRepository
+
+// SetObjectRepository set the storage of /v1/object endpoints
+func SetObjectRepository(r models.ObjectRepository) {
+	objectRepository = r
+}
+
+// objects return the store of objects set by SetObjectRepository
+func objects() models.ObjectRepository {
+	if objectRepository == nil {
+		panic("controllers: object repository isn't set, SetObjectRepository must be called at startup")
+	}
+	return objectRepository
+}
+
+// objectPatchBody is the document which patches of object apply to
+type objectPatchBody struct {
+	Score      int64
+	PlayerName string `valid:"MaxSize(64)"`
+}
+
 // ObjectController Operations about object
 type ObjectController struct {
-	beego.Controller
+	apiController
+}
+
+// serveUpdateError respond failure of changing object, the current object is responded on version conflict
+func (o *ObjectController) serveUpdateError(id string, err error) {
+	if err == models.ErrVersionConflict {
+		if current, e := objects().Get(models.OnPrimary(o.Ctx.Request.Context()), id); e == nil {
+			o.serveConflict(current.Version, current)
+			return
+		}
+	}
+	o.serveError(err)
 }
 
 // @Title Create
 // @Description create object
 // @Param	body		body 	models.Object	true		"The object content"
 // @Success 200 {string} models.Object.Id
-// @Failure 403 body is empty
+// @Failure 400 {object} errcode.Body body is invalid
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router / [post]
 func (o *ObjectController) Post() {
 	var ob models.Object
-	json.Unmarshal(o.Ctx.Input.RequestBody, &ob)
-	objectid := models.AddOne(ob)
-	o.Data["json"] = map[string]string{"ObjectId": objectid}
-	o.ServeJSON()
+	if !o.bindJSON(&ob) {
+		return
+	}
+	if err := objects().Create(o.Ctx.Request.Context(), &ob); err != nil {
+		o.serveError(err)
+		return
+	}
+	o.serveJSON(map[string]string{"ObjectId": ob.ObjectId})
 }
 
 // @Title Get
 // @Description find object by objectid
 // @Param	objectId		path 	string	true		"the objectid you want to get"
 // @Success 200 {object} models.Object
-// @Failure 403 :objectId is empty
+// @Failure 404 {object} errcode.Body object doesn't exist
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router /:objectId [get]
 func (o *ObjectController) Get() {
 	objectId := o.Ctx.Input.Param(":objectId")
-	if objectId != "" {
-		ob, err := models.GetOne(objectId)
-		if err != nil {
-			o.Data["json"] = err.Error()
-		} else {
-			o.Data["json"] = ob
-		}
+	ob, err := objects().Get(o.Ctx.Request.Context(), objectId)
+	if err != nil {
+		o.serveError(err)
+		return
 	}
-	o.ServeJSON()
+	o.serveEntity(ob.Version, ob)
 }
 
 // @Title GetAll
-// @Description get all objects
+// @Description get objects by page
+// @Param	filter	query	string	false	"Comma separated conditions on id, score and player_name, e.g. score>100,player_name=astaxie"
+// @Param	sort	query	string	false	"Comma separated sort fields, '-' prefix sorts descending, e.g. -score"
+// @Param	fields	query	string	false	"Comma separated fields returned, e.g. id,score"
+// @Param	limit	query	int	false	"The max number of objects, 20 by default and 100 at most"
+// @Param	cursor	query	string	false	"The cursor of page from Link header"
 // @Success 200 {object} models.Object
-// @Failure 403 :objectId is empty
+// @Failure 400 {object} errcode.Body query is invalid
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router / [get]
 func (o *ObjectController) GetAll() {
-	obs := models.GetAll()
-	o.Data["json"] = obs
-	o.ServeJSON()
+	q, ok := o.parseQuery(models.ObjectQuerySchema)
+	if !ok {
+		return
+	}
+	obs, total, err := objects().List(o.Ctx.Request.Context(), q)
+	if err != nil {
+		o.serveError(err)
+		return
+	}
+	o.servePage(q, obs, total)
 }
 
 // @Title Update
 // @Description update the object
 // @Param	objectId		path 	string	true		"The objectid you want to update"
+// @Param	If-Match	header	string	false	"The ETag of object which the update is based on"
 // @Param	body		body 	models.Object	true		"The body"
 // @Success 200 {object} models.Object
-// @Failure 403 :objectId is empty
+// @Failure 400 {object} errcode.Body body is invalid
+// @Failure 404 {object} errcode.Body object doesn't exist
+// @Failure 412 {object} errcode.Body object has been modified, details is the current object
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router /:objectId [put]
 func (o *ObjectController) Put() {
-	objectId := o.Ctx.Input.Param(":objectId")
-	var ob models.Object
-	json.Unmarshal(o.Ctx.Input.RequestBody, &ob)
+	// the version is checked against primary, a replica may lag behind it
+	ctx := models.OnPrimary(o.Ctx.Request.Context())
+	ob, err := objects().Get(ctx, o.Ctx.Input.Param(":objectId"))
+	if err != nil {
+		o.serveError(err)
+		return
+	}
+	if !o.checkIfMatch(ob.Version, ob) {
+		return
+	}
+
+	var update models.Object
+	if !o.bindJSON(&update) {
+		return
+	}
+	ob.Score = update.Score
+	if update.PlayerName != "" {
+		ob.PlayerName = update.PlayerName
+	}
+	if err := objects().Update(ctx, ob); err != nil {
+		o.serveUpdateError(ob.ObjectId, err)
+		return
+	}
+	o.serveEntity(ob.Version, ob)
+}
 
-	err := models.Update(objectId, ob.Score)
+// @Title Patch
+// @Description patch the object by merge patch or json patch, the patches apply to {"Score", "PlayerName"}
+// @Param	objectId		path 	string	true		"The objectid you want to patch"
+// @Param	Content-Type	header	string	true	"application/merge-patch+json or application/json-patch+json"
+// @Param	If-Match	header	string	false	"The ETag of object which the patch is based on"
+// @Param	body		body 	string	true		"The patch document"
+// @Success 200 {object} models.Object
+// @Failure 400 {object} errcode.Body patch is invalid
+// @Failure 404 {object} errcode.Body object doesn't exist
+// @Failure 412 {object} errcode.Body object has been modified, details is the current object
+// @Failure 415 {object} errcode.Body content type isn't a patch document
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
+// @router /:objectId [patch]
+func (o *ObjectController) Patch() {
+	ctx := models.OnPrimary(o.Ctx.Request.Context())
+	ob, err := objects().Get(ctx, o.Ctx.Input.Param(":objectId"))
 	if err != nil {
-		o.Data["json"] = err.Error()
-	} else {
-		o.Data["json"] = "update success!"
+		o.serveError(err)
+		return
+	}
+	if !o.checkIfMatch(ob.Version, ob) {
+		return
+	}
+
+	var body objectPatchBody
+	if !o.bindPatch(&objectPatchBody{Score: ob.Score, PlayerName: ob.PlayerName}, &body) {
+		return
+	}
+	ob.Score = body.Score
+	ob.PlayerName = body.PlayerName
+	if err := objects().Update(ctx, ob); err != nil {
+		o.serveUpdateError(ob.ObjectId, err)
+		return
 	}
-	o.ServeJSON()
+	o.serveEntity(ob.Version, ob)
 }
 
 // @Title Delete
 // @Description delete the object
 // @Param	objectId		path 	string	true		"The objectId you want to delete"
+// @Param	If-Match	header	string	false	"The ETag of object which is deleted"
 // @Success 200 {string} delete success!
-// @Failure 403 objectId is empty
+// @Failure 404 {object} errcode.Body object doesn't exist
+// @Failure 412 {object} errcode.Body object has been modified, details is the current object
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router /:objectId [delete]
 func (o *ObjectController) Delete() {
-	objectId := o.Ctx.Input.Param(":objectId")
-	models.Delete(objectId)
-	o.Data["json"] = "delete success!"
-	o.ServeJSON()
+	ctx := models.OnPrimary(o.Ctx.Request.Context())
+	ob, err := objects().Get(ctx, o.Ctx.Input.Param(":objectId"))
+	if err != nil {
+		o.serveError(err)
+		return
+	}
+	if !o.checkIfMatch(ob.Version, ob) {
+		return
+	}
+	if err := objects().Delete(ctx, ob.ObjectId, o.requiredVersion(ob.Version)); err != nil {
+		o.serveUpdateError(ob.ObjectId, err)
+		return
+	}
+	o.serveJSON("delete success!")
 }
 
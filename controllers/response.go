@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/astaxie/beego/context"
+	"github.com/sirupsen/logrus"
+
+	"github.com/slover2000/beego_demo/dao"
+	"github.com/slover2000/beego_demo/errcode"
+	"github.com/slover2000/beego_demo/models"
+	"github.com/slover2000/beego_demo/services"
+)
+
+const (
+	requestIDKey    = "request_id"
+	requestIDHeader = "X-Request-Id"
+)
+
+// modelErrors translate errors of models, services and dao to typed errors
+var modelErrors = map[error]*errcode.Error{
+	models.ErrUserNotFound:    errcode.New(errcode.NotFound, "user_not_found"),
+	models.ErrUserNameExists:  errcode.New(errcode.AlreadyExists, "user_name_exists"),
+	models.ErrInvalidUserName: errcode.New(errcode.InvalidArgument, "invalid_user_name"),
+	models.ErrObjectNotFound:  errcode.New(errcode.NotFound, "object_not_found"),
+	models.ErrWrongPassword:   errcode.New(errcode.Unauthorized, "invalid_credentials"),
+	models.ErrInvalidToken:    errcode.New(errcode.Unauthorized, "token_invalid"),
+	models.ErrTokenRevoked:    errcode.New(errcode.Unauthorized, "token_invalid"),
+	models.ErrInvalidAPIKey:   errcode.New(errcode.Unauthorized, "apikey_invalid"),
+	models.ErrAPIKeyExpired:   errcode.New(errcode.Unauthorized, "apikey_invalid"),
+	models.ErrInvalidCursor:   errcode.New(errcode.InvalidArgument, "invalid_cursor"),
+	models.ErrVersionConflict: errcode.New(errcode.VersionConflict, ""),
+	models.ErrNotInTrash:      errcode.New(errcode.NotFound, "not_in_trash"),
+
+	services.ErrSearchUnavailable: errcode.New(errcode.Unavailable, "search_unavailable"),
+	services.ErrInvalidSort:       errcode.New(errcode.InvalidArgument, "invalid_sort"),
+
+	dao.ErrTimeout:      errcode.New(errcode.Unavailable, ""),
+	dao.ErrDuplicateKey: errcode.New(errcode.AlreadyExists, ""),
+}
+
+// AssignRequestID is a filter which takes request id from header or generates one, the id is echoed in response header
+func AssignRequestID(ctx *context.Context) {
+	id := ctx.Input.Header(requestIDHeader)
+	if id == "" || len(id) > 64 {
+		buf := make([]byte, 12)
+		rand.Read(buf)
+		id = hex.EncodeToString(buf)
+	}
+	ctx.Input.SetData(requestIDKey, id)
+	ctx.Output.Header(requestIDHeader, id)
+}
+
+func requestID(ctx *context.Context) string {
+	id, _ := ctx.Input.GetData(requestIDKey).(string)
+	return id
+}
+
+// toError convert err to typed error, the known errors of models are translated
+func toError(err error) *errcode.Error {
+	if e, ok := modelErrors[err]; ok {
+		return e.WithCause(err)
+	}
+	return errcode.From(err)
+}
+
+// writeJSON is the single writer of json responses
+func writeJSON(ctx *context.Context, status int, body interface{}) {
+	ctx.Output.SetStatus(status)
+	ctx.Output.JSON(body, false, false)
+}
+
+// writeError respond err with its http status and the message in language of request
+func writeError(ctx *context.Context, err error) {
+	e := toError(err)
+	entry := logrus.WithFields(logrus.Fields{
+		"path":       ctx.Request.URL.Path,
+		"method":     ctx.Request.Method,
+		"code":       e.Code,
+		"request_id": requestID(ctx),
+	})
+	if e.Status() >= 500 {
+		entry.Errorf("request failed:%v", e)
+	} else {
+		entry.Infof("request rejected:%v", e)
+	}
+
+	lang := errcode.Language(ctx.Input.Header("Accept-Language"))
+	writeJSON(ctx, e.Status(), e.Body(lang, requestID(ctx)))
+}
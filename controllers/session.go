@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/sirupsen/logrus"
+
+	"github.com/slover2000/beego_demo/models"
+)
+
+const (
+	sessionUIDKey     = "uid"
+	sessionNameKey    = "name"
+	sessionLoginAtKey = "login_at"
+	rememberCookie    = "remember"
+)
+
+// sessionIdleTimeout is the seconds a session lives without requests
+func sessionIdleTimeout() int64 {
+	return beego.AppConfig.DefaultInt64("session.idletimeout", 1800)
+}
+
+// sessionAbsoluteTimeout is the longest time a session lives after login whatever it's active
+func sessionAbsoluteTimeout() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("session.absolutetimeout", 12*3600)) * time.Second
+}
+
+func rememberLifetime() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("session.rememberexpire", 30*24*3600)) * time.Second
+}
+
+func sessionSecure() bool {
+	return beego.AppConfig.DefaultBool("session.secure", false)
+}
+
+// startLoginSession bind user to a new session id, the old id is dropped to prevent session fixation
+func startLoginSession(ctx *context.Context, uid int64, name string) {
+	resp := ctx.ResponseWriter.ResponseWriter
+	sess := globalSessions.SessionRegenerateID(resp, ctx.Request)
+	defer sess.SessionRelease(resp)
+	sess.Set(sessionUIDKey, uid)
+	sess.Set(sessionNameKey, name)
+	sess.Set(sessionLoginAtKey, time.Now().UnixNano())
+}
+
+// sessionUser return the user of session, the expired or revoked session is cleared
+func sessionUser(ctx *context.Context) (int64, string, bool) {
+	resp := ctx.ResponseWriter.ResponseWriter
+	sess, err := globalSessions.SessionStart(resp, ctx.Request)
+	if err != nil {
+		return 0, "", false
+	}
+	defer sess.SessionRelease(resp)
+
+	uid, ok1 := sess.Get(sessionUIDKey).(int64)
+	name, ok2 := sess.Get(sessionNameKey).(string)
+	loginAt, ok3 := sess.Get(sessionLoginAtKey).(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return 0, "", false
+	}
+
+	login := time.Unix(0, loginAt)
+	if time.Since(login) > sessionAbsoluteTimeout() || models.SessionRevoked(uid, login) {
+		sess.Delete(sessionUIDKey)
+		sess.Delete(sessionNameKey)
+		sess.Delete(sessionLoginAtKey)
+		return 0, "", false
+	}
+	return uid, name, true
+}
+
+// setRememberCookie issue a persistent login for user
+func setRememberCookie(ctx *context.Context, uid int64, name string) {
+	lifetime := rememberLifetime()
+	value, err := models.IssueRememberToken(uid, name, lifetime)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user": name,
+			"path": ctx.Request.URL.Path,
+		}).Errorf("issue remember-me token failed:%v", err)
+		return
+	}
+	ctx.SetCookie(rememberCookie, value, int(lifetime/time.Second), "/", "", sessionSecure(), true)
+}
+
+func clearRememberCookie(ctx *context.Context) {
+	if value := ctx.GetCookie(rememberCookie); value != "" {
+		models.DeleteRememberToken(value)
+		ctx.SetCookie(rememberCookie, "", -1, "/", "", sessionSecure(), true)
+	}
+}
+
+// restoreRememberedLogin start a new session by the persistent login cookie, its token is rotated
+func restoreRememberedLogin(ctx *context.Context) (int64, string, bool) {
+	value := ctx.GetCookie(rememberCookie)
+	if value == "" {
+		return 0, "", false
+	}
+
+	lifetime := rememberLifetime()
+	record, newValue, err := models.UseRememberToken(value, lifetime)
+	if err != nil {
+		if err == models.ErrRememberTokenTheft {
+			logrus.WithFields(logrus.Fields{
+				"path": ctx.Request.URL.Path,
+				"ip":   ctx.Input.IP(),
+			}).Warn("stale remember-me token is used, persistent logins are removed")
+		}
+		ctx.SetCookie(rememberCookie, "", -1, "/", "", sessionSecure(), true)
+		return 0, "", false
+	}
+
+	ctx.SetCookie(rememberCookie, newValue, int(lifetime/time.Second), "/", "", sessionSecure(), true)
+	startLoginSession(ctx, record.UserID, record.Name)
+	return record.UserID, record.Name, true
+}
@@ -1,123 +1,432 @@
 package controllers
 
 import (
+	"fmt"
 	"strconv"
-	"encoding/json"
-	"github.com/astaxie/beego"
-	
-	"github.com/slover2000/beego_demo/dao"
+	"time"
+
+	"github.com/astaxie/beego/validation"
+
+	"github.com/slover2000/beego_demo/errcode"
 	"github.com/slover2000/beego_demo/models"
 	"github.com/slover2000/beego_demo/services"
 )
 
 // UserController Operations about Users
 type UserController struct {
-	beego.Controller
+	apiController
+}
+
+// createUserBody is the json body of creating user
+type createUserBody struct {
+	Name     string `valid:"Required;MinSize(3);MaxSize(32)"`
+	Password string `valid:"Required;MinSize(6);MaxSize(64)"`
+	Profile  models.Profile
+}
+
+// updateUserBody is the json body of updating user, only the provided fields are changed
+type updateUserBody struct {
+	Name     string `valid:"MaxSize(32)"`
+	Password string `valid:"MaxSize(64)"`
+	Profile  models.Profile
+}
+
+// userPatchBody is the document which patches of user apply to, password is absent until it's added
+type userPatchBody struct {
+	Name     string         `valid:"Required;MinSize(3);MaxSize(32)"`
+	Password string         `json:",omitempty" valid:"MaxSize(64)"`
+	Profile  models.Profile
+}
+
+type loginForm struct {
+	Username string `form:"username" valid:"Required"`
+	Password string `form:"password" valid:"Required"`
+}
+
+// searchUserForm is the query of searching users, dates are in format 2006-01-02
+type searchUserForm struct {
+	Q             string    `form:"q" valid:"MaxSize(128)"`
+	Gender        string    `form:"gender" valid:"MaxSize(16)"`
+	MinAge        int       `form:"min_age" valid:"Min(0)"`
+	MaxAge        int       `form:"max_age" valid:"Min(0)"`
+	CreatedAfter  time.Time `form:"created_after"`
+	CreatedBefore time.Time `form:"created_before"`
+	Sort          string    `form:"sort"`
+	Offset        int       `form:"offset" valid:"Range(0,10000)"`
+	Limit         int       `form:"limit" valid:"Range(0,100)"`
+}
+
+// the default page size of search, and the max_result_window of elasticsearch which pages can't reach beyond
+const (
+	defaultSearchLimit = 20
+	maxSearchWindow    = 10000
+)
+
+// Valid check the page of search is within the search window
+func (f *searchUserForm) Valid(v *validation.Validation) {
+	limit := f.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	if f.Offset+limit > maxSearchWindow {
+		v.SetError("Offset", fmt.Sprintf("offset plus limit can't exceed %d", maxSearchWindow))
+	}
+}
+
+type refreshForm struct {
+	RefreshToken string `form:"refresh_token" valid:"Required"`
+}
+
+// publicUser hide the password hash of user from response
+func publicUser(user *models.User) *models.User {
+	user.Password = ""
+	return user
+}
+
+func (u *UserController) userID() (int64, bool) {
+	uid, err := strconv.ParseInt(u.GetString(":uid"), 10, 64)
+	if err != nil {
+		u.serveError(errcode.InvalidParameter("uid"))
+		return 0, false
+	}
+	return uid, true
+}
+
+// serveUpdateError respond failure of changing user, the current user is responded on version conflict
+func (u *UserController) serveUpdateError(uid int64, err error) {
+	if err == models.ErrVersionConflict {
+		if current, e := models.Users().Get(models.OnPrimary(u.Ctx.Request.Context()), uid); e == nil {
+			u.serveConflict(current.Version(), publicUser(current))
+			return
+		}
+	}
+	u.serveError(err)
 }
 
 // @Title CreateUser
 // @Description create users
 // @Param	body		body 	models.User	true		"body for user content"
 // @Success 200 {int} models.User.Id
-// @Failure 403 body is empty
+// @Failure 400 {object} errcode.Body body is invalid
+// @Failure 409 {object} errcode.Body user name already exists
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router / [post]
 func (u *UserController) Post() {
-	var user models.User
-	json.Unmarshal(u.Ctx.Input.RequestBody, &user)
-	uid := models.AddUser(user)
-	u.Data["json"] = map[string]string{"uid": strconv.FormatInt(uid, 10)}
-	u.ServeJSON()
+	var body createUserBody
+	if !u.bindJSON(&body) {
+		return
+	}
+	user := models.User{Name: body.Name, Password: body.Password, Profile: body.Profile}
+	if err := models.Users().Create(u.Ctx.Request.Context(), &user); err != nil {
+		u.serveError(err)
+		return
+	}
+	u.serveJSON(map[string]string{"uid": strconv.FormatInt(user.Id, 10)})
 }
 
 // @Title GetAll
-// @Description get all Users
+// @Description get users by page
+// @Param	filter	query	string	false	"Comma separated conditions on id, name, create_time and update_time, e.g. id>100"
+// @Param	sort	query	string	false	"Comma separated sort fields, '-' prefix sorts descending, e.g. -create_time"
+// @Param	fields	query	string	false	"Comma separated fields returned, e.g. id,name,profile"
+// @Param	limit	query	int	false	"The max number of users, 20 by default and 100 at most"
+// @Param	cursor	query	string	false	"The cursor of page from Link header"
 // @Success 200 {object} models.User
+// @Failure 400 {object} errcode.Body query is invalid
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router / [get]
 func (u *UserController) GetAll() {
+	q, ok := u.parseQuery(models.UserQuerySchema)
+	if !ok {
+		return
+	}
 	ctx := u.Ctx.Request.Context()
-	users, _ := dao.QueryAllUser(ctx)
-	u.Data["json"] = users
-	services.QueryGrpcDemo(ctx)
-	u.ServeJSON()
+	users, total, err := models.Users().List(ctx, q)
+	if err != nil {
+		u.serveError(err)
+		return
+	}
+	for i := range users {
+		publicUser(&users[i])
+	}
+	u.servePage(q, users, total)
+}
+
+// @Title Search
+// @Description full-text search users by name and email, matched fragments are highlighted with <em> tags
+// @Param	q	query	string	false	"The text matched against name and email, all users are matched without it"
+// @Param	gender	query	string	false	"Only users of the gender"
+// @Param	min_age	query	int	false	"Only users at least this age"
+// @Param	max_age	query	int	false	"Only users at most this age"
+// @Param	created_after	query	string	false	"Only users created on or after the date, e.g. 2018-01-01"
+// @Param	created_before	query	string	false	"Only users created before the date"
+// @Param	sort	query	string	false	"name, email, age, create_time or update_time, '-' prefix sorts descending. Relevance by default"
+// @Param	offset	query	int	false	"The number of skipped users"
+// @Param	limit	query	int	false	"The max number of users, 20 by default and 100 at most"
+// @Success 200 {object} services.UserSearchResult
+// @Failure 400 {object} errcode.Body query is invalid
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
+// @Failure 503 {object} errcode.Body search isn't enabled
+// @router /search [get]
+func (u *UserController) Search() {
+	var form searchUserForm
+	if !u.bindForm(&form) {
+		return
+	}
+	if form.Limit == 0 {
+		form.Limit = defaultSearchLimit
+	}
+	result, err := services.SearchUsers(u.Ctx.Request.Context(), &services.UserSearchQuery{
+		Text:          form.Q,
+		Gender:        form.Gender,
+		MinAge:        form.MinAge,
+		MaxAge:        form.MaxAge,
+		CreatedAfter:  form.CreatedAfter,
+		CreatedBefore: form.CreatedBefore,
+		Sort:          form.Sort,
+		Offset:        form.Offset,
+		Limit:         form.Limit,
+	})
+	if err != nil {
+		u.serveError(err)
+		return
+	}
+	u.Ctx.Output.Header("X-Total-Count", strconv.FormatInt(result.Total, 10))
+	u.serveJSON(result)
 }
 
 // @Title Get
 // @Description get user by uid
 // @Param	uid		path 	string	true		"The key for staticblock"
 // @Success 200 {object} models.User
-// @Failure 403 :uid is empty
+// @Failure 400 {object} errcode.Body :uid is not int
+// @Failure 404 {object} errcode.Body user doesn't exist
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router /:uid [get]
 func (u *UserController) Get() {
-	uid := u.GetString(":uid")
-	if uid != "" {
-		user, err := models.GetUser(uid)
-		if err != nil {
-			u.Data["json"] = err.Error()
-		} else {
-			u.Data["json"] = user
-		}
+	uid, ok := u.userID()
+	if !ok {
+		return
+	}
+	user, err := models.Users().Get(u.Ctx.Request.Context(), uid)
+	if err != nil {
+		u.serveError(err)
+		return
 	}
-	u.ServeJSON()
+	u.serveEntity(user.Version(), publicUser(user))
 }
 
 // @Title Update
 // @Description update the user
 // @Param	uid		path 	string	true		"The uid you want to update"
+// @Param	If-Match	header	string	false	"The ETag of user which the update is based on"
 // @Param	body		body 	models.User	true		"body for user content"
 // @Success 200 {object} models.User
-// @Failure 403 :uid is not int
+// @Failure 400 {object} errcode.Body :uid is not int or body is invalid
+// @Failure 404 {object} errcode.Body user doesn't exist
+// @Failure 412 {object} errcode.Body user has been modified, details is the current user
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router /:uid [put]
 func (u *UserController) Put() {
-	uid := u.GetString(":uid")
-	if uid != "" {
-		var user models.User
-		json.Unmarshal(u.Ctx.Input.RequestBody, &user)
-		uu, err := models.UpdateUser(uid, &user)
-		if err != nil {
-			u.Data["json"] = err.Error()
-		} else {
-			u.Data["json"] = uu
-		}
+	uid, ok := u.userID()
+	if !ok {
+		return
+	}
+	// the version is checked against primary, a replica may lag behind it
+	ctx := models.OnPrimary(u.Ctx.Request.Context())
+	user, err := models.Users().Get(ctx, uid)
+	if err != nil {
+		u.serveError(err)
+		return
+	}
+	current := *user
+	if !u.checkIfMatch(user.Version(), publicUser(&current)) {
+		return
+	}
+
+	var uu updateUserBody
+	if !u.bindJSON(&uu) {
+		return
+	}
+	// only the provided fields are changed
+	if uu.Name != "" {
+		user.Name = uu.Name
+	}
+	user.Password = uu.Password
+	if uu.Profile.Age != 0 {
+		user.Profile.Age = uu.Profile.Age
+	}
+	if uu.Profile.Address != "" {
+		user.Profile.Address = uu.Profile.Address
+	}
+	if uu.Profile.Gender != "" {
+		user.Profile.Gender = uu.Profile.Gender
+	}
+	if uu.Profile.Email != "" {
+		user.Profile.Email = uu.Profile.Email
+	}
+	if err := models.Users().Update(ctx, user); err != nil {
+		u.serveUpdateError(uid, err)
+		return
+	}
+	u.serveEntity(user.Version(), publicUser(user))
+}
+
+// @Title Patch
+// @Description patch the user by merge patch or json patch, the patches apply to {"Name", "Password", "Profile"}
+// @Param	uid		path 	string	true		"The uid you want to patch"
+// @Param	Content-Type	header	string	true	"application/merge-patch+json or application/json-patch+json"
+// @Param	If-Match	header	string	false	"The ETag of user which the patch is based on"
+// @Param	body		body 	string	true		"The patch document"
+// @Success 200 {object} models.User
+// @Failure 400 {object} errcode.Body :uid is not int or patch is invalid
+// @Failure 404 {object} errcode.Body user doesn't exist
+// @Failure 412 {object} errcode.Body user has been modified, details is the current user
+// @Failure 415 {object} errcode.Body content type isn't a patch document
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
+// @router /:uid [patch]
+func (u *UserController) Patch() {
+	uid, ok := u.userID()
+	if !ok {
+		return
+	}
+	ctx := models.OnPrimary(u.Ctx.Request.Context())
+	user, err := models.Users().Get(ctx, uid)
+	if err != nil {
+		u.serveError(err)
+		return
+	}
+	current := *user
+	if !u.checkIfMatch(user.Version(), publicUser(&current)) {
+		return
 	}
-	u.ServeJSON()
+
+	var body userPatchBody
+	if !u.bindPatch(&userPatchBody{Name: user.Name, Profile: user.Profile}, &body) {
+		return
+	}
+	user.Name = body.Name
+	user.Password = body.Password
+	user.Profile = body.Profile
+	if err := models.Users().Update(ctx, user); err != nil {
+		u.serveUpdateError(uid, err)
+		return
+	}
+	u.serveEntity(user.Version(), publicUser(user))
 }
 
 // @Title Delete
 // @Description delete the user
 // @Param	uid		path 	string	true		"The uid you want to delete"
+// @Param	If-Match	header	string	false	"The ETag of user which is deleted"
 // @Success 200 {string} delete success!
-// @Failure 403 uid is empty
+// @Failure 400 {object} errcode.Body :uid is not int
+// @Failure 404 {object} errcode.Body user doesn't exist
+// @Failure 412 {object} errcode.Body user has been modified, details is the current user
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router /:uid [delete]
 func (u *UserController) Delete() {
-	uid := u.GetString(":uid")
-	models.DeleteUser(uid)
-	u.Data["json"] = "delete success!"
-	u.ServeJSON()
+	uid, ok := u.userID()
+	if !ok {
+		return
+	}
+	ctx := models.OnPrimary(u.Ctx.Request.Context())
+	user, err := models.Users().Get(ctx, uid)
+	if err != nil {
+		u.serveError(err)
+		return
+	}
+	if !u.checkIfMatch(user.Version(), publicUser(user)) {
+		return
+	}
+	if err := models.Users().Delete(ctx, uid, u.requiredVersion(user.Version())); err != nil {
+		u.serveUpdateError(uid, err)
+		return
+	}
+	// roles and sessions are kept by user id whichever store holds the user, so the user in trash
+	// loses its roles and can't keep signed in like deleted by the admin console
+	if err := enforcer.DeleteUser(user.Id, user.Name); err != nil {
+		u.serveError(err)
+		return
+	}
+	if err := models.SignOutEverywhere(user.Id); err != nil {
+		u.serveError(err)
+		return
+	}
+	u.serveJSON("delete success!")
 }
 
 // @Title Login
-// @Description Logs user into the system
-// @Param	username		query 	string	true		"The username for login"
-// @Param	password		query 	string	true		"The password for login"
-// @Success 200 {string} login success
-// @Failure 403 user not exist
-// @router /login [get]
+// @Description Logs user into the system and issues access token
+// @Param	username		formData 	string	true		"The username for login"
+// @Param	password		formData 	string	true		"The password for login"
+// @Success 200 {object} models.TokenPair
+// @Failure 400 {object} errcode.Body parameters are missing
+// @Failure 401 {object} errcode.Body user name or password is wrong
+// @router /login [post]
 func (u *UserController) Login() {
-	username := u.GetString("username")
-	password := u.GetString("password")
-	if models.Login(username, password) {
-		u.Data["json"] = "login success"
-	} else {
-		u.Data["json"] = "user not exist"
+	var form loginForm
+	if !u.bindForm(&form) {
+		return
+	}
+	user, err := models.GetAndVerifyUser(form.Username, form.Password)
+	if err != nil {
+		// don't tell whether the user exists
+		u.serveError(errcode.New(errcode.Unauthorized, "invalid_credentials").WithCause(err))
+		return
 	}
-	u.ServeJSON()
+
+	tokens, err := models.IssueTokenPair(user.Id, user.Name)
+	if err != nil {
+		u.serveError(err)
+		return
+	}
+	u.serveJSON(tokens)
+}
+
+// @Title Refresh
+// @Description Exchange refresh token for a new token pair
+// @Param	refresh_token		formData 	string	true		"The refresh token issued with access token"
+// @Success 200 {object} models.TokenPair
+// @Failure 400 {object} errcode.Body parameters are missing
+// @Failure 401 {object} errcode.Body refresh token is invalid or revoked
+// @router /refresh [post]
+func (u *UserController) Refresh() {
+	var form refreshForm
+	if !u.bindForm(&form) {
+		return
+	}
+	tokens, err := models.RefreshTokenPair(form.RefreshToken)
+	if err != nil {
+		u.serveError(err)
+		return
+	}
+	u.serveJSON(tokens)
 }
 
 // @Title logout
-// @Description Logs out current logged in user session
+// @Description Revokes the access token of current request and its refresh token
 // @Success 200 {string} logout success
+// @Failure 401 {object} errcode.Body access token or api key is invalid
+// @Failure 403 {object} errcode.Body permission deny
 // @router /logout [get]
 func (u *UserController) Logout() {
-	u.Data["json"] = "logout success"
-	u.ServeJSON()
+	claims := u.claims()
+	if claims == nil {
+		u.serveError(errcode.New(errcode.Unauthorized, "token_required"))
+		return
+	}
+
+	if err := models.RevokeAccessToken(claims); err != nil {
+		u.serveError(err)
+		return
+	}
+	u.serveJSON("logout success")
 }
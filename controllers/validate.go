@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/validation"
+
+	"github.com/slover2000/beego_demo/errcode"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// parseForm bind form and query parameters to the form tags of obj and validate it
+func parseForm(c *beego.Controller, obj interface{}) error {
+	if err := c.ParseForm(obj); err != nil {
+		return errcode.New(errcode.InvalidArgument, "invalid_body").WithDetails(err.Error())
+	}
+	return validate(obj)
+}
+
+// parseJSON bind json body of request to obj and validate it
+func parseJSON(ctx *context.Context, obj interface{}) error {
+	if err := json.Unmarshal(ctx.Input.RequestBody, obj); err != nil {
+		return errcode.New(errcode.InvalidArgument, "invalid_body").WithDetails(err.Error())
+	}
+	return validate(obj)
+}
+
+// validate check obj by its valid tags, the fields of nested structs are checked too.
+// The invalid fields are returned in details of error and named by their form or json tags
+func validate(obj interface{}) error {
+	fields := make([]errcode.FieldError, 0)
+	if err := validateStruct(obj, "", &fields); err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		return errcode.InvalidFields(fields)
+	}
+	return nil
+}
+
+func validateStruct(obj interface{}, prefix string, fields *[]errcode.FieldError) error {
+	valid := validation.Validation{}
+	if _, err := valid.Valid(obj); err != nil {
+		// the valid tags are wrong
+		return err
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	t := v.Type()
+	names := make(map[string]string, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		names[f.Name] = fieldName(f)
+	}
+	for _, e := range valid.Errors {
+		// key of error is "Field.Func" for tags, or the key given by Valid method
+		name := strings.SplitN(e.Key, ".", 2)[0]
+		if tagged, ok := names[name]; ok {
+			name = tagged
+		}
+		*fields = append(*fields, errcode.FieldError{Field: prefix + name, Message: e.Message})
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Type.Kind() != reflect.Struct || f.Type == timeType {
+			continue
+		}
+		nested := v.Field(i).Interface()
+		if v.Field(i).CanAddr() {
+			nested = v.Field(i).Addr().Interface()
+		}
+		if err := validateStruct(nested, prefix+names[f.Name]+".", fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldName return the name of field in request, form tag is preferred to json tag
+func fieldName(f reflect.StructField) string {
+	for _, key := range []string{"form", "json"} {
+		if tag := strings.Split(f.Tag.Get(key), ",")[0]; tag != "" && tag != "-" {
+			return tag
+		}
+	}
+	return f.Name
+}
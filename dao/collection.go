@@ -0,0 +1,228 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"gopkg.in/mgo.v2"
+
+	"github.com/slover2000/beego_demo/models"
+	"github.com/slover2000/prisma"
+	"github.com/slover2000/prisma/hystrix"
+	p "github.com/slover2000/prisma/thirdparty"
+)
+
+var (
+	// ErrNotFound is returned when no document matches the selector
+	ErrNotFound = errors.New("document doesn't exist")
+	// ErrDuplicateKey is returned when a unique index is violated
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrTimeout is returned when the operation isn't done before the deadline of context
+	ErrTimeout = errors.New("mongo operation timeout")
+)
+
+// Error is the failure of an operation on collection other than the typed errors
+type Error struct {
+	Collection string
+	Action     string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mongo %s on %s failed:%v", e.Action, e.Collection, e.Err)
+}
+
+// Collection is a mongo collection whose operations run with the deadline of context,
+// under the circuit breaker of mongo and labeled by collection and action in metrics.
+// Missing documents and duplicate keys don't count as failures of the breaker
+type Collection struct {
+	name string
+}
+
+// NewCollection create the collection named name, InitMongoClient must be called before using it
+func NewCollection(name string) *Collection {
+	return &Collection{name: name}
+}
+
+// Name return the name of collection
+func (c *Collection) Name() string {
+	return c.name
+}
+
+// Do run fn with the collection of a copied session, it's the base of other operations
+func (c *Collection) Do(ctx context.Context, action string, fn func(mc *mgo.Collection) error) error {
+	ctx = hystrix.WithGroup(ctx, "mongo")
+	ctx = p.JoinDatabaseContextValue(ctx, p.DatabaseParam{
+		System:   prisma.MongoName,
+		Database: mongoInstance.DB("").Name,
+		Table:    c.name,
+		Action:   action,
+		SQL:      action + " " + c.name,
+	})
+	// the earlier one of the deadline of ctx and the operation timeout wins
+	reqctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	result, err := prisma.Do(
+		reqctx,
+		func() (interface{}, error) {
+			sessionCopy := mongoInstance.Copy()
+			defer sessionCopy.Close()
+			if models.ReadsPrimary(reqctx) {
+				// the monotonic session may read a secondary before its first write
+				sessionCopy.SetMode(mgo.Strong, false)
+			}
+			if deadline, ok := reqctx.Deadline(); ok {
+				sessionCopy.SetSocketTimeout(time.Until(deadline))
+			}
+			err := fn(sessionCopy.DB("").C(c.name))
+			if err == mgo.ErrNotFound || mgo.IsDup(err) {
+				// they are answers of a healthy mongo, so they are the result rather than failures of breaker
+				return err, nil
+			}
+			return nil, err
+		})
+	if answer, ok := result.(error); ok && err == nil {
+		err = answer
+	}
+	return c.translate(reqctx, action, err)
+}
+
+// translate mgo errors to the typed errors
+func (c *Collection) translate(ctx context.Context, action string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == mgo.ErrNotFound:
+		return ErrNotFound
+	case mgo.IsDup(err):
+		return ErrDuplicateKey
+	case ctx.Err() == context.DeadlineExceeded:
+		return ErrTimeout
+	}
+	return &Error{Collection: c.name, Action: action, Err: err}
+}
+
+// duplicateIndex return the name of the unique index violated by err of mgo, it's empty for other errors
+func duplicateIndex(err error) string {
+	if err == nil || !mgo.IsDup(err) {
+		return ""
+	}
+	// e.g. "E11000 duplicate key error collection: test.user index: name_unique dup key: { : "astaxie" }"
+	msg := err.Error()
+	i := strings.Index(msg, "index: ")
+	if i < 0 {
+		return ""
+	}
+	name := msg[i+len("index: "):]
+	if j := strings.IndexByte(name, ' '); j >= 0 {
+		name = name[:j]
+	}
+	return name
+}
+
+// FindOne decode the first document matched by selector into result
+func (c *Collection) FindOne(ctx context.Context, selector interface{}, result interface{}) error {
+	return c.Do(ctx, "find", func(mc *mgo.Collection) error {
+		return mc.Find(selector).One(result)
+	})
+}
+
+// FindID decode the document of id into result
+func (c *Collection) FindID(ctx context.Context, id interface{}, result interface{}) error {
+	return c.Do(ctx, "find", func(mc *mgo.Collection) error {
+		return mc.FindId(id).One(result)
+	})
+}
+
+// FindAll decode the documents matched by selector into result which is a pointer of slice,
+// they are sorted by fields and limit is ignored if it's zero
+func (c *Collection) FindAll(ctx context.Context, selector interface{}, skip, limit int, result interface{}, sort ...string) error {
+	return c.Do(ctx, "find", func(mc *mgo.Collection) error {
+		return mc.Find(selector).Sort(sort...).Skip(skip).Limit(limit).All(result)
+	})
+}
+
+// Count the documents matched by selector
+func (c *Collection) Count(ctx context.Context, selector interface{}) (int, error) {
+	var count int
+	err := c.Do(ctx, "count", func(mc *mgo.Collection) (err error) {
+		count, err = mc.Find(selector).Count()
+		return err
+	})
+	return count, err
+}
+
+// Insert documents
+func (c *Collection) Insert(ctx context.Context, docs ...interface{}) error {
+	return c.Do(ctx, "insert", func(mc *mgo.Collection) error {
+		return mc.Insert(docs...)
+	})
+}
+
+// Update the first document matched by selector, ErrNotFound is returned if there is none
+func (c *Collection) Update(ctx context.Context, selector, update interface{}) error {
+	return c.Do(ctx, "update", func(mc *mgo.Collection) error {
+		return mc.Update(selector, update)
+	})
+}
+
+// UpdateAll update all documents matched by selector and return the number of them
+func (c *Collection) UpdateAll(ctx context.Context, selector, update interface{}) (int, error) {
+	var updated int
+	err := c.Do(ctx, "update", func(mc *mgo.Collection) error {
+		info, err := mc.UpdateAll(selector, update)
+		if info != nil {
+			updated = info.Updated
+		}
+		return err
+	})
+	return updated, err
+}
+
+// Upsert update the document matched by selector or insert it
+func (c *Collection) Upsert(ctx context.Context, selector, update interface{}) error {
+	return c.Do(ctx, "upsert", func(mc *mgo.Collection) error {
+		_, err := mc.Upsert(selector, update)
+		return err
+	})
+}
+
+// UpsertID replace the document of id or insert it
+func (c *Collection) UpsertID(ctx context.Context, id, doc interface{}) error {
+	return c.Do(ctx, "upsert", func(mc *mgo.Collection) error {
+		_, err := mc.UpsertId(id, doc)
+		return err
+	})
+}
+
+// Delete the first document matched by selector, ErrNotFound is returned if there is none
+func (c *Collection) Delete(ctx context.Context, selector interface{}) error {
+	return c.Do(ctx, "delete", func(mc *mgo.Collection) error {
+		return mc.Remove(selector)
+	})
+}
+
+// DeleteAll delete all documents matched by selector and return the number of them
+func (c *Collection) DeleteAll(ctx context.Context, selector interface{}) (int, error) {
+	var removed int
+	err := c.Do(ctx, "delete", func(mc *mgo.Collection) error {
+		info, err := mc.RemoveAll(selector)
+		if info != nil {
+			removed = info.Removed
+		}
+		return err
+	})
+	return removed, err
+}
+
+// Aggregate run pipeline and decode its results into result which is a pointer of slice
+func (c *Collection) Aggregate(ctx context.Context, pipeline interface{}, result interface{}) error {
+	return c.Do(ctx, "aggregate", func(mc *mgo.Collection) error {
+		return mc.Pipe(pipeline).All(result)
+	})
+}
@@ -0,0 +1,23 @@
+package dao
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/mgo.v2"
+)
+
+func TestDuplicateIndex(t *testing.T) {
+	cases := map[error]string{
+		&mgo.LastError{Code: 11000, Err: `E11000 duplicate key error collection: test.user index: name_unique dup key: { : "astaxie" }`}: "name_unique",
+		&mgo.LastError{Code: 11000, Err: `E11000 duplicate key error collection: test.user index: _id_ dup key: { : 1 }`}:                "_id_",
+		&mgo.LastError{Code: 11000, Err: "E11000 duplicate key error"}:                                                                   "",
+		errors.New("index: name_unique"): "",
+		nil:                              "",
+	}
+	for err, index := range cases {
+		if got := duplicateIndex(err); got != index {
+			t.Errorf("index of %v should be '%s', got '%s'", err, index, got)
+		}
+	}
+}
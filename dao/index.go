@@ -0,0 +1,109 @@
+package dao
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"gopkg.in/mgo.v2"
+)
+
+// CollectionIndexes declares the indexes of a collection, index name is required to compare with existing ones
+type CollectionIndexes struct {
+	Collection string
+	Indexes    []mgo.Index
+}
+
+// IndexReport is the difference between declared and existing indexes of a collection,
+// missing indexes are created and extra ones are left for admin to drop
+type IndexReport struct {
+	Collection string
+	Created    []string
+	Extra      []string
+}
+
+// names of the indexes whose violations are told apart
+const (
+	idIndex       = "_id_"
+	userNameIndex = "name_unique"
+	// trashTTLIndex expires the trashed users of mongo
+	trashTTLIndex = "deletedat_ttl"
+)
+
+// declaredIndexes return indexes of collections, trashed users expire after retention unless it's zero
+func declaredIndexes(trashRetention time.Duration) []CollectionIndexes {
+	userIndexes := []mgo.Index{
+		// names of users in trash are taken too, so the index isn't partial
+		{Name: userNameIndex, Key: []string{"name"}, Unique: true},
+		{Name: "createtime", Key: []string{"createtime"}},
+		// local users have neither field, so they are left out of the sparse index
+		{Name: "source_externalid_unique", Key: []string{"source", "externalid"}, Unique: true, Sparse: true},
+	}
+	if trashRetention > 0 {
+		userIndexes = append(userIndexes, mgo.Index{Name: trashTTLIndex, Key: []string{"deletedat"}, ExpireAfter: trashRetention})
+	}
+
+	return []CollectionIndexes{
+		{Collection: userCollection, Indexes: userIndexes},
+		{Collection: objectCollection, Indexes: []mgo.Index{
+			{Name: "player_name_score", Key: []string{"player_name", "-score"}},
+		}},
+	}
+}
+
+// EnsureIndexes create the missing indexes of collections and report the extra ones
+func EnsureIndexes(ctx context.Context, declared []CollectionIndexes) ([]IndexReport, error) {
+	reports := make([]IndexReport, 0, len(declared))
+	for _, d := range declared {
+		report := IndexReport{Collection: d.Collection}
+		err := NewCollection(d.Collection).Do(ctx, "index", func(c *mgo.Collection) error {
+			existing, err := c.Indexes()
+			if err != nil && !isNamespaceNotFound(err) {
+				return err
+			}
+			missing, extra := diffIndexes(d.Indexes, existing)
+			for _, index := range missing {
+				if err := c.EnsureIndex(index); err != nil {
+					return err
+				}
+				report.Created = append(report.Created, index.Name)
+			}
+			report.Extra = extra
+			return nil
+		})
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// diffIndexes compare indexes by name, the default index of _id is never extra
+func diffIndexes(declared, existing []mgo.Index) (missing []mgo.Index, extra []string) {
+	names := make(map[string]bool, len(existing))
+	for _, index := range existing {
+		names[index.Name] = true
+	}
+	declaredNames := make(map[string]bool, len(declared))
+	for _, index := range declared {
+		declaredNames[index.Name] = true
+		if !names[index.Name] {
+			missing = append(missing, index)
+		}
+	}
+	for _, index := range existing {
+		if index.Name != "_id_" && !declaredNames[index.Name] {
+			extra = append(extra, index.Name)
+		}
+	}
+	return missing, extra
+}
+
+// isNamespaceNotFound tell whether listing indexes fails because collection doesn't exist yet
+func isNamespaceNotFound(err error) bool {
+	if e, ok := err.(*mgo.QueryError); ok {
+		return e.Code == 26
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package dao
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+func TestDiffIndexes(t *testing.T) {
+	declared := declaredIndexes(30 * 24 * time.Hour)[0].Indexes
+	existing := []mgo.Index{
+		{Name: "_id_", Key: []string{"_id"}},
+		{Name: "name_unique", Key: []string{"name"}, Unique: true},
+		{Name: "profile.email", Key: []string{"profile.email"}},
+	}
+
+	missing, extra := diffIndexes(declared, existing)
+	names := make([]string, 0, len(missing))
+	for _, index := range missing {
+		names = append(names, index.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"createtime", "source_externalid_unique", "deletedat_ttl"}) {
+		t.Errorf("missing indexes should be createtime, source_externalid_unique and deletedat_ttl, got %v", names)
+	}
+	if !reflect.DeepEqual(extra, []string{"profile.email"}) {
+		t.Errorf("extra index should be profile.email, got %v", extra)
+	}
+
+	if indexes := declaredIndexes(0)[0].Indexes; len(indexes) != 3 {
+		t.Errorf("ttl index of trash shouldn't be declared without retention, got %v", indexes)
+	}
+}
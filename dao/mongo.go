@@ -5,13 +5,11 @@ import (
 
 	"golang.org/x/net/context"
 
+	"github.com/sirupsen/logrus"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
 	"github.com/slover2000/beego_demo/models"
-	"github.com/slover2000/prisma"
-	"github.com/slover2000/prisma/hystrix"
-	p "github.com/slover2000/prisma/thirdparty"
 )
 
 // MongoConfig is the settings of mongo
@@ -20,11 +18,19 @@ type MongoConfig struct {
 	DialTimeout int      `default:"10"`
 	Database    string   `required:"true"`
 	PoolLimit   int      `default:"10"`
+	// OperationTimeout in seconds bounds every operation, the deadline of request is used if it's earlier
+	OperationTimeout int `default:"5"`
+	// TrashRetention in days is how long deleted users are kept, they are kept forever if it's zero.
+	// Expired users are gone from the trash of admin console without notice
+	TrashRetention int `default:"0"`
 }
 
-var mongoInstance *mgo.Session
+var (
+	mongoInstance    *mgo.Session
+	operationTimeout = 5 * time.Second
+)
 
-// InitMongoClient initialize mongo client
+// InitMongoClient initialize mongo client and ensure the indexes of collections
 func InitMongoClient(cfg *MongoConfig) error {
 	client, err := mgo.DialWithInfo(&mgo.DialInfo{
 		Addrs:     cfg.Addrs,
@@ -37,8 +43,30 @@ func InitMongoClient(cfg *MongoConfig) error {
 		// Optional. Switch the session to a monotonic behavior.
 		client.SetMode(mgo.Monotonic, true)
 		mongoInstance = client
+		if cfg.OperationTimeout > 0 {
+			operationTimeout = time.Duration(cfg.OperationTimeout) * time.Second
+		}
+	}
+	if err != nil {
+		return err
 	}
 
+	retention := time.Duration(cfg.TrashRetention) * 24 * time.Hour
+	reports, err := EnsureIndexes(context.Background(), declaredIndexes(retention))
+	for _, r := range reports {
+		entry := logrus.WithField("collection", r.Collection)
+		if len(r.Created) > 0 {
+			entry.Infof("mongo indexes %v are created", r.Created)
+		}
+		if len(r.Extra) > 0 {
+			entry.Warnf("mongo indexes %v aren't declared, drop them if they are unused", r.Extra)
+		}
+		for _, name := range r.Extra {
+			if name == trashTTLIndex {
+				entry.Warnf("trashed users still expire by index %s, drop it to keep them", name)
+			}
+		}
+	}
 	return err
 }
 
@@ -50,79 +78,31 @@ func CloseMongoClient() {
 }
 
 // StoreUserInfo store user info into db
-func StoreUserInfo(user *models.User) bool {
-	sessionCopy := mongoInstance.Copy()
-	defer sessionCopy.Close()
-
-	c := sessionCopy.DB("").C("user")
-	if err := c.Insert(user); err != nil {
-		return false
-	}
-
-	return true
+func StoreUserInfo(ctx context.Context, user *models.User) error {
+	return NewCollection(userCollection).Insert(ctx, user)
 }
 
 // QueryAllUser query user info from db
 func QueryAllUser(ctx context.Context) ([]models.User, error) {
-	ctx = hystrix.WithGroup(ctx, "mongo")	
-	ctx = p.JoinDatabaseContextValue(ctx, p.DatabaseParam{
-		System: prisma.MongoName,
-		Database: "test_db",
-		Table: "user",
-		Action: "find",
-		SQL: "find all users",
-	}) // the following codes is optional, you can not set context value if you don't want to metric specific database
-	reqctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	values, err := prisma.Do(
-		reqctx,
-		func() (interface{}, error) {
-			sessionCopy := mongoInstance.Copy()
-			defer sessionCopy.Close()
-
-			var users []models.User
-			c := sessionCopy.DB("").C("user")
-			err := c.Find(bson.M{}).All(&users)
-			return users, err
-		})
-	cancel()
-
-	if err != nil {
-		return nil, err
-	}
-	users, _ := values.([]models.User)
-	return users, nil
+	var users []models.User
+	err := NewCollection(userCollection).FindAll(ctx, bson.M{}, 0, 0, &users)
+	return users, err
 }
 
 // QueryUser query user info from db
-func QueryUser(name string) (*models.User, error) {
-	sessionCopy := mongoInstance.Copy()
-	defer sessionCopy.Close()
-
+func QueryUser(ctx context.Context, name string) (*models.User, error) {
 	user := &models.User{}
-	c := sessionCopy.DB("").C("user")
-	err := c.FindId(name).One(&user)
-
+	err := NewCollection(userCollection).FindOne(ctx, bson.M{"name": name}, user)
 	return user, err
 }
 
 // UserNameExists check wether user name already exists
-func UserNameExists(name string) bool {
-	sessionCopy := mongoInstance.Copy()
-	defer sessionCopy.Close()
-
-	c := sessionCopy.DB("").C("user")
-	count, _ := c.FindId(name).Count()
-
-	return count > 0
+func UserNameExists(ctx context.Context, name string) (bool, error) {
+	count, err := NewCollection(userCollection).Count(ctx, bson.M{"name": name})
+	return count > 0, err
 }
 
-// RemoveUser remove user from db by name
-func RemoveUser(id string) bool {
-	sessionCopy := mongoInstance.Copy()
-	defer sessionCopy.Close()
-
-	c := sessionCopy.DB("").C("user")
-	err := c.Remove(bson.M{"_id": id})
-
-	return err == nil
+// RemoveUser remove user from db by id
+func RemoveUser(ctx context.Context, id int64) error {
+	return NewCollection(userCollection).Delete(ctx, bson.M{"_id": id})
 }
@@ -0,0 +1,99 @@
+package dao
+
+import (
+	"golang.org/x/net/context"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/slover2000/beego_demo/models"
+)
+
+const objectCollection = "object"
+
+// MongoObjectRepository stores objects in the object collection of mongo
+type MongoObjectRepository struct {
+	objects *Collection
+}
+
+// NewMongoObjectRepository create the object repository of mongo, InitMongoClient must be called before using it
+func NewMongoObjectRepository() *MongoObjectRepository {
+	return &MongoObjectRepository{objects: NewCollection(objectCollection)}
+}
+
+func (r *MongoObjectRepository) Get(ctx context.Context, id string) (*models.Object, error) {
+	o := &models.Object{}
+	err := r.objects.FindID(ctx, id, o)
+	if err == ErrNotFound {
+		return nil, models.ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (r *MongoObjectRepository) List(ctx context.Context, q *models.Query) ([]models.Object, int, error) {
+	var count int
+	var objects []models.Object
+	err := r.objects.Do(ctx, "find", func(c *mgo.Collection) error {
+		query, total, err := findQuery(c, q, nil)
+		if err != nil {
+			return err
+		}
+		count = total
+		return query.All(&objects)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return objects, count, nil
+}
+
+func (r *MongoObjectRepository) Create(ctx context.Context, o *models.Object) error {
+	o.ObjectId = models.NewObjectID()
+	o.Version = 1
+	return r.objects.Insert(ctx, o)
+}
+
+func (r *MongoObjectRepository) Update(ctx context.Context, o *models.Object) error {
+	selector := bson.M{"_id": o.ObjectId, "version": objectVersion(o.Version)}
+	fields := bson.M{"score": o.Score, "player_name": o.PlayerName, "version": o.Version + 1}
+	err := r.objects.Update(ctx, selector, bson.M{"$set": fields})
+	if err == ErrNotFound {
+		return r.missed(ctx, o.ObjectId)
+	}
+	if err != nil {
+		return err
+	}
+	o.Version++
+	return nil
+}
+
+func (r *MongoObjectRepository) Delete(ctx context.Context, id string, version int64) error {
+	selector := bson.M{"_id": id}
+	if version != models.AnyVersion {
+		selector["version"] = objectVersion(version)
+	}
+	err := r.objects.Delete(ctx, selector)
+	if err == ErrNotFound {
+		return r.missed(ctx, id)
+	}
+	return err
+}
+
+// objectVersion match version of object, objects saved before versioning have no version field
+func objectVersion(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": []interface{}{0, nil}}
+	}
+	return version
+}
+
+// missed tell why no object is changed, it's either deleted or changed by others
+func (r *MongoObjectRepository) missed(ctx context.Context, id string) error {
+	if _, err := r.Get(ctx, id); err != nil {
+		return err
+	}
+	return models.ErrVersionConflict
+}
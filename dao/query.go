@@ -0,0 +1,70 @@
+package dao
+
+import (
+	"regexp"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/slover2000/beego_demo/models"
+)
+
+var mongoOperators = map[string]string{
+	"=":  "$eq",
+	"!=": "$ne",
+	">":  "$gt",
+	">=": "$gte",
+	"<":  "$lt",
+	"<=": "$lte",
+}
+
+// mongoFilter translate filters of query to mongo selector
+func mongoFilter(q *models.Query) bson.M {
+	selector := bson.M{}
+	for _, f := range q.Filters {
+		field := q.Schema.Fields[f.Field].BSON
+		cond, ok := selector[field].(bson.M)
+		if !ok {
+			cond = bson.M{}
+			selector[field] = cond
+		}
+		cond[mongoOperators[f.Op]] = f.Value
+	}
+	if q.Keyword != "" && len(q.Schema.KeywordFields) > 0 {
+		// keyword is matched literally and case insensitively like ILIKE of postgres
+		pattern := bson.RegEx{Pattern: regexp.QuoteMeta(q.Keyword), Options: "i"}
+		conditions := make([]bson.M, len(q.Schema.KeywordFields))
+		for i, field := range q.Schema.KeywordFields {
+			conditions[i] = bson.M{field: pattern}
+		}
+		selector["$or"] = conditions
+	}
+	return selector
+}
+
+// mongoSort translate sorts of query to mgo sort fields
+func mongoSort(q *models.Query) []string {
+	fields := make([]string, 0, len(q.Sorts))
+	for _, s := range q.Sorts {
+		field := q.Schema.Fields[s.Field].BSON
+		if s.Desc {
+			field = "-" + field
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// findQuery count documents matched by query and return the mgo query of its page,
+// the conditions of scope are added to the filters of query
+func findQuery(c *mgo.Collection, q *models.Query, scope bson.M) (*mgo.Query, int, error) {
+	selector := mongoFilter(q)
+	for k, v := range scope {
+		selector[k] = v
+	}
+	count, err := c.Find(selector).Count()
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.Find(selector).Sort(mongoSort(q)...).Skip(q.Offset).Limit(q.Limit), count, nil
+}
@@ -0,0 +1,218 @@
+package dao
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/slover2000/beego_demo/models"
+)
+
+const userCollection = "user"
+
+// maxIDAttempts is how many ids are tried when users are created at the same nanosecond
+const maxIDAttempts = 3
+
+// MongoUserRepository stores users in the user collection of mongo
+type MongoUserRepository struct {
+	users *Collection
+}
+
+// NewMongoUserRepository create the user repository of mongo, InitMongoClient must be called before using it
+func NewMongoUserRepository() *MongoUserRepository {
+	return &MongoUserRepository{users: NewCollection(userCollection)}
+}
+
+// alive add the condition excluding users in trash to selector
+func alive(selector bson.M) bson.M {
+	selector["deletedat"] = nil
+	return selector
+}
+
+func (r *MongoUserRepository) findOne(ctx context.Context, query bson.M) (*models.User, error) {
+	user := &models.User{}
+	err := r.users.FindOne(ctx, query, user)
+	if err == ErrNotFound {
+		return nil, models.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *MongoUserRepository) Get(ctx context.Context, id int64) (*models.User, error) {
+	return r.findOne(ctx, alive(bson.M{"_id": id}))
+}
+
+func (r *MongoUserRepository) GetByName(ctx context.Context, name string) (*models.User, error) {
+	return r.findOne(ctx, alive(bson.M{"name": name}))
+}
+
+// GetByExternalID find the linked user in trash too
+func (r *MongoUserRepository) GetByExternalID(ctx context.Context, source, externalID string) (*models.User, error) {
+	if source == "" || externalID == "" {
+		return nil, models.ErrUserNotFound
+	}
+	return r.findOne(ctx, bson.M{"source": source, "externalid": externalID})
+}
+
+func (r *MongoUserRepository) List(ctx context.Context, q *models.Query) ([]models.User, int, error) {
+	var count int
+	var users []models.User
+	err := r.users.Do(ctx, "find", func(c *mgo.Collection) error {
+		query, total, err := findQuery(c, q, alive(bson.M{}))
+		if err != nil {
+			return err
+		}
+		count = total
+		return query.All(&users)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, count, nil
+}
+
+func (r *MongoUserRepository) ListTrash(ctx context.Context, offset, limit int) ([]models.User, int, error) {
+	var count int
+	var users []models.User
+	err := r.users.Do(ctx, "find", func(c *mgo.Collection) error {
+		selector := bson.M{"deletedat": bson.M{"$ne": nil}}
+		total, err := c.Find(selector).Count()
+		if err != nil {
+			return err
+		}
+		count = total
+		return c.Find(selector).Sort("-deletedat").Skip(offset).Limit(limit).All(&users)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, count, nil
+}
+
+func (r *MongoUserRepository) Create(ctx context.Context, u *models.User) error {
+	if err := models.CheckUserName(u.Name); err != nil {
+		return err
+	}
+	passwordhash, err := models.HashPassword(u.Password)
+	if err != nil {
+		return err
+	}
+	return r.create(ctx, u, passwordhash)
+}
+
+func (r *MongoUserRepository) CreateHashed(ctx context.Context, u *models.User) error {
+	if err := models.CheckUserName(u.Name); err != nil {
+		return err
+	}
+	return r.create(ctx, u, u.Password)
+}
+
+func (r *MongoUserRepository) create(ctx context.Context, u *models.User, passwordhash string) error {
+	// names of users in trash are taken too
+	if _, err := r.findOne(ctx, bson.M{"name": u.Name}); err == nil {
+		return models.ErrUserNameExists
+	}
+
+	now := models.Timestamp()
+	user := *u
+	user.Password = passwordhash
+	user.CreateTime = now
+	user.UpdateTime = now
+	// ids are taken from the clock in nanoseconds, users created at the same time get the next one
+	for attempt := 1; ; attempt++ {
+		user.Id = time.Now().UnixNano()
+		var index string
+		err := r.users.Do(ctx, "insert", func(c *mgo.Collection) error {
+			err := c.Insert(&user)
+			index = duplicateIndex(err)
+			return err
+		})
+		if err == ErrDuplicateKey && index == idIndex && attempt < maxIDAttempts {
+			continue
+		}
+		if err == ErrDuplicateKey && index == userNameIndex {
+			return models.ErrUserNameExists
+		}
+		if err != nil {
+			return err
+		}
+		*u = user
+		return nil
+	}
+}
+
+func (r *MongoUserRepository) Update(ctx context.Context, u *models.User) error {
+	if err := models.CheckUserName(u.Name); err != nil {
+		return err
+	}
+	now := models.Timestamp()
+	fields := bson.M{
+		"name":       u.Name,
+		"profile":    u.Profile,
+		"updatetime": now,
+	}
+	if u.Password != "" {
+		passwordhash, err := models.HashPassword(u.Password)
+		if err != nil {
+			return err
+		}
+		fields["password"] = passwordhash
+	}
+
+	err := r.users.Update(ctx, alive(bson.M{"_id": u.Id, "updatetime": u.UpdateTime}), bson.M{"$set": fields})
+	if err == ErrNotFound {
+		return r.missed(ctx, u.Id)
+	}
+	if err != nil {
+		return err
+	}
+	u.UpdateTime = now
+	return nil
+}
+
+// Delete move user to trash by setting its deletedat
+func (r *MongoUserRepository) Delete(ctx context.Context, id int64, version int64) error {
+	selector := alive(bson.M{"_id": id})
+	if version != models.AnyVersion {
+		selector["updatetime"] = time.Unix(0, version)
+	}
+	err := r.users.Update(ctx, selector, bson.M{"$set": bson.M{"deletedat": models.Timestamp()}})
+	if err == ErrNotFound {
+		return r.missed(ctx, id)
+	}
+	return err
+}
+
+func (r *MongoUserRepository) Restore(ctx context.Context, id int64) error {
+	err := r.users.Update(ctx, bson.M{"_id": id, "deletedat": bson.M{"$ne": nil}}, bson.M{"$unset": bson.M{"deletedat": ""}})
+	if err == ErrNotFound {
+		return models.ErrNotInTrash
+	}
+	return err
+}
+
+func (r *MongoUserRepository) Purge(ctx context.Context, id int64) error {
+	err := r.users.Delete(ctx, bson.M{"_id": id, "deletedat": bson.M{"$ne": nil}})
+	if err == ErrNotFound {
+		return models.ErrNotInTrash
+	}
+	return err
+}
+
+// missed tell why no user is changed, it's either deleted or changed by others
+func (r *MongoUserRepository) missed(ctx context.Context, id int64) error {
+	if _, err := r.Get(ctx, id); err != nil {
+		return err
+	}
+	return models.ErrVersionConflict
+}
+
+func (r *MongoUserRepository) Import(ctx context.Context, u *models.User) error {
+	return r.users.UpsertID(ctx, u.Id, u)
+}
@@ -0,0 +1,142 @@
+package dao
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/slover2000/beego_demo/events"
+	"github.com/slover2000/beego_demo/models"
+)
+
+// oplogEntry is a document of local.oplog.rs, O is the inserted document or the update,
+// and O2 holds _id of the updated document
+type oplogEntry struct {
+	Timestamp bson.MongoTimestamp `bson:"ts"`
+	Op        string              `bson:"op"`
+	Namespace string              `bson:"ns"`
+	O         bson.Raw            `bson:"o"`
+	O2        struct {
+		ID int64 `bson:"_id"`
+	} `bson:"o2"`
+}
+
+// UserWatcher tails the oplog of replica set for changes of user collection and publishes events.UserChanged.
+// The mgo driver doesn't support change streams, so the oplog is polled by a tailable cursor.
+// Updates are published with the user read after the change, like the updateLookup of change streams
+type UserWatcher struct {
+	bus  *events.Bus
+	last bson.MongoTimestamp
+	stop chan struct{}
+	done chan struct{}
+}
+
+// WatchUsers start tailing changes of users made from now on, InitMongoClient must be called before it
+func WatchUsers(bus *events.Bus) (*UserWatcher, error) {
+	w := &UserWatcher{bus: bus, stop: make(chan struct{}), done: make(chan struct{})}
+
+	session := mongoInstance.Copy()
+	defer session.Close()
+	var latest oplogEntry
+	err := session.DB("local").C("oplog.rs").Find(nil).Sort("-$natural").One(&latest)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, &Error{Collection: "oplog.rs", Action: "find", Err: err}
+	}
+	w.last = latest.Timestamp
+
+	go w.run()
+	return w, nil
+}
+
+// Stop tailing and wait until the running publish is done
+func (w *UserWatcher) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *UserWatcher) run() {
+	defer close(w.done)
+	for {
+		if err := w.tail(); err != nil {
+			logrus.Errorf("tail oplog of users failed:%v", err)
+		}
+		// the cursor is dead or failed, tail again from the last seen entry
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (w *UserWatcher) tail() error {
+	session := mongoInstance.Copy()
+	defer session.Close()
+	ns := session.DB("").Name + "." + userCollection
+
+	iter := session.DB("local").C("oplog.rs").
+		Find(bson.M{"ts": bson.M{"$gt": w.last}, "ns": ns}).
+		LogReplay().
+		Tail(time.Second)
+	defer iter.Close()
+
+	var entry oplogEntry
+	for {
+		for iter.Next(&entry) {
+			w.publish(session, &entry)
+			w.last = entry.Timestamp
+			select {
+			case <-w.stop:
+				return nil
+			default:
+			}
+		}
+		if iter.Err() != nil || !iter.Timeout() {
+			return iter.Err()
+		}
+		select {
+		case <-w.stop:
+			return nil
+		default:
+		}
+	}
+}
+
+func (w *UserWatcher) publish(session *mgo.Session, entry *oplogEntry) {
+	at := time.Unix(int64(entry.Timestamp>>32), 0)
+	switch entry.Op {
+	case "i":
+		user := &models.User{}
+		if err := entry.O.Unmarshal(user); err != nil {
+			logrus.Errorf("decode inserted user failed:%v", err)
+			return
+		}
+		// subscribers never see the password, as with events published by events.UserRepository
+		user.Password = ""
+		w.bus.Publish(&events.UserChanged{Op: events.OpInsert, ID: user.Id, User: user, At: at})
+	case "u":
+		user := &models.User{}
+		err := session.DB("").C(userCollection).FindId(entry.O2.ID).One(user)
+		if err == mgo.ErrNotFound {
+			// deleted after the update
+			return
+		}
+		if err != nil {
+			logrus.Errorf("read updated user %d failed:%v", entry.O2.ID, err)
+			return
+		}
+		user.Password = ""
+		w.bus.Publish(&events.UserChanged{Op: events.OpUpdate, ID: user.Id, User: user, At: at})
+	case "d":
+		var key struct {
+			ID int64 `bson:"_id"`
+		}
+		if err := entry.O.Unmarshal(&key); err != nil {
+			logrus.Errorf("decode deleted user failed:%v", err)
+			return
+		}
+		w.bus.Publish(&events.UserChanged{Op: events.OpDelete, ID: key.ID, At: at})
+	}
+}
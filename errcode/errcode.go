@@ -0,0 +1,145 @@
+// Package errcode defines the typed errors returned by controllers, every error has a code,
+// a http status and a localized message.
+package errcode
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Code is the business error code in response body, zero means success
+type Code int
+
+const (
+	OK               Code = 0
+	PermissionDenied Code = -1
+	Unauthorized     Code = -2
+	InvalidArgument  Code = 1000
+	NotFound         Code = 1001
+	AlreadyExists    Code = 1002
+	VersionConflict  Code = 1003
+	UnsupportedMedia Code = 1004
+	Internal         Code = 1500
+	Unavailable      Code = 1503
+)
+
+type codeInfo struct {
+	status int
+	key    string
+}
+
+var codes = map[Code]codeInfo{
+	OK:               {http.StatusOK, "ok"},
+	PermissionDenied: {http.StatusForbidden, "permission_denied"},
+	Unauthorized:     {http.StatusUnauthorized, "unauthorized"},
+	InvalidArgument:  {http.StatusBadRequest, "invalid_argument"},
+	NotFound:         {http.StatusNotFound, "not_found"},
+	AlreadyExists:    {http.StatusConflict, "already_exists"},
+	VersionConflict:  {http.StatusPreconditionFailed, "version_conflict"},
+	UnsupportedMedia: {http.StatusUnsupportedMediaType, "unsupported_media"},
+	Internal:         {http.StatusInternalServerError, "internal"},
+	Unavailable:      {http.StatusServiceUnavailable, "unavailable"},
+}
+
+// Error is a typed error, Cause is logged but never exposed to clients
+type Error struct {
+	Code    Code
+	Key     string
+	Args    []interface{}
+	Details interface{}
+	Cause   error
+}
+
+// Body is the json body of error response
+type Body struct {
+	Status    Code        `json:"status"`
+	Message   string      `json:"msg"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// FieldError is the detail of an invalid field in request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// New create error of code, the message is looked up by key which is formatted with args,
+// the default message of code is used when key is empty
+func New(code Code, key string, args ...interface{}) *Error {
+	return &Error{Code: code, Key: key, Args: args}
+}
+
+// From convert err to typed error, unknown errors are internal errors
+func From(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return New(Internal, "").WithCause(err)
+}
+
+// InvalidParameter create the error of a missing or malformed parameter
+func InvalidParameter(name string) *Error {
+	return New(InvalidArgument, "invalid_parameter", name)
+}
+
+// InvalidFields create the error of request which fails validation, fields are returned as details
+func InvalidFields(fields []FieldError) *Error {
+	return New(InvalidArgument, "validation_failed").WithDetails(fields)
+}
+
+// WithDetails return a copy of error with details
+func (e *Error) WithDetails(details interface{}) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// WithCause return a copy of error with cause
+func (e *Error) WithCause(err error) *Error {
+	c := *e
+	c.Cause = err
+	return &c
+}
+
+// Status is the http status of error
+func (e *Error) Status() int {
+	if info, ok := codes[e.Code]; ok {
+		return info.status
+	}
+	return http.StatusInternalServerError
+}
+
+// Message return the message of error in language
+func (e *Error) Message(lang string) string {
+	key := e.Key
+	if key == "" {
+		key = codes[e.Code].key
+	}
+	format, ok := lookup(lang, key)
+	if !ok {
+		format, _ = lookup(lang, codes[Internal].key)
+	}
+	if len(e.Args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, e.Args...)
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("[%d] %s", e.Code, e.Message(English))
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+// Body create response body of error in language
+func (e *Error) Body(lang, requestID string) *Body {
+	return &Body{
+		Status:    e.Code,
+		Message:   e.Message(lang),
+		Details:   e.Details,
+		RequestID: requestID,
+	}
+}
@@ -0,0 +1,65 @@
+package errcode
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestErrorMessage(t *testing.T) {
+	e := InvalidParameter("page")
+	if e.Status() != http.StatusBadRequest {
+		t.Errorf("expect status 400 but got %d", e.Status())
+	}
+	if msg := e.Message(English); msg != "parameter 'page' is invalid" {
+		t.Errorf("unexpected english message:%s", msg)
+	}
+	if msg := e.Message(Chinese); msg != "参数'page'无效" {
+		t.Errorf("unexpected chinese message:%s", msg)
+	}
+
+	body := New(NotFound, "").WithDetails("uid").Body(English, "req-1")
+	if body.Status != NotFound || body.Message != "resource doesn't exist" || body.Details != "uid" || body.RequestID != "req-1" {
+		t.Errorf("unexpected body:%+v", body)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	cause := errors.New("connection refused")
+	e := From(cause)
+	if e.Code != Internal || e.Cause != cause || e.Status() != http.StatusInternalServerError {
+		t.Errorf("unknown error should be internal error:%+v", e)
+	}
+	if From(e) != e {
+		t.Error("typed error should be returned as it is")
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":                   DefaultLanguage,
+		"en-US,en;q=0.9":     English,
+		"fr-FR, zh-TW;q=0.8": Chinese,
+		"de-DE":              DefaultLanguage,
+	}
+	for header, lang := range cases {
+		if got := Language(header); got != lang {
+			t.Errorf("Language(%q) expect %s but got %s", header, lang, got)
+		}
+	}
+}
+
+func TestInvalidFields(t *testing.T) {
+	e := InvalidFields([]FieldError{{Field: "profile.email", Message: "Must be a valid email address"}})
+	if e.Code != InvalidArgument || e.Status() != http.StatusBadRequest {
+		t.Errorf("unexpected error:%+v", e)
+	}
+	body := e.Body(English, "")
+	fields, ok := body.Details.([]FieldError)
+	if !ok || len(fields) != 1 || fields[0].Field != "profile.email" {
+		t.Errorf("fields should be returned as details:%+v", body.Details)
+	}
+	if body.Message != "request fails validation" {
+		t.Errorf("unexpected message:%s", body.Message)
+	}
+}
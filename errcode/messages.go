@@ -0,0 +1,150 @@
+package errcode
+
+import (
+	"strings"
+	"sync"
+)
+
+// supported languages of messages
+const (
+	Chinese = "zh-CN"
+	English = "en-US"
+)
+
+// DefaultLanguage is used when the request doesn't accept any supported language
+var DefaultLanguage = Chinese
+
+var (
+	lock     sync.RWMutex
+	messages = map[string]map[string]string{
+		Chinese: {
+			"ok":                          "ok",
+			"degraded":                    "只读副本不可用，读请求由主库处理",
+			"permission_denied":           "没有权限",
+			"unauthorized":                "请先登录",
+			"invalid_argument":            "参数错误",
+			"not_found":                   "资源不存在",
+			"already_exists":              "资源已存在",
+			"version_conflict":            "数据已被他人修改，请刷新后重试",
+			"unsupported_media":           "不支持的请求内容类型",
+			"internal":                    "服务器内部错误",
+			"invalid_parameter":           "参数'%s'无效",
+			"invalid_query":               "查询参数无效",
+			"invalid_cursor":              "分页游标无效",
+			"invalid_body":                "请求内容无效",
+			"invalid_patch":               "补丁文档无效或无法应用",
+			"validation_failed":           "请求参数校验失败",
+			"invalid_credentials":         "帐号或密码错误",
+			"token_required":              "请提供访问令牌",
+			"token_invalid":               "访问令牌无效或已撤销",
+			"apikey_invalid":              "API密钥无效或已过期",
+			"user_not_found":              "用户不存在",
+			"user_name_exists":            "用户名重复",
+			"invalid_user_name":           "用户名不能包含':'",
+			"object_not_found":            "对象不存在",
+			"save_user_failed":            "保存用户失败",
+			"delete_user_failed":          "删除用户失败",
+			"role_name_exists":            "角色名重复",
+			"save_role_failed":            "保存角色权限失败",
+			"delete_role_failed":          "删除角色失败",
+			"permission_name_exists":      "权限名重复",
+			"delete_permission_failed":    "删除权限失败",
+			"group_name_exists":           "组名重复",
+			"delete_group_failed":         "删除权限组失败",
+			"client_name_exists":          "客户端名重复",
+			"create_apikey_failed":        "创建API密钥失败",
+			"delete_apikey_failed":        "删除API密钥失败",
+			"approve_registration_failed": "审核通过失败",
+			"reject_registration_failed":  "拒绝注册失败",
+			"not_in_trash":                "回收站中没有该项",
+			"restore_failed":              "恢复失败",
+			"purge_failed":                "彻底删除失败",
+			"unavailable":                 "服务暂不可用，请稍后重试",
+			"search_unavailable":          "搜索服务未启用",
+			"invalid_sort":                "排序字段无效",
+		},
+		English: {
+			"ok":                          "ok",
+			"degraded":                    "read replicas are unreachable, reads are served by primary",
+			"permission_denied":           "permission denied",
+			"unauthorized":                "authentication is required",
+			"invalid_argument":            "invalid argument",
+			"not_found":                   "resource doesn't exist",
+			"already_exists":              "resource already exists",
+			"version_conflict":            "resource has been modified by others, reload and retry",
+			"unsupported_media":           "content type of request is unsupported",
+			"internal":                    "internal server error",
+			"invalid_parameter":           "parameter '%s' is invalid",
+			"invalid_query":               "query parameters are invalid",
+			"invalid_cursor":              "cursor is invalid",
+			"invalid_body":                "request body is invalid",
+			"invalid_patch":               "patch document is invalid or can't be applied",
+			"validation_failed":           "request fails validation",
+			"invalid_credentials":         "user name or password is wrong",
+			"token_required":              "access token must be provided",
+			"token_invalid":               "access token is invalid or revoked",
+			"apikey_invalid":              "api key is invalid or expired",
+			"user_not_found":              "user doesn't exist",
+			"user_name_exists":            "user name already exists",
+			"invalid_user_name":           "user name can't contain ':'",
+			"object_not_found":            "object doesn't exist",
+			"save_user_failed":            "save user failed",
+			"delete_user_failed":          "delete user failed",
+			"role_name_exists":            "role name already exists",
+			"save_role_failed":            "save permissions of role failed",
+			"delete_role_failed":          "delete role failed",
+			"permission_name_exists":      "permission name already exists",
+			"delete_permission_failed":    "delete permission failed",
+			"group_name_exists":           "group name already exists",
+			"delete_group_failed":         "delete permission group failed",
+			"client_name_exists":          "client name already exists",
+			"create_apikey_failed":        "create api key failed",
+			"delete_apikey_failed":        "delete api key failed",
+			"approve_registration_failed": "approve registration failed",
+			"reject_registration_failed":  "reject registration failed",
+			"not_in_trash":                "item isn't in trash",
+			"restore_failed":              "restore failed",
+			"purge_failed":                "purge failed",
+			"unavailable":                 "service is unavailable, retry later",
+			"search_unavailable":          "search is unavailable",
+			"invalid_sort":                "sort field is invalid",
+		},
+	}
+)
+
+// AddMessages add or replace messages of language
+func AddMessages(lang string, msgs map[string]string) {
+	lock.Lock()
+	defer lock.Unlock()
+	if messages[lang] == nil {
+		messages[lang] = make(map[string]string)
+	}
+	for k, v := range msgs {
+		messages[lang][k] = v
+	}
+}
+
+func lookup(lang, key string) (string, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if msg, ok := messages[lang][key]; ok {
+		return msg, true
+	}
+	msg, ok := messages[DefaultLanguage][key]
+	return msg, ok
+}
+
+// Language choose the supported language by Accept-Language header, e.g. "en-US,en;q=0.9"
+func Language(acceptLanguage string) string {
+	for _, item := range strings.Split(acceptLanguage, ",") {
+		tag := strings.TrimSpace(strings.SplitN(item, ";", 2)[0])
+		switch {
+		case tag == "":
+		case strings.HasPrefix(strings.ToLower(tag), "zh"):
+			return Chinese
+		case strings.HasPrefix(strings.ToLower(tag), "en"):
+			return English
+		}
+	}
+	return DefaultLanguage
+}
@@ -0,0 +1,116 @@
+// Package events is an in-process publish/subscribe bus of typed events
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Event is published on bus, subscribers receive the events of their topics
+type Event interface {
+	Topic() string
+}
+
+// Handler handles the events of subscribed topic
+type Handler func(e Event)
+
+type subscriber struct {
+	ch   chan Event
+	done chan struct{}
+}
+
+// Bus delivers events to the subscribers of their topics. Every subscriber has its own goroutine and queue,
+// events are handled in order of publishing. Publish never blocks, events are dropped for subscribers
+// whose queue is full and counted by Dropped
+type Bus struct {
+	lock        sync.RWMutex
+	subscribers map[string]map[*subscriber]bool
+	closed      bool
+	dropped     uint64
+}
+
+// Default is the bus shared by application
+var Default = NewBus()
+
+// NewBus create an event bus
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[string]map[*subscriber]bool)}
+}
+
+// Subscribe handle events of topic with h, buffer is the size of queue. The returned function unsubscribes,
+// events in queue are still handled before it returns
+func (b *Bus) Subscribe(topic string, buffer int, h Handler) (unsubscribe func()) {
+	s := &subscriber{ch: make(chan Event, buffer), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for e := range s.ch {
+			h(e)
+		}
+	}()
+
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		close(s.ch)
+		return func() {}
+	}
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = make(map[*subscriber]bool)
+	}
+	b.subscribers[topic][s] = true
+	b.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.lock.Lock()
+			if b.subscribers[topic][s] {
+				delete(b.subscribers[topic], s)
+				close(s.ch)
+			}
+			b.lock.Unlock()
+			<-s.done
+		})
+	}
+}
+
+// Publish deliver e to the subscribers of its topic, it's dropped for subscribers which fall behind
+func (b *Bus) Publish(e Event) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for s := range b.subscribers[e.Topic()] {
+		select {
+		case s.ch <- e:
+		default:
+			n := atomic.AddUint64(&b.dropped, 1)
+			logrus.Errorf("queue of a subscriber of %s is full, event is dropped, %d dropped in total", e.Topic(), n)
+		}
+	}
+}
+
+// Dropped return the number of events dropped because the queue of subscriber was full
+func (b *Bus) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// Close unsubscribe all subscribers after their queued events are handled, events published later are dropped
+func (b *Bus) Close() {
+	b.lock.Lock()
+	b.closed = true
+	subscribers := b.subscribers
+	b.subscribers = make(map[string]map[*subscriber]bool)
+	for _, topic := range subscribers {
+		for s := range topic {
+			close(s.ch)
+		}
+	}
+	b.lock.Unlock()
+
+	for _, topic := range subscribers {
+		for s := range topic {
+			<-s.done
+		}
+	}
+}
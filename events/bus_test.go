@@ -0,0 +1,58 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	var received []int64
+	unsubscribe := bus.Subscribe(UserTopic, 3, func(e Event) {
+		received = append(received, e.(*UserChanged).ID)
+	})
+	other := 0
+	bus.Subscribe("other", 0, func(e Event) {
+		other++
+	})
+
+	for i := int64(1); i <= 3; i++ {
+		bus.Publish(&UserChanged{Op: OpInsert, ID: i})
+	}
+	unsubscribe()
+	bus.Publish(&UserChanged{Op: OpDelete, ID: 4})
+
+	if len(received) != 3 || received[0] != 1 || received[2] != 3 {
+		t.Errorf("events should be handled in order until unsubscribed, got %v", received)
+	}
+	bus.Close()
+	if other != 0 {
+		t.Errorf("events of other topic shouldn't be received, got %d", other)
+	}
+}
+
+func TestBusDropsWhenQueueIsFull(t *testing.T) {
+	bus := NewBus()
+	started, release := make(chan struct{}), make(chan struct{})
+	var received []int64
+	bus.Subscribe(UserTopic, 1, func(e Event) {
+		if len(received) == 0 {
+			close(started)
+			<-release
+		}
+		received = append(received, e.(*UserChanged).ID)
+	})
+
+	bus.Publish(&UserChanged{Op: OpInsert, ID: 1})
+	<-started
+	bus.Publish(&UserChanged{Op: OpInsert, ID: 2})
+	bus.Publish(&UserChanged{Op: OpInsert, ID: 3})
+	close(release)
+	bus.Close()
+
+	if len(received) != 2 || received[1] != 2 {
+		t.Errorf("events beyond the queue should be dropped, got %v", received)
+	}
+	if bus.Dropped() != 1 {
+		t.Errorf("dropped should be 1, got %d", bus.Dropped())
+	}
+}
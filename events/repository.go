@@ -0,0 +1,89 @@
+package events
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/slover2000/beego_demo/models"
+)
+
+// UserRepository publishes UserChanged after users are changed through it, it's used by stores
+// which can't be watched. All changes of users are made through the repository set to models
+type UserRepository struct {
+	models.UserRepository
+	bus *Bus
+}
+
+// NewUserRepository publish the changes made through repo on bus
+func NewUserRepository(repo models.UserRepository, bus *Bus) *UserRepository {
+	return &UserRepository{UserRepository: repo, bus: bus}
+}
+
+func (r *UserRepository) Create(ctx context.Context, u *models.User) error {
+	if err := r.UserRepository.Create(ctx, u); err != nil {
+		return err
+	}
+	r.publish(OpInsert, u)
+	return nil
+}
+
+func (r *UserRepository) CreateHashed(ctx context.Context, u *models.User) error {
+	if err := r.UserRepository.CreateHashed(ctx, u); err != nil {
+		return err
+	}
+	r.publish(OpInsert, u)
+	return nil
+}
+
+func (r *UserRepository) Update(ctx context.Context, u *models.User) error {
+	if err := r.UserRepository.Update(ctx, u); err != nil {
+		return err
+	}
+	r.publish(OpUpdate, u)
+	return nil
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id int64, version int64) error {
+	if err := r.UserRepository.Delete(ctx, id, version); err != nil {
+		return err
+	}
+	r.bus.Publish(&UserChanged{Op: OpDelete, ID: id, At: time.Now()})
+	return nil
+}
+
+// Restore publish the restored user loaded from primary
+func (r *UserRepository) Restore(ctx context.Context, id int64) error {
+	if err := r.UserRepository.Restore(ctx, id); err != nil {
+		return err
+	}
+	u, err := r.UserRepository.Get(models.OnPrimary(ctx), id)
+	if err != nil {
+		return err
+	}
+	r.publish(OpInsert, u)
+	return nil
+}
+
+func (r *UserRepository) Purge(ctx context.Context, id int64) error {
+	if err := r.UserRepository.Purge(ctx, id); err != nil {
+		return err
+	}
+	r.bus.Publish(&UserChanged{Op: OpDelete, ID: id, At: time.Now()})
+	return nil
+}
+
+func (r *UserRepository) Import(ctx context.Context, u *models.User) error {
+	if err := r.UserRepository.Import(ctx, u); err != nil {
+		return err
+	}
+	r.publish(OpInsert, u)
+	return nil
+}
+
+// publish a copy of u without password, so subscribers never see it
+func (r *UserRepository) publish(op Op, u *models.User) {
+	user := *u
+	user.Password = ""
+	r.bus.Publish(&UserChanged{Op: op, ID: user.Id, User: &user, At: time.Now()})
+}
@@ -0,0 +1,39 @@
+package events
+
+import (
+	"time"
+
+	"github.com/slover2000/beego_demo/models"
+)
+
+// UserTopic is the topic of UserChanged
+const UserTopic = "user"
+
+// Op is the kind of change
+type Op string
+
+// kinds of change
+const (
+	OpInsert Op = "insert"
+	OpUpdate Op = "update"
+	OpDelete Op = "delete"
+)
+
+// UserChanged is published when a user is inserted, updated or deleted. User is the document after change,
+// it's nil when the user is deleted. Moving user to trash is an update whose user has DeletedAt
+type UserChanged struct {
+	Op   Op
+	ID   int64
+	User *models.User
+	At   time.Time
+}
+
+// Topic of user changes
+func (e *UserChanged) Topic() string {
+	return UserTopic
+}
+
+// Removed tell whether the user is gone from the view of api, it's deleted or in trash
+func (e *UserChanged) Removed() bool {
+	return e.User == nil || e.User.DeletedAt != nil
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -27,7 +28,11 @@ import (
 	"github.com/slover2000/prisma/trace"
 	"github.com/slover2000/prisma/trace/zipkin"
 
+	"github.com/slover2000/beego_demo/cache"
+	"github.com/slover2000/beego_demo/controllers"
 	"github.com/slover2000/beego_demo/dao"
+	"github.com/slover2000/beego_demo/events"
+	"github.com/slover2000/beego_demo/models"
 	_ "github.com/slover2000/beego_demo/routers"
 	"github.com/slover2000/beego_demo/services"
 )
@@ -38,7 +43,11 @@ const (
 
 // ServerConfig server configuration
 type ServerConfig struct {
-	MongoConfig dao.MongoConfig
+	RedisConfig    cache.RedisConfig
+	PostgresConfig models.PostgresConfig
+	MongoConfig    dao.MongoConfig
+	SearchConfig   services.SearchConfig
+	GRPCConfig     services.GRPCConfig
 }
 
 func initInterceptor() (*prisma.InterceptorClient, error) {
@@ -146,6 +155,40 @@ func main() {
 	}
 	m.MustLoad(serverConf) // Check for error
 
+	// the connection pools are shared by models, enforcer and repositories
+	db, err := models.OpenPostgres(&serverConf.PostgresConfig)
+	if err != nil {
+		log.Fatalf("init postgres failed:%s", err.Error())
+		return
+	}
+	defer db.Close()
+	models.SetDB(db)
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateSchema(os.Args[2:]); err != nil {
+			log.Fatalf("migrate failed:%s", err.Error())
+		}
+		return
+	}
+	// pending migrations are applied at startup if automigrate is on, otherwise run "migrate" before deploying
+	if serverConf.PostgresConfig.AutoMigrate {
+		if _, err := models.MigrateSchema(0); err != nil {
+			log.Fatalf("migrate failed:%s", err.Error())
+			return
+		}
+	}
+
+	if err := models.SetTokenKey(beego.AppConfig.String("jwt.key")); err != nil {
+		log.Fatalf("init jwt key failed:%s, set JWT_KEY environment variable", err.Error())
+		return
+	}
+
+	enforcer := models.NewSyncedEnforcer(db, true)
+	if err := enforcer.LoadPolicy(); err != nil {
+		log.Fatalf("load policy failed:%s", err.Error())
+		return
+	}
+
 	// init log
 	//logs.SetLogger(logs.AdapterFile,`{"filename":"access.log","level":6,"maxlines":0,"maxsize":0,"daily":true,"maxdays":7}`)
 	//logs.SetLogFuncCall(false)
@@ -157,12 +200,41 @@ func main() {
 	}
 	defer interceptorClient.Close()
 
-	err = services.InitHelloServiceClient("http://10.98.16.215:2379", interceptorClient)
+	err = services.InitGRPCClients(&serverConf.GRPCConfig, interceptorClient)
 	if err != nil {
-		log.Fatalf("init hello service failed:%s", err.Error())
+		log.Fatalf("init grpc clients failed:%s", err.Error())
 		return
 	}
-	defer services.CloseHelloServiceClient()
+	defer services.CloseGRPCClients()
+
+	err = services.InitMailer(&services.MailConfig{
+		Adapter:  beego.AppConfig.DefaultString("mail.adapter", "file"),
+		Host:     beego.AppConfig.String("mail.host"),
+		Port:     beego.AppConfig.DefaultInt("mail.port", 25),
+		User:     beego.AppConfig.String("mail.user"),
+		Password: beego.AppConfig.String("mail.password"),
+		From:     beego.AppConfig.String("mail.from"),
+		File:     beego.AppConfig.String("mail.file"),
+	})
+	if err != nil {
+		log.Fatalf("init mailer failed:%s", err.Error())
+		return
+	}
+
+	if beego.AppConfig.DefaultBool("oidc.enable", false) {
+		err = services.InitOIDCProvider(&services.OIDCConfig{
+			Issuer:       beego.AppConfig.String("oidc.issuer"),
+			ClientID:     beego.AppConfig.String("oidc.clientid"),
+			ClientSecret: beego.AppConfig.String("oidc.clientsecret"),
+			RedirectURL:  beego.AppConfig.String("oidc.redirecturl"),
+			Scopes:       strings.Fields(beego.AppConfig.String("oidc.scopes")),
+			GroupsClaim:  beego.AppConfig.String("oidc.groupsclaim"),
+		})
+		if err != nil {
+			log.Fatalf("init oidc provider failed:%s", err.Error())
+			return
+		}
+	}
 
 	// initialize mongo
 	err = dao.InitMongoClient(&serverConf.MongoConfig)
@@ -174,6 +246,92 @@ func main() {
 
 	interceptorClient.Enable3rdDBMetrics(prisma.MongoName)
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate-users" {
+		if err := migrateUsers(os.Args[2:], db); err != nil {
+			log.Fatalf("migrate users failed:%s", err.Error())
+		}
+		return
+	}
+
+	if serverConf.SearchConfig.Enable {
+		if err := services.InitSearchClient(&serverConf.SearchConfig); err != nil {
+			log.Fatalf("init search client failed:%s", err.Error())
+			return
+		}
+		defer services.CloseSearchClient()
+	}
+
+	userStore := beego.AppConfig.DefaultString("user.store", "postgres")
+	if len(os.Args) > 1 && os.Args[1] == "reindex-users" {
+		if err := reindexUsers(os.Args[2:], userStore, db); err != nil {
+			log.Fatalf("reindex users failed:%s", err.Error())
+		}
+		return
+	}
+
+	userRepository, err := newUserRepository(userStore, db)
+	if err != nil {
+		log.Fatalf("init user repository failed:%s", err.Error())
+		return
+	}
+	if serverConf.SearchConfig.Enable && serverConf.SearchConfig.Engine == "memory" {
+		// the in-process index starts empty
+		count, err := services.ReindexUsers(context.Background(), userRepository, 0)
+		if err != nil {
+			log.Fatalf("index users failed:%s", err.Error())
+			return
+		}
+		logrus.Infof("%d users are indexed in memory", count)
+	}
+	// changes of users are published on the event bus to keep caches and search index in sync,
+	// mongo is tailed for them and postgres publishes the changes made through the repository
+	defer events.Default.Close()
+	if userStore == "mongo" {
+		// tailing needs a replica set
+		watcher, err := dao.WatchUsers(events.Default)
+		if err != nil {
+			log.Printf("watch changes of users failed:%s", err.Error())
+		} else {
+			defer watcher.Stop()
+		}
+	} else {
+		userRepository = events.NewUserRepository(userRepository, events.Default)
+	}
+	defer services.IndexUserChanges(events.Default)()
+
+	// users and objects got by id are cached in redis and written through on save, changes of users on the event bus
+	// are written to cache too
+	var loader *cache.Loader
+	if serverConf.RedisConfig.Enable {
+		redisCache, err := cache.NewRedisCache(&serverConf.RedisConfig)
+		if err != nil {
+			log.Fatalf("init redis cache failed:%s", err.Error())
+			return
+		}
+		defer redisCache.Close()
+		loader = cache.NewLoader(redisCache)
+		ttl := time.Duration(serverConf.RedisConfig.TTL) * time.Second
+		userRepository = cache.NewUserRepository(userRepository, loader, ttl)
+		defer cache.SyncUsers(events.Default, redisCache, ttl)()
+	}
+	// login, tokens, roles and the admin console share the users of api
+	models.SetUserRepository(userRepository)
+	// background work of controllers stops when the server is shut down
+	stop := make(chan struct{})
+	defer close(stop)
+	controllers.Init(enforcer, stop)
+	beego.Get("/health", controllers.HealthCheck(db.Ping, db.PingReplicas))
+
+	objectRepository, err := newObjectRepository(beego.AppConfig.DefaultString("object.store", "postgres"), db)
+	if err != nil {
+		log.Fatalf("init object repository failed:%s", err.Error())
+		return
+	}
+	if loader != nil {
+		objectRepository = cache.NewObjectRepository(objectRepository, loader, time.Duration(serverConf.RedisConfig.TTL)*time.Second)
+	}
+	controllers.SetObjectRepository(objectRepository)
+
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL, syscall.SIGHUP, syscall.SIGQUIT)
 	go func(c chan os.Signal) {
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/slover2000/beego_demo/models"
+)
+
+// migrateSchema run the migrations of database, usage:
+//
+//	migrate [up [version]]   apply pending migrations up to version, all of them by default
+//	migrate down [steps]     revert the latest applied migrations, one by default
+//	migrate status           list migrations and whether they are applied
+func migrateSchema(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	var n int64
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.ParseInt(args[1], 10, 64); err != nil || n < 0 {
+			return fmt.Errorf("invalid argument '%s'", args[1])
+		}
+	}
+
+	switch command {
+	case "up":
+		// the generated password of seeded admin is only showed here
+		models.AdminPasswordOutput = os.Stdout
+		count, err := models.MigrateSchema(n)
+		log.Printf("%d migrations are applied", count)
+		return err
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		count, err := models.RollbackSchema(int(n))
+		log.Printf("%d migrations are reverted", count)
+		return err
+	case "status":
+		status, err := models.SchemaStatus()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%6d  %-30s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command '%s'", command)
+}
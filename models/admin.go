@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/lib/pq"	
+	"golang.org/x/net/context"
 )
 
 // Enforcer interface
@@ -12,20 +13,30 @@ type Enforcer interface {
 	RefreshPolicy()
 	GetRolesForUser(name string) []string
 	GetAllRoles() []CasbinRole
-	GetRoles(offset, limit int) ([]CasbinRole, int)
+	GetRoles(ctx context.Context, q *Query) ([]CasbinRole, int, error)
 	GetRole(id uint) (*CasbinRole, error)	
 	CreateRole(role *CasbinRole) error
 	SaveRole(id uint, permissionIDs []uint) error
 	DeleteRole(id uint) error
-	GetPermissions() []CasbinPermission
-	GetPermissionsWithoutEmpty() []CasbinPermission
+	RestoreRole(id uint) error
+	PurgeRole(id uint) error
+	GetPermissions(ctx context.Context) []CasbinPermission
+	GetPermissionsWithoutEmpty(ctx context.Context) []CasbinPermission
+	SearchPermissions(ctx context.Context, q *Query) ([]CasbinPermission, int, error)
 	GetChildPermissions(parent uint) []CasbinPermission
 	CreatePermission(p *CasbinPermission) error
 	DeletePermission(pid uint) error
+	RestorePermission(pid uint) error
+	PurgePermission(pid uint) error
 	GetUsers(offset, limit int) ([]CasbinUser, int)
 	GetUser(id int64) (*CasbinUser, error)
 	SaveUser(u *CasbinUser, roles []uint) error
 	DeleteUser(id int64, name string) error
+	RestoreUser(id int64) error
+	PurgeUser(id int64) error
+	GetAPIKeys(offset, limit int) ([]APIKey, int)
+	CreateAPIKey(k *APIKey, roles []uint) error
+	DeleteAPIKey(id uint) error
 	Enforce(user, resource, action string) bool
 }
 
@@ -44,6 +55,7 @@ type CasbinUser struct {
 	UpdatedAt time.Time
 	Name  string 				`gorm:"not null;unique"`
 	Roles pq.Int64Array `gorm:"type:integer[]"`
+	DeletedAt *time.Time `sql:"index"`
 }
 
 // CasbinRole represents casbin role 
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// APIKeyPrincipalPrefix prefixes the enforcer principal name of api keys
+	APIKeyPrincipalPrefix = "client:"
+	apiKeyPrefixSize      = 4
+	apiKeySecretSize      = 24
+)
+
+var (
+	// ErrInvalidAPIKey is returned when api key can't be verified
+	ErrInvalidAPIKey = errors.New("api key is invalid")
+	// ErrAPIKeyExpired is returned when api key is expired
+	ErrAPIKeyExpired = errors.New("api key has expired")
+	// ErrAPIKeyNameExists is returned when the name of api key has been taken, deleted keys keep their names
+	ErrAPIKeyNameExists = errors.New("api key name already exists")
+	// ErrInvalidUserName is returned when user name contains ':', which would take the principal of an api key
+	ErrInvalidUserName = errors.New("user name can't contain ':'")
+)
+
+// CheckUserName reject the user names which share the principal namespace of api keys
+func CheckUserName(name string) error {
+	if strings.Contains(name, ":") {
+		return ErrInvalidUserName
+	}
+	return nil
+}
+
+// APIKey represents a key of machine client, only the hash of key is stored
+type APIKey struct {
+	Model
+	Name       string        `json:"name" gorm:"not null;unique"`
+	Prefix     string        `json:"prefix" gorm:"not null;unique_index"`
+	KeyHash    string        `json:"-" gorm:"not null"`
+	Roles      pq.Int64Array `json:"roles" gorm:"type:integer[]"`
+	ExpiresAt  *time.Time    `json:"expires_at"`
+	LastUsedAt *time.Time    `json:"last_used_at"`
+}
+
+// Principal return the name used by enforcer for this key
+func (k *APIKey) Principal() string {
+	return APIKeyPrincipalPrefix + k.Name
+}
+
+// Expired check whether key is expired at the moment
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+// CasbinUser convert key to principal which can be loaded by enforcer
+func (k *APIKey) CasbinUser() CasbinUser {
+	return CasbinUser{Name: k.Principal(), Roles: k.Roles}
+}
+
+// GenerateAPIKey fill prefix and hash of key, the plain key is returned and can't be recovered later
+func GenerateAPIKey(k *APIKey) (string, error) {
+	prefix, err := randomToken(apiKeyPrefixSize)
+	if err != nil {
+		return "", err
+	}
+	secret, err := randomToken(apiKeySecretSize)
+	if err != nil {
+		return "", err
+	}
+
+	key := prefix + "." + secret
+	k.Prefix = prefix
+	k.KeyHash = hashToken(key)
+	return key, nil
+}
+
+// VerifyAPIKey check key sent by client and record its usage
+func VerifyAPIKey(clientID, key string) (*APIKey, error) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// revoked keys are deleted, so they are never found
+	k := &APIKey{}
+	if err := gormDB.Where("prefix = ?", parts[0]).First(k).Error; err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if err := checkAPIKey(k, clientID, key); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	k.LastUsedAt = &now
+	gormDB.Model(k).UpdateColumn("last_used_at", now)
+	return k, nil
+}
+
+// checkAPIKey check key sent by client against the stored key k whose prefix matches it
+func checkAPIKey(k *APIKey, clientID, key string) error {
+	if subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(hashToken(key))) != 1 {
+		return ErrInvalidAPIKey
+	}
+	if clientID != "" && clientID != k.Name {
+		return ErrInvalidAPIKey
+	}
+	if k.Expired() {
+		return ErrAPIKeyExpired
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateAndCheckAPIKey(t *testing.T) {
+	k := &APIKey{Name: "billing"}
+	key, err := GenerateAPIKey(k)
+	if err != nil {
+		t.Fatalf("generate api key failed:%v", err)
+	}
+	if !strings.HasPrefix(key, k.Prefix+".") || k.KeyHash == "" || strings.Contains(k.KeyHash, key) {
+		t.Fatalf("unexpected key %s with prefix %s", key, k.Prefix)
+	}
+
+	if err := checkAPIKey(k, "", key); err != nil {
+		t.Errorf("key should be valid, got %v", err)
+	}
+	if err := checkAPIKey(k, "billing", key); err != nil {
+		t.Errorf("key should be valid for its client, got %v", err)
+	}
+	if err := checkAPIKey(k, "reports", key); err != ErrInvalidAPIKey {
+		t.Errorf("key of other client should be invalid, got %v", err)
+	}
+	if err := checkAPIKey(k, "", key+"x"); err != ErrInvalidAPIKey {
+		t.Errorf("tampered key should be invalid, got %v", err)
+	}
+
+	other := &APIKey{Name: "billing"}
+	otherKey, _ := GenerateAPIKey(other)
+	if other.Prefix == k.Prefix || checkAPIKey(k, "", otherKey) != ErrInvalidAPIKey {
+		t.Error("keys should be unique")
+	}
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	k := &APIKey{Name: "billing"}
+	key, _ := GenerateAPIKey(k)
+
+	future := time.Now().Add(time.Hour)
+	k.ExpiresAt = &future
+	if k.Expired() || checkAPIKey(k, "", key) != nil {
+		t.Error("key shouldn't expire before its expiry time")
+	}
+
+	past := time.Now().Add(-time.Second)
+	k.ExpiresAt = &past
+	if !k.Expired() {
+		t.Error("key should expire after its expiry time")
+	}
+	if err := checkAPIKey(k, "", key); err != ErrAPIKeyExpired {
+		t.Errorf("expired key should be rejected, got %v", err)
+	}
+}
+
+func TestAPIKeyRevocation(t *testing.T) {
+	k := &APIKey{Name: "billing", Roles: []int64{1}}
+	m := NewModel(false)
+	m.Init([]CasbinUser{k.CasbinUser(), {Name: "billing", Roles: []int64{2}}},
+		[]CasbinRole{
+			{Model: Model{ID: 1}, Name: "reader", Permissions: []CasbinPermission{{Model: Model{ID: 10}}}},
+			{Model: Model{ID: 2}, Name: "writer", Permissions: []CasbinPermission{{Model: Model{ID: 11}}}},
+		},
+		[]CasbinPermission{
+			{Model: Model{ID: 10}, Parent: 1, Resource: "/v1/object", Action: "GET"},
+			{Model: Model{ID: 11}, Parent: 1, Resource: "/v1/object", Action: "POST"},
+		})
+
+	// the principal of key is apart from the user of the same name
+	if !m.HasPermission(k.Principal(), "/v1/object", "GET") || m.HasPermission(k.Principal(), "/v1/object", "POST") {
+		t.Error("key should only have permissions of its roles")
+	}
+	if m.HasPermission("billing", "/v1/object", "GET") {
+		t.Error("user shouldn't have permissions of key with the same name")
+	}
+
+	m.RemoveUser(k.Principal())
+	if m.HasPermission(k.Principal(), "/v1/object", "GET") {
+		t.Error("revoked key shouldn't have permissions")
+	}
+	if !m.HasPermission("billing", "/v1/object", "POST") {
+		t.Error("revoking key shouldn't affect the user of the same name")
+	}
+}
+
+func TestCheckUserName(t *testing.T) {
+	if err := CheckUserName("astaxie"); err != nil {
+		t.Errorf("name should be valid, got %v", err)
+	}
+	k := &APIKey{Name: "billing"}
+	if err := CheckUserName(k.Principal()); err != ErrInvalidUserName {
+		t.Errorf("principal of api key can't be a user name, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrWrongPassword is returned when user name or password is wrong
+	ErrWrongPassword = errors.New("user name or password is wrong")
+)
+
+// Authenticator verifies the credentials of user
+type Authenticator interface {
+	Name() string
+	Authenticate(name, password string) (*User, error)
+}
+
+// LocalAuthenticator verifies user by the bcrypt password stored in the user repository
+type LocalAuthenticator struct{}
+
+var authenticators = []Authenticator{&LocalAuthenticator{}}
+
+// SetAuthenticators replace the authenticators tried by GetAndVerifyUser in order
+func SetAuthenticators(a ...Authenticator) {
+	authenticators = a
+}
+
+func (a *LocalAuthenticator) Name() string {
+	return "local"
+}
+
+func (a *LocalAuthenticator) Authenticate(name, password string) (*User, error) {
+	user, err := Users().GetByName(context.Background(), name)
+	if err == nil && checkPasswordHash(password, user.Password) {
+		return user, nil
+	}
+	return nil, ErrWrongPassword
+}
+
+// ParseGroupRoleMapping parse mapping of external groups to role names like "group1:role1;group2:role2"
+func ParseGroupRoleMapping(mapping string) map[string]string {
+	groupRoles := make(map[string]string)
+	for _, item := range strings.Split(mapping, ";") {
+		pair := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(pair) == 2 {
+			groupRoles[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+		}
+	}
+	return groupRoles
+}
+
+// MapGroupsToRoles convert external groups to role ids, unknown groups and roles are ignored.
+// nil is returned when no group is mapped, so the roles of user are left as they are
+func MapGroupsToRoles(e Enforcer, groupRoles map[string]string, groups []string) []uint {
+	roleIDs := make(map[string]uint)
+	roleIDs[AdminRoleName] = AdminRoleID
+	for _, r := range e.GetAllRoles() {
+		roleIDs[r.Name] = r.ID
+	}
+
+	roles := make([]uint, 0)
+	seen := make(map[uint]bool)
+	for _, g := range groups {
+		if role, ok := groupRoles[g]; ok {
+			if id, ok := roleIDs[role]; ok && !seen[id] {
+				seen[id] = true
+				roles = append(roles, id)
+			}
+		}
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+	return roles
+}
@@ -1,39 +1,196 @@
 package models
 
 import (
+	"database/sql"
 	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
 
-	_ "github.com/lib/pq"
 	"github.com/jinzhu/gorm"
-	"github.com/astaxie/beego"
+	_ "github.com/lib/pq"
+	"golang.org/x/net/context"
 )
 
 var (
 	gormDB *gorm.DB
+	// cluster routes the list and get queries of models to read replicas
+	cluster *Cluster
 )
 
-func init() {
+// PostgresConfig is the connection config of postgres, replicas share database and credentials with primary
+type PostgresConfig struct {
+	Host        string `default:"127.0.0.1"`
+	Port        int    `default:"5432"`
+	Database    string `required:"true"`
+	User        string `required:"true"`
+	Password    string
+	SSLMode     string `default:"disable"`
+	AutoMigrate bool   `default:"false"`
+	// Replicas are the host:port of read replicas
+	Replicas []string
+	// pool of each server, lifetime is in seconds
+	MaxOpenConns    int `default:"20"`
+	MaxIdleConns    int `default:"5"`
+	ConnMaxLifetime int `default:"300"`
+	// QueryTimeout in milliseconds bounds the queries whose context has no deadline
+	QueryTimeout int `default:"5000"`
+}
+
+// Cluster is the connection pools of primary and read replicas,
+// writes go to primary and list or get queries are spread over replicas
+type Cluster struct {
+	primary      *gorm.DB
+	replicas     []*gorm.DB
+	next         uint32
+	queryTimeout time.Duration
+}
+
+// OpenPostgres create the connection pools of primary and replicas, they are shared by models, enforcer and repositories
+func OpenPostgres(cfg *PostgresConfig) (*Cluster, error) {
+	primary, err := openPostgres(cfg, cfg.Host, cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{primary: primary, queryTimeout: time.Duration(cfg.QueryTimeout) * time.Millisecond}
+
+	for _, addr := range cfg.Replicas {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("replica address '%s' is invalid:%v", addr, err)
+		}
+		portNum, _ := strconv.Atoi(port)
+		replica, err := openPostgres(cfg, host, portNum)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.replicas = append(c.replicas, replica)
+	}
+	return c, nil
+}
+
+func openPostgres(cfg *PostgresConfig, host string, port int) (*gorm.DB, error) {
 	dataSource := fmt.Sprintf(
-		"dbname=%s user=%s password=%s host=%s port=%d sslmode=disable",
-		beego.AppConfig.DefaultString("postgres.database", "beego"),
-		beego.AppConfig.DefaultString("postgres.user", "beego_group"),
-		beego.AppConfig.DefaultString("postgres.password", "123456"),
-		beego.AppConfig.DefaultString("postgres.host", "127.0.0.1"),
-		beego.AppConfig.DefaultInt("postgres.port", 5432))
+		"dbname=%s user=%s password=%s host=%s port=%d sslmode=%s",
+		cfg.Database, cfg.User, cfg.Password, host, port, cfg.SSLMode)
 
 	db, err := gorm.Open("postgres", dataSource)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("connect postgres %s:%d failed:%v", host, port, err)
+	}
+	db.DB().SetMaxOpenConns(cfg.MaxOpenConns)
+	db.DB().SetMaxIdleConns(cfg.MaxIdleConns)
+	db.DB().SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime) * time.Second)
+	db.SingularTable(true)
+	return db, nil
+}
+
+// Primary return the pool of primary
+func (c *Cluster) Primary() *gorm.DB {
+	return c.primary
+}
+
+// Replica return the pool of replicas in turn, it's primary if there are no replicas
+func (c *Cluster) Replica() *gorm.DB {
+	if len(c.replicas) == 0 {
+		return c.primary
+	}
+	n := atomic.AddUint32(&c.next, 1)
+	return c.replicas[int(n)%len(c.replicas)]
+}
+
+// Ping check primary is reachable before ctx is done
+func (c *Cluster) Ping(ctx context.Context) error {
+	return c.primary.DB().PingContext(ctx)
+}
+
+// PingReplicas check replicas are reachable before ctx is done, it returns the first failure
+func (c *Cluster) PingReplicas(ctx context.Context) error {
+	for _, db := range c.replicas {
+		if err := db.DB().PingContext(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close close all pools
+func (c *Cluster) Close() error {
+	err := c.primary.Close()
+	for _, db := range c.replicas {
+		if e := db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// withContext run fn in a transaction on db bound to ctx, the statements are cancelled when ctx is done
+// and postgres aborts them when the deadline of ctx passes. Query timeout is the deadline if ctx has none
+func (c *Cluster) withContext(ctx context.Context, db *gorm.DB, readOnly bool, fn func(tx *gorm.DB) error) error {
+	if _, ok := ctx.Deadline(); !ok && c.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.queryTimeout)
+		defer cancel()
+	}
+
+	tx := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: readOnly})
+	if tx.Error != nil {
+		return tx.Error
 	}
-	gormDB = db
-	gormDB.SingularTable(true)
-	if !gormDB.HasTable(&CasbinRole{}) {
-		gormDB.CreateTable(&CasbinRole{})
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline) / time.Millisecond
+		if timeout <= 0 {
+			tx.Rollback()
+			return context.DeadlineExceeded
+		}
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout)).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
-	if !gormDB.HasTable(&CasbinUser{}) {
-		gormDB.CreateTable(&CasbinUser{})
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
 	}
-	if !gormDB.HasTable(&CasbinPermission{}) {
-		gormDB.CreateTable(&CasbinPermission{})
+	return tx.Commit().Error
+}
+
+// read run fn on a replica, or on primary when ctx is marked by OnPrimary, see withContext
+func (c *Cluster) read(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	db := c.Replica()
+	if ReadsPrimary(ctx) {
+		db = c.primary
 	}
-}
\ No newline at end of file
+	return c.withContext(ctx, db, true, fn)
+}
+
+// write run fn on primary, see withContext
+func (c *Cluster) write(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	return c.withContext(ctx, c.primary, false, fn)
+}
+
+type primaryKey struct{}
+
+// OnPrimary mark ctx to read primary instead of replicas, the reads which a conditional write is based on
+// must not lag behind the version it checks. Cached results are bypassed too
+func OnPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// ReadsPrimary tell whether ctx is marked by OnPrimary
+func ReadsPrimary(ctx context.Context) bool {
+	on, _ := ctx.Value(primaryKey{}).(bool)
+	return on
+}
+
+// SetDB set the pools used by the functions of models, it must be called before using them.
+// Tables are created and changed by migrations, see MigrateSchema
+func SetDB(c *Cluster) {
+	gormDB = c.primary
+	cluster = c
+}
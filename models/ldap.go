@@ -0,0 +1,353 @@
+package models
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"gopkg.in/ldap.v2"
+)
+
+// SourceLDAP is the source of directory users, they are linked by dn
+const SourceLDAP = "ldap"
+
+var (
+	// ErrDirectoryUserNotFound is returned when user doesn't exist in directory or isn't unique
+	ErrDirectoryUserNotFound = errors.New("user doesn't exist in directory")
+)
+
+// LDAPConfig is the settings of directory authentication
+type LDAPConfig struct {
+	Addr          string
+	UseTLS        bool
+	StartTLS      bool
+	SkipVerify    bool
+	Timeout       time.Duration
+	BindDN        string
+	BindPassword  string
+	BaseDN        string
+	UserFilter    string // e.g. "(&(objectClass=person)(uid=%s))"
+	NameAttr      string
+	EmailAttr     string
+	GroupBaseDN   string
+	GroupFilter   string // e.g. "(&(objectClass=groupOfNames)(member=%s))", memberOf is used when it's empty
+	GroupNameAttr string
+	GroupRoles    map[string]string
+}
+
+// LDAPConn is the part of ldap connection used by authenticator
+type LDAPConn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+// DirectoryUser is a user found in directory
+type DirectoryUser struct {
+	DN     string
+	Name   string
+	Email  string
+	Groups []string
+}
+
+// LDAPAuthenticator verifies user by binding to directory, users are created in database at first login
+type LDAPAuthenticator struct {
+	config   LDAPConfig
+	enforcer Enforcer
+	dial     func() (LDAPConn, error)
+}
+
+// NewLDAPAuthenticator create a directory authenticator, roles of users are saved by enforcer
+func NewLDAPAuthenticator(cfg *LDAPConfig, e Enforcer) *LDAPAuthenticator {
+	a := &LDAPAuthenticator{config: *cfg, enforcer: e}
+	if a.config.UserFilter == "" {
+		a.config.UserFilter = "(&(objectClass=person)(uid=%s))"
+	}
+	if a.config.NameAttr == "" {
+		a.config.NameAttr = "uid"
+	}
+	if a.config.EmailAttr == "" {
+		a.config.EmailAttr = "mail"
+	}
+	if a.config.GroupBaseDN == "" {
+		a.config.GroupBaseDN = a.config.BaseDN
+	}
+	if a.config.GroupNameAttr == "" {
+		a.config.GroupNameAttr = "cn"
+	}
+	if a.config.Timeout == 0 {
+		a.config.Timeout = 5 * time.Second
+	}
+	a.dial = a.dialDirectory
+	return a
+}
+
+func (a *LDAPAuthenticator) dialDirectory() (LDAPConn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.config.SkipVerify}
+	host := strings.Split(a.config.Addr, ":")[0]
+	tlsConfig.ServerName = host
+
+	var conn *ldap.Conn
+	var err error
+	if a.config.UseTLS {
+		conn, err = ldap.DialTLS("tcp", a.config.Addr, tlsConfig)
+	} else {
+		conn, err = ldap.Dial("tcp", a.config.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.config.Timeout)
+
+	if a.config.StartTLS && !a.config.UseTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (a *LDAPAuthenticator) Name() string {
+	return "ldap"
+}
+
+// Authenticate verify password by directory and sync user into database
+func (a *LDAPAuthenticator) Authenticate(name, password string) (*User, error) {
+	du, err := a.Verify(name, password)
+	if err != nil {
+		return nil, err
+	}
+	return a.syncUser(du)
+}
+
+// Verify bind as the user found by name, the groups of user are loaded as well
+func (a *LDAPAuthenticator) Verify(name, password string) (*DirectoryUser, error) {
+	// directory accepts unauthenticated bind with empty password
+	if name == "" || password == "" {
+		return nil, ErrWrongPassword
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+	users, err := a.searchUsers(conn, fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(name)))
+	if err != nil {
+		return nil, err
+	}
+	if len(users) != 1 {
+		return nil, ErrDirectoryUserNotFound
+	}
+	du := users[0]
+
+	if err := conn.Bind(du.DN, password); err != nil {
+		return nil, ErrWrongPassword
+	}
+	// groups are searched with service account
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+	if err := a.loadGroups(conn, du); err != nil {
+		return nil, err
+	}
+	return du, nil
+}
+
+// DirectoryUsers list all users matched by user filter
+func (a *LDAPAuthenticator) DirectoryUsers() ([]*DirectoryUser, error) {
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+	users, err := a.searchUsers(conn, fmt.Sprintf(a.config.UserFilter, "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, du := range users {
+		if err := a.loadGroups(conn, du); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// Sync create or update all directory users in database, the directory users which are gone from directory
+// are moved to trash with their roles and sessions
+func (a *LDAPAuthenticator) Sync() error {
+	users, err := a.DirectoryUsers()
+	if err != nil {
+		return err
+	}
+	dns := make(map[string]bool, len(users))
+	for _, du := range users {
+		dns[du.DN] = true
+		_, err := a.syncUser(du)
+		if err == ErrUserNameExists || err == ErrUserNotFound {
+			// names taken by local users need admin, users in trash stay there
+			log.Printf("skip directory user '%s':%v", du.DN, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// an empty result is more likely a broken filter than a directory without users
+	if len(users) == 0 {
+		return nil
+	}
+	return a.trashMissingUsers(context.Background(), dns)
+}
+
+// trashMissingUsers move the directory users whose dn isn't in dns to trash
+func (a *LDAPAuthenticator) trashMissingUsers(ctx context.Context, dns map[string]bool) error {
+	var missing []User
+	q := NewQuery(UserQuerySchema, 0, 100)
+	q.Filters = []Filter{{Field: "source", Op: "=", Value: SourceLDAP}}
+	for {
+		users, _, err := Users().List(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			if !dns[u.ExternalID] {
+				missing = append(missing, u)
+			}
+		}
+		if len(users) < q.Limit {
+			break
+		}
+		q.Offset += q.Limit
+	}
+
+	for _, u := range missing {
+		if err := Users().Delete(ctx, u.Id, AnyVersion); err != nil && err != ErrUserNotFound {
+			return err
+		}
+		if err := a.enforcer.DeleteUser(u.Id, u.Name); err != nil {
+			return err
+		}
+		if err := SignOutEverywhere(u.Id); err != nil {
+			return err
+		}
+		log.Printf("directory user '%s' is gone from directory and moved to trash", u.ExternalID)
+	}
+	return nil
+}
+
+// SyncPeriodically run Sync by interval until stop is closed
+func (a *LDAPAuthenticator) SyncPeriodically(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := a.Sync(); err != nil {
+			log.Printf("sync directory users failed:%v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (a *LDAPAuthenticator) bindService(conn LDAPConn) error {
+	if a.config.BindDN == "" {
+		return nil
+	}
+	return conn.Bind(a.config.BindDN, a.config.BindPassword)
+}
+
+func (a *LDAPAuthenticator) searchUsers(conn LDAPConn, filter string) ([]*DirectoryUser, error) {
+	req := ldap.NewSearchRequest(
+		a.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{a.config.NameAttr, a.config.EmailAttr, "memberOf"},
+		nil)
+	result, err := conn.Search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*DirectoryUser, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		du := &DirectoryUser{
+			DN:    entry.DN,
+			Name:  entry.GetAttributeValue(a.config.NameAttr),
+			Email: entry.GetAttributeValue(a.config.EmailAttr),
+		}
+		if a.config.GroupFilter == "" {
+			for _, dn := range entry.GetAttributeValues("memberOf") {
+				du.Groups = append(du.Groups, groupNameOfDN(dn))
+			}
+		}
+		users = append(users, du)
+	}
+	return users, nil
+}
+
+func (a *LDAPAuthenticator) loadGroups(conn LDAPConn, du *DirectoryUser) error {
+	if a.config.GroupFilter == "" {
+		return nil
+	}
+
+	req := ldap.NewSearchRequest(
+		a.config.GroupBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(a.config.GroupFilter, ldap.EscapeFilter(du.DN)),
+		[]string{a.config.GroupNameAttr},
+		nil)
+	result, err := conn.Search(req)
+	if err != nil {
+		return err
+	}
+	du.Groups = make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		du.Groups = append(du.Groups, entry.GetAttributeValue(a.config.GroupNameAttr))
+	}
+	return nil
+}
+
+// groupNameOfDN take the first rdn value of group dn, e.g. "cn=devs,ou=groups,dc=example,dc=com" is "devs"
+func groupNameOfDN(dn string) string {
+	rdn := strings.SplitN(dn, ",", 2)[0]
+	if i := strings.Index(rdn, "="); i >= 0 {
+		return rdn[i+1:]
+	}
+	return rdn
+}
+
+// syncUser find or create the user linked to dn of directory user, local users of the same name are never taken over
+func (a *LDAPAuthenticator) syncUser(du *DirectoryUser) (*User, error) {
+	user, created, err := GetOrCreateExternalUser(context.Background(), SourceLDAP, du.DN, du.Name, du.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	// roles follow the groups of directory when mapping is configured and any group is mapped
+	var roles []uint
+	if len(a.config.GroupRoles) > 0 {
+		roles = MapGroupsToRoles(a.enforcer, a.config.GroupRoles, du.Groups)
+	}
+	if roles != nil {
+		err = a.enforcer.SaveUser(&CasbinUser{ID: user.Id, Name: user.Name}, roles)
+	} else if created {
+		err = a.enforcer.SaveUser(&CasbinUser{ID: user.Id, Name: user.Name}, []uint{})
+	}
+	return user, err
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"regexp"
+	"testing"
+
+	"gopkg.in/ldap.v2"
+)
+
+// stubDirectory is an in-process directory which understands the filters built by LDAPAuthenticator
+type stubDirectory struct {
+	passwords map[string]string
+	users     []*ldap.Entry
+	groups    []*ldap.Entry
+	bound     string
+}
+
+var filterValue = regexp.MustCompile(`\((uid|member)=([^)]*)\)`)
+
+func newStubDirectory() *stubDirectory {
+	return &stubDirectory{
+		passwords: map[string]string{
+			"cn=admin,dc=example,dc=com":            "secret",
+			"uid=alice,ou=people,dc=example,dc=com": "alice123",
+			"uid=bob,ou=people,dc=example,dc=com":   "bob123",
+		},
+		users: []*ldap.Entry{
+			ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":      {"alice"},
+				"mail":     {"alice@example.com"},
+				"memberOf": {"cn=devs,ou=groups,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("uid=bob,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":  {"bob"},
+				"mail": {"bob@example.com"},
+			}),
+		},
+		groups: []*ldap.Entry{
+			ldap.NewEntry("cn=ops,ou=groups,dc=example,dc=com", map[string][]string{
+				"cn":     {"ops"},
+				"member": {"uid=bob,ou=people,dc=example,dc=com"},
+			}),
+		},
+	}
+}
+
+func (d *stubDirectory) Bind(username, password string) error {
+	if pw, ok := d.passwords[username]; !ok || pw != password {
+		return &ldap.Error{ResultCode: ldap.LDAPResultInvalidCredentials}
+	}
+	d.bound = username
+	return nil
+}
+
+func (d *stubDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if d.bound != "cn=admin,dc=example,dc=com" {
+		return nil, &ldap.Error{ResultCode: ldap.LDAPResultInvalidCredentials}
+	}
+
+	m := filterValue.FindStringSubmatch(req.Filter)
+	result := &ldap.SearchResult{}
+	entries := d.users
+	if m[1] == "member" {
+		entries = d.groups
+	}
+	for _, e := range entries {
+		for _, v := range e.GetAttributeValues(m[1]) {
+			if m[2] == "*" || v == m[2] {
+				result.Entries = append(result.Entries, e)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (d *stubDirectory) Close() {}
+
+func newStubAuthenticator(d *stubDirectory, groupFilter string) *LDAPAuthenticator {
+	a := NewLDAPAuthenticator(&LDAPConfig{
+		BindDN:       "cn=admin,dc=example,dc=com",
+		BindPassword: "secret",
+		BaseDN:       "dc=example,dc=com",
+		GroupFilter:  groupFilter,
+	}, nil)
+	a.dial = func() (LDAPConn, error) { return d, nil }
+	return a
+}
+
+func TestLDAPVerify(t *testing.T) {
+	a := newStubAuthenticator(newStubDirectory(), "")
+
+	du, err := a.Verify("alice", "alice123")
+	if err != nil {
+		t.Fatalf("verify alice failed:%v", err)
+	}
+	if du.Name != "alice" || du.Email != "alice@example.com" || len(du.Groups) != 1 || du.Groups[0] != "devs" {
+		t.Errorf("unexpected directory user:%+v", du)
+	}
+
+	if _, err := a.Verify("alice", "wrong"); err != ErrWrongPassword {
+		t.Errorf("wrong password should be rejected, got %v", err)
+	}
+	if _, err := a.Verify("alice", ""); err != ErrWrongPassword {
+		t.Errorf("empty password should be rejected, got %v", err)
+	}
+	if _, err := a.Verify("carol", "carol123"); err != ErrDirectoryUserNotFound {
+		t.Errorf("unknown user should be rejected, got %v", err)
+	}
+}
+
+func TestLDAPGroupFilterAndUsers(t *testing.T) {
+	a := newStubAuthenticator(newStubDirectory(), "(member=%s)")
+
+	du, err := a.Verify("bob", "bob123")
+	if err != nil {
+		t.Fatalf("verify bob failed:%v", err)
+	}
+	if len(du.Groups) != 1 || du.Groups[0] != "ops" {
+		t.Errorf("unexpected groups of bob:%v", du.Groups)
+	}
+
+	users, err := a.DirectoryUsers()
+	if err != nil {
+		t.Fatalf("list directory users failed:%v", err)
+	}
+	if len(users) != 2 {
+		t.Errorf("expect 2 directory users but got %d", len(users))
+	}
+}
+
+func TestParseGroupRoleMapping(t *testing.T) {
+	mapping := ParseGroupRoleMapping("devs:developer; ops : admin;broken")
+	if len(mapping) != 2 || mapping["devs"] != "developer" || mapping["ops"] != "admin" {
+		t.Errorf("unexpected mapping:%v", mapping)
+	}
+}
+
+// rolesEnforcer only knows all roles
+type rolesEnforcer struct {
+	Enforcer
+	roles []CasbinRole
+}
+
+func (e *rolesEnforcer) GetAllRoles() []CasbinRole {
+	return e.roles
+}
+
+func TestMapGroupsToRoles(t *testing.T) {
+	e := &rolesEnforcer{roles: []CasbinRole{{Model: Model{ID: 3}, Name: "developer"}}}
+	mapping := ParseGroupRoleMapping("devs:developer;ops:admin;qa:tester")
+
+	roles := MapGroupsToRoles(e, mapping, []string{"devs", "ops", "devs"})
+	if len(roles) != 2 || roles[0] != 3 || roles[1] != AdminRoleID {
+		t.Errorf("devs and ops should be mapped to developer and admin once, got %v", roles)
+	}
+	if roles := MapGroupsToRoles(e, mapping, []string{"qa", "sales"}); roles != nil {
+		t.Errorf("roles shouldn't be replaced when no group is mapped, got %v", roles)
+	}
+}
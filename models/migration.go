@@ -0,0 +1,198 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// migrationLockKey is the key of postgres advisory lock taken by migration runners,
+// concurrent runners apply migrations one after another
+const migrationLockKey = 20180101
+
+// ErrUnknownMigration is returned when the target version isn't one of migrations
+var ErrUnknownMigration = errors.New("unknown migration version")
+
+// Migration is a versioned change of schema or data, Up applies it and Down reverts it.
+// Migrations are applied in the order of version and each one runs in a transaction
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version   int64 `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// MigrationStatus tells whether a migration is applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// SQLMigration create migration from up and down sql
+func SQLMigration(version int64, name, up, down string) Migration {
+	return Migration{
+		Version: version,
+		Name:    name,
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec(up).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec(down).Error
+		},
+	}
+}
+
+// IrreversibleMigration create migration from up sql without Down, so rolling it back fails
+func IrreversibleMigration(version int64, name, up string) Migration {
+	return Migration{
+		Version: version,
+		Name:    name,
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec(up).Error
+		},
+	}
+}
+
+// MigrateSchema apply pending migrations up to target version, all of them are applied when target is 0
+func MigrateSchema(target int64) (int, error) {
+	if target != 0 && findMigration(target) < 0 {
+		return 0, ErrUnknownMigration
+	}
+	if err := gormDB.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		applied, err := migrateStep(func(versions map[int64]time.Time) *Migration {
+			for i := range migrations {
+				m := &migrations[i]
+				if target != 0 && m.Version > target {
+					return nil
+				}
+				if _, ok := versions[m.Version]; !ok {
+					return m
+				}
+			}
+			return nil
+		}, true)
+		if err != nil || !applied {
+			return count, err
+		}
+		count++
+	}
+}
+
+// RollbackSchema revert the latest applied migrations, steps is the number of them
+func RollbackSchema(steps int) (int, error) {
+	if err := gormDB.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for count < steps {
+		reverted, err := migrateStep(func(versions map[int64]time.Time) *Migration {
+			for i := len(migrations) - 1; i >= 0; i-- {
+				if _, ok := versions[migrations[i].Version]; ok {
+					return &migrations[i]
+				}
+			}
+			return nil
+		}, false)
+		if err != nil || !reverted {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// SchemaStatus list migrations in order of version with their states
+func SchemaStatus() ([]MigrationStatus, error) {
+	if err := gormDB.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+	versions, err := appliedVersions(gormDB)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i := range migrations {
+		status[i].Migration = migrations[i]
+		status[i].AppliedAt, status[i].Applied = versions[migrations[i].Version]
+	}
+	return status, nil
+}
+
+// migrateStep apply or revert the migration chosen by next in a transaction holding the migration lock,
+// the applied versions are read after the lock is taken so a migration is never run twice
+func migrateStep(next func(versions map[int64]time.Time) *Migration, up bool) (bool, error) {
+	tx := gormDB.Begin()
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockKey).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	versions, err := appliedVersions(tx)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	m := next(versions)
+	if m == nil {
+		tx.Rollback()
+		return false, nil
+	}
+
+	if up {
+		err = m.Up(tx)
+		if err == nil {
+			err = tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		}
+	} else {
+		if m.Down == nil {
+			err = errors.New("migration can't be reverted")
+		} else {
+			err = m.Down(tx)
+		}
+		if err == nil {
+			err = tx.Delete(&SchemaMigration{Version: m.Version}).Error
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("migration %d '%s':%v", m.Version, m.Name, err)
+	}
+	return true, tx.Commit().Error
+}
+
+func appliedVersions(db *gorm.DB) (map[int64]time.Time, error) {
+	var records []SchemaMigration
+	if err := db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	versions := make(map[int64]time.Time, len(records))
+	for _, r := range records {
+		versions[r.Version] = r.AppliedAt
+	}
+	return versions, nil
+}
+
+func findMigration(version int64) int {
+	for i := range migrations {
+		if migrations[i].Version == version {
+			return i
+		}
+	}
+	return -1
+}
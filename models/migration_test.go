@@ -0,0 +1,25 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.Up == nil || m.Name == "" {
+			t.Errorf("migration %d must have name and up", m.Version)
+		}
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			t.Errorf("version of migration '%s' must be greater than %d", m.Name, migrations[i-1].Version)
+		}
+	}
+	if findMigration(migrations[len(migrations)-1].Version) != len(migrations)-1 || findMigration(0) != -1 {
+		t.Error("migration should be found by version")
+	}
+}
+
+func TestCreateTablesIrreversible(t *testing.T) {
+	if m := migrations[findMigration(1)]; m.Down != nil {
+		t.Error("tables which existed before migrations shouldn't be dropped by rolling back migration 1")
+	}
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/astaxie/beego"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// migrations in order of version, a released migration must not be changed,
+// changes of schema are appended as new migrations. They are written in sql instead of
+// migrating the structs of models, which change with later versions
+var migrations = []Migration{
+	// the tables and columns which existed before migrations are kept, so it's applied to existing databases safely.
+	// It can't be reverted since dropping the tables would lose the data which existed before
+	IrreversibleMigration(1, "create tables", `
+CREATE TABLE IF NOT EXISTS user2 (
+	id bigserial PRIMARY KEY,
+	name varchar(255) NOT NULL UNIQUE,
+	password varchar(255) NOT NULL,
+	create_time timestamp with time zone,
+	update_time timestamp with time zone,
+	profile text
+);
+ALTER TABLE user2 ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+CREATE INDEX IF NOT EXISTS idx_user2_deleted_at ON user2 (deleted_at);
+
+CREATE TABLE IF NOT EXISTS casbin_user (
+	id bigserial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	name varchar(255) NOT NULL UNIQUE,
+	roles integer[]
+);
+ALTER TABLE casbin_user ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+CREATE INDEX IF NOT EXISTS idx_casbin_user_deleted_at ON casbin_user (deleted_at);
+
+CREATE TABLE IF NOT EXISTS casbin_role (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	name varchar(255) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_casbin_role_deleted_at ON casbin_role (deleted_at);
+
+CREATE TABLE IF NOT EXISTS casbin_permission (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	name varchar(255) NOT NULL,
+	parent integer,
+	resource varchar(255),
+	action varchar(255)
+);
+CREATE INDEX IF NOT EXISTS idx_casbin_permission_deleted_at ON casbin_permission (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_casbin_permission_parent ON casbin_permission (parent);
+
+CREATE TABLE IF NOT EXISTS casbin_role_permission (
+	casbin_role_id integer,
+	casbin_permission_id integer,
+	PRIMARY KEY (casbin_role_id, casbin_permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS refresh_token (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	token_hash varchar(255) NOT NULL,
+	access_id varchar(255),
+	user_id bigint,
+	name varchar(255) NOT NULL,
+	expires_at timestamp with time zone NOT NULL,
+	revoked boolean
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_deleted_at ON refresh_token (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_refresh_token_token_hash ON refresh_token (token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_access_id ON refresh_token (access_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_user_id ON refresh_token (user_id);
+
+CREATE TABLE IF NOT EXISTS revoked_token (
+	id varchar(255) PRIMARY KEY,
+	created_at timestamp with time zone,
+	expires_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_token_expires_at ON revoked_token (expires_at);
+
+CREATE TABLE IF NOT EXISTS api_key (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	name varchar(255) NOT NULL UNIQUE,
+	prefix varchar(255) NOT NULL,
+	key_hash varchar(255) NOT NULL,
+	roles integer[],
+	expires_at timestamp with time zone,
+	last_used_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_api_key_deleted_at ON api_key (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_api_key_prefix ON api_key (prefix);
+
+CREATE TABLE IF NOT EXISTS registration (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	name varchar(255) NOT NULL,
+	password varchar(255) NOT NULL,
+	email varchar(255) NOT NULL,
+	token_hash varchar(255) NOT NULL,
+	expires_at timestamp with time zone,
+	state integer
+);
+CREATE INDEX IF NOT EXISTS idx_registration_deleted_at ON registration (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_registration_name ON registration (name);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_registration_token_hash ON registration (token_hash);
+CREATE INDEX IF NOT EXISTS idx_registration_state ON registration (state);
+
+CREATE TABLE IF NOT EXISTS remember_token (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	series varchar(255) NOT NULL,
+	token_hash varchar(255) NOT NULL,
+	user_id bigint,
+	name varchar(255) NOT NULL,
+	expires_at timestamp with time zone NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_remember_token_deleted_at ON remember_token (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_remember_token_series ON remember_token (series);
+CREATE INDEX IF NOT EXISTS idx_remember_token_user_id ON remember_token (user_id);
+
+CREATE TABLE IF NOT EXISTS session_revocation (
+	user_id bigint PRIMARY KEY,
+	revoked_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS object (
+	object_id varchar(255) PRIMARY KEY,
+	score bigint,
+	player_name varchar(255),
+	version bigint NOT NULL DEFAULT 0
+)`),
+	{
+		Version: 2,
+		Name:    "seed admin",
+		Up:      seedAdmin,
+		Down: func(tx *gorm.DB) error {
+			name := beego.AppConfig.DefaultString("admin.name", "admin")
+			if err := tx.Exec("DELETE FROM casbin_user WHERE name = ?", name).Error; err != nil {
+				return err
+			}
+			return tx.Exec("DELETE FROM user2 WHERE name = ?", name).Error
+		},
+	},
+	// users of identity providers and directory are linked by their accounts instead of names
+	SQLMigration(3, "link external users",
+		`ALTER TABLE user2 ADD COLUMN IF NOT EXISTS source varchar(255) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS external_id varchar(255) NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user2_source_external_id ON user2 (source, external_id) WHERE source <> ''`,
+		`DROP INDEX IF EXISTS idx_user2_source_external_id;
+ALTER TABLE user2 DROP COLUMN IF EXISTS external_id, DROP COLUMN IF EXISTS source`),
+	{
+		// admin role is virtual, the row inserted by earlier seed showed up as a role which can't be edited
+		Version: 4,
+		Name:    "remove admin role row",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Exec("DELETE FROM casbin_role_permission WHERE casbin_role_id = ?", AdminRoleID).Error; err != nil {
+				return err
+			}
+			return tx.Exec("DELETE FROM casbin_role WHERE id = ?", AdminRoleID).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	},
+}
+
+// AdminPasswordOutput receives the password generated for the admin user seeded by migrations when
+// admin.password is empty, e.g. stdout of the migrate command. Seeding fails without admin.password
+// or the output, so the password never goes to logs
+var AdminPasswordOutput io.Writer
+
+// seedAdmin create the first admin user named by admin.name with the virtual admin role,
+// a random password is generated and written to AdminPasswordOutput when admin.password is empty
+func seedAdmin(tx *gorm.DB) error {
+	name := beego.AppConfig.DefaultString("admin.name", "admin")
+	var count int
+	if err := tx.Raw("SELECT count(*) FROM user2 WHERE name = ?", name).Row().Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	var err error
+	password := beego.AppConfig.String("admin.password")
+	if password == "" {
+		if AdminPasswordOutput == nil {
+			return errors.New("admin.password is required to seed the admin user, or run the migrate command to generate one")
+		}
+		if password, err = randomToken(8); err != nil {
+			return err
+		}
+		fmt.Fprintf(AdminPasswordOutput, "admin user '%s' is created with password '%s', change it after login\n", name, password)
+	}
+	hash, err := encryptPassword(password)
+	if err != nil {
+		return err
+	}
+	// the tables are of this version, later columns of models don't exist yet
+	var id int64
+	err = tx.Raw(`INSERT INTO user2 (name, password, profile, create_time, update_time)
+VALUES (?, ?, '{}', now(), now()) RETURNING id`, name, hash).Row().Scan(&id)
+	if err != nil {
+		return err
+	}
+	return tx.Exec("INSERT INTO casbin_user (id, name, roles, created_at, updated_at) VALUES (?, ?, ?, now(), now())",
+		id, name, pq.Int64Array{AdminRoleID}).Error
+}
@@ -138,6 +138,16 @@ func (m *EnforcerModel) UpdateUser(user string, roles []uint) {
 		if m.autoRefresh {
 			cache.permissions = m.buildPermissions(roles)
 		}
+	} else {
+		// user created after policy was loaded
+		hasAdminRole := false
+		for i := range roles {
+			if roles[i] == AdminRoleID {
+				hasAdminRole = true
+				break
+			}
+		}
+		m.Users[user] = &userCache{hasAdminRole: hasAdminRole, roles: roles, permissions: m.buildPermissions(roles)}
 	}
 }
 
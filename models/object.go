@@ -1,53 +1,232 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
 	"strconv"
+	"sync"
 	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/net/context"
 )
 
 var (
-	Objects map[string]*Object
+	// ErrObjectNotFound is returned when object doesn't exist in repository
+	ErrObjectNotFound = errors.New("ObjectId Not Exist")
 )
 
 type Object struct {
-	ObjectId   string
-	Score      int64
-	PlayerName string
+	ObjectId   string `bson:"_id" gorm:"primary_key"`
+	Score      int64  `bson:"score"`
+	PlayerName string `bson:"player_name" valid:"MaxSize(64)"`
+	// Version is increased by every update, objects saved before versioning are of version 0
+	Version    int64  `bson:"version" gorm:"not null;default:0"`
+}
+
+// ObjectRepository is the storage of objects of the rest api
+type ObjectRepository interface {
+	Get(ctx context.Context, id string) (*Object, error)
+	// List return objects in page of query and the total number of objects matched by query
+	List(ctx context.Context, q *Query) ([]Object, int, error)
+	// Create assign id of object and save it
+	Create(ctx context.Context, o *Object) error
+	// Update save score and player of object when its version is the stored one, ErrVersionConflict is returned
+	// when the object has been changed by others, the version of o is increased after saving
+	Update(ctx context.Context, o *Object) error
+	// Delete remove object of the version, AnyVersion removes it unconditionally
+	Delete(ctx context.Context, id string, version int64) error
+}
+
+// NewObjectID generate id for a new object
+func NewObjectID() string {
+	return "astaxie" + strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+// MemoryObjectRepository keeps objects in memory, it's safe for concurrent use
+type MemoryObjectRepository struct {
+	lock    sync.RWMutex
+	objects map[string]Object
+}
+
+// NewMemoryObjectRepository create an in-memory object repository with initial objects
+func NewMemoryObjectRepository(objects ...Object) *MemoryObjectRepository {
+	r := &MemoryObjectRepository{objects: make(map[string]Object)}
+	for _, o := range objects {
+		r.objects[o.ObjectId] = o
+	}
+	return r
+}
+
+func (r *MemoryObjectRepository) Get(ctx context.Context, id string) (*Object, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if o, ok := r.objects[id]; ok {
+		return &o, nil
+	}
+	return nil, ErrObjectNotFound
+}
+
+func (r *MemoryObjectRepository) List(ctx context.Context, q *Query) ([]Object, int, error) {
+	r.lock.RLock()
+	objects := make([]Object, 0, len(r.objects))
+	for _, o := range r.objects {
+		objects = append(objects, o)
+	}
+	r.lock.RUnlock()
+
+	// filters and sorts work on the json representation like the api does
+	data, err := json.Marshal(objects)
+	if err != nil {
+		return nil, 0, err
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, 0, err
+	}
+	indexes, total := q.Apply(rows)
+	page := make([]Object, 0, len(indexes))
+	for _, i := range indexes {
+		page = append(page, objects[i])
+	}
+	return page, total, nil
+}
+
+func (r *MemoryObjectRepository) Create(ctx context.Context, o *Object) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	o.ObjectId = NewObjectID()
+	for r.objects[o.ObjectId].ObjectId != "" {
+		o.ObjectId = NewObjectID()
+	}
+	o.Version = 1
+	r.objects[o.ObjectId] = *o
+	return nil
 }
 
-func init() {
-	Objects = make(map[string]*Object)
-	Objects["hjkhsbnmn123"] = &Object{"hjkhsbnmn123", 100, "astaxie"}
-	Objects["mjjkxsxsaa23"] = &Object{"mjjkxsxsaa23", 101, "someone"}
+func (r *MemoryObjectRepository) Update(ctx context.Context, o *Object) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stored, ok := r.objects[o.ObjectId]
+	if !ok {
+		return ErrObjectNotFound
+	}
+	if stored.Version != o.Version {
+		return ErrVersionConflict
+	}
+	o.Version++
+	r.objects[o.ObjectId] = *o
+	return nil
 }
 
-func AddOne(object Object) (ObjectId string) {
-	object.ObjectId = "astaxie" + strconv.FormatInt(time.Now().UnixNano(), 10)
-	Objects[object.ObjectId] = &object
-	return object.ObjectId
+func (r *MemoryObjectRepository) Delete(ctx context.Context, id string, version int64) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stored, ok := r.objects[id]
+	if !ok {
+		return ErrObjectNotFound
+	}
+	if version != AnyVersion && stored.Version != version {
+		return ErrVersionConflict
+	}
+	delete(r.objects, id)
+	return nil
 }
 
-func GetOne(ObjectId string) (object *Object, err error) {
-	if v, ok := Objects[ObjectId]; ok {
-		return v, nil
+// PostgresObjectRepository stores objects in the object table of postgres,
+// objects are read from replicas unless ctx is marked by OnPrimary, the reasons of failed writes are checked on primary
+type PostgresObjectRepository struct {
+	db *Cluster
+}
+
+// NewPostgresObjectRepository create the object repository of postgres on the connection pools of db
+func NewPostgresObjectRepository(db *Cluster) *PostgresObjectRepository {
+	return &PostgresObjectRepository{db: db}
+}
+
+func (r *PostgresObjectRepository) Get(ctx context.Context, id string) (*Object, error) {
+	return r.get(ctx, id, r.db.read)
+}
+
+func (r *PostgresObjectRepository) get(ctx context.Context, id string, run func(context.Context, func(*gorm.DB) error) error) (*Object, error) {
+	o := &Object{}
+	err := run(ctx, func(tx *gorm.DB) error {
+		return tx.Where("object_id = ?", id).First(o).Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (r *PostgresObjectRepository) List(ctx context.Context, q *Query) ([]Object, int, error) {
+	var count int
+	var objects []Object
+	err := r.db.read(ctx, func(tx *gorm.DB) error {
+		if err := q.Where(tx.Model(&Object{})).Count(&count).Error; err != nil {
+			return err
+		}
+		return q.Page(q.Where(tx)).Find(&objects).Error
+	})
+	if err != nil {
+		return nil, 0, err
 	}
-	return nil, errors.New("ObjectId Not Exist")
+	return objects, count, nil
 }
 
-func GetAll() map[string]*Object {
-	return Objects
+func (r *PostgresObjectRepository) Create(ctx context.Context, o *Object) error {
+	o.ObjectId = NewObjectID()
+	o.Version = 1
+	return r.db.write(ctx, func(tx *gorm.DB) error {
+		return tx.Create(o).Error
+	})
 }
 
-func Update(ObjectId string, Score int64) (err error) {
-	if v, ok := Objects[ObjectId]; ok {
-		v.Score = Score
-		return nil
+func (r *PostgresObjectRepository) Update(ctx context.Context, o *Object) error {
+	var affected int64
+	err := r.db.write(ctx, func(tx *gorm.DB) error {
+		db := tx.Model(&Object{}).Where("object_id = ? AND version = ?", o.ObjectId, o.Version).
+			UpdateColumns(map[string]interface{}{"score": o.Score, "player_name": o.PlayerName, "version": o.Version + 1})
+		affected = db.RowsAffected
+		return db.Error
+	})
+	if err != nil {
+		return err
 	}
-	return errors.New("ObjectId Not Exist")
+	if affected == 0 {
+		return r.missed(ctx, o.ObjectId)
+	}
+	o.Version++
+	return nil
 }
 
-func Delete(ObjectId string) {
-	delete(Objects, ObjectId)
+func (r *PostgresObjectRepository) Delete(ctx context.Context, id string, version int64) error {
+	var affected int64
+	err := r.db.write(ctx, func(tx *gorm.DB) error {
+		db := tx.Where("object_id = ?", id)
+		if version != AnyVersion {
+			db = db.Where("version = ?", version)
+		}
+		db = db.Delete(&Object{})
+		affected = db.RowsAffected
+		return db.Error
+	})
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.missed(ctx, id)
+	}
+	return nil
 }
 
+// missed tell why no object is changed, it's either deleted or changed by others
+func (r *PostgresObjectRepository) missed(ctx context.Context, id string) error {
+	if _, err := r.get(ctx, id, r.db.write); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestMemoryObjectRepository(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemoryObjectRepository(Object{ObjectId: "hjkhsbnmn123", Score: 100, PlayerName: "astaxie"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(score int64) {
+			defer wg.Done()
+			if err := r.Create(ctx, &Object{Score: score, PlayerName: "someone"}); err != nil {
+				t.Errorf("create object failed:%v", err)
+			}
+			r.Update(ctx, &Object{ObjectId: "hjkhsbnmn123", Score: score, PlayerName: "astaxie"})
+		}(int64(i))
+	}
+	wg.Wait()
+
+	objects, total, err := r.List(ctx, NewQuery(ObjectQuerySchema, 0, 10))
+	if err != nil || total != 51 || len(objects) != 10 {
+		t.Fatalf("unexpected list result, total:%d len:%d err:%v", total, len(objects), err)
+	}
+
+	if err := r.Delete(ctx, "hjkhsbnmn123", AnyVersion); err != nil {
+		t.Fatalf("delete object failed:%v", err)
+	}
+	if _, err := r.Get(ctx, "hjkhsbnmn123"); err != ErrObjectNotFound {
+		t.Errorf("deleted object should not be found, got %v", err)
+	}
+	if err := r.Update(ctx, &Object{ObjectId: "hjkhsbnmn123"}); err != ErrObjectNotFound {
+		t.Errorf("update deleted object should fail, got %v", err)
+	}
+}
+
+func TestMemoryObjectVersion(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemoryObjectRepository()
+	o := &Object{Score: 1, PlayerName: "astaxie"}
+	if err := r.Create(ctx, o); err != nil || o.Version != 1 {
+		t.Fatalf("object should be created at version 1, version:%d err:%v", o.Version, err)
+	}
+
+	stale := *o
+	o.Score = 2
+	if err := r.Update(ctx, o); err != nil || o.Version != 2 {
+		t.Fatalf("update should increase version, version:%d err:%v", o.Version, err)
+	}
+	stale.Score = 3
+	if err := r.Update(ctx, &stale); err != ErrVersionConflict {
+		t.Errorf("update of stale version should conflict, got %v", err)
+	}
+	if err := r.Delete(ctx, o.ObjectId, 1); err != ErrVersionConflict {
+		t.Errorf("delete of stale version should conflict, got %v", err)
+	}
+	if err := r.Delete(ctx, o.ObjectId, o.Version); err != nil {
+		t.Errorf("delete of current version failed:%v", err)
+	}
+}
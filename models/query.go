@@ -0,0 +1,516 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// kinds of query field, filter values are converted to the kind
+const (
+	QueryString = iota
+	QueryInt
+	QueryTime
+)
+
+var (
+	// ErrInvalidCursor is returned when cursor token can't be decoded or it's of another query
+	ErrInvalidCursor = errors.New("cursor is invalid")
+)
+
+// filterOperators are the comparisons of filter, two-char operators come first
+var filterOperators = []string{">=", "<=", "!=", ">", "<", "="}
+
+// QueryField describes a field which can be filtered, sorted and projected
+type QueryField struct {
+	Column string // column of postgres
+	BSON   string // field of mongo
+	JSON   string // key of json response
+	Kind   int
+}
+
+// QuerySchema is the fields of a collection which are allowed in query
+type QuerySchema struct {
+	Fields map[string]QueryField
+	// Key is the unique field appended to sorts, so rows of equal sort values are paged in a stable order
+	Key          string
+	DefaultSort  []SortField
+	DefaultLimit int
+	MaxLimit     int
+	// KeywordColumns are the columns of postgres matched by keyword, keyword is rejected without them
+	KeywordColumns []string
+	// KeywordFields are the fields of mongo matched by keyword
+	KeywordFields []string
+}
+
+// Filter is a comparison like score>100
+type Filter struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// SortField is a sort key, Desc is true for "-score"
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Query is the parsed query parameters of a collection, e.g.
+// "?filter=score>100,player_name=astaxie&sort=-score&fields=id,score&limit=20&cursor=xxx"
+type Query struct {
+	Schema  *QuerySchema
+	Filters []Filter
+	Sorts   []SortField
+	Fields  []string
+	Limit   int
+	Offset  int
+	// Keyword is contained case insensitively by any keyword column of matched rows
+	Keyword string
+}
+
+// ObjectQuerySchema is the query schema of /v1/object
+var ObjectQuerySchema = &QuerySchema{
+	Fields: map[string]QueryField{
+		"id":          {Column: "object_id", BSON: "_id", JSON: "ObjectId", Kind: QueryString},
+		"score":       {Column: "score", BSON: "score", JSON: "Score", Kind: QueryInt},
+		"player_name": {Column: "player_name", BSON: "player_name", JSON: "PlayerName", Kind: QueryString},
+	},
+	Key:          "id",
+	DefaultSort:  []SortField{{Field: "id"}},
+	DefaultLimit: 20,
+	MaxLimit:     100,
+}
+
+// UserQuerySchema is the query schema of /v1/user
+var UserQuerySchema = &QuerySchema{
+	Fields: map[string]QueryField{
+		"id":          {Column: "id", BSON: "_id", JSON: "Id", Kind: QueryInt},
+		"name":        {Column: "name", BSON: "name", JSON: "Name", Kind: QueryString},
+		"create_time": {Column: "create_time", BSON: "createtime", JSON: "CreateTime", Kind: QueryTime},
+		"update_time": {Column: "update_time", BSON: "updatetime", JSON: "UpdateTime", Kind: QueryTime},
+		"profile":     {JSON: "Profile"},
+		"source":      {Column: "source", BSON: "source", JSON: "Source", Kind: QueryString},
+	},
+	Key:          "id",
+	DefaultSort:  []SortField{{Field: "id"}},
+	DefaultLimit: 20,
+	MaxLimit:     100,
+}
+
+// AdminUserQuerySchema is the query schema of users in admin console, keyword matches name and email
+var AdminUserQuerySchema = &QuerySchema{
+	Fields: map[string]QueryField{
+		"id":          {Column: "id", BSON: "_id", JSON: "id", Kind: QueryInt},
+		"name":        {Column: "name", BSON: "name", JSON: "name", Kind: QueryString},
+		"gender":      {Column: "profile::jsonb->>'gender'", BSON: "profile.gender", JSON: "profile.gender", Kind: QueryString},
+		"email":       {Column: "profile::jsonb->>'email'", BSON: "profile.email", JSON: "profile.email", Kind: QueryString},
+		"create_time": {Column: "create_time", BSON: "createtime", JSON: "create_time", Kind: QueryTime},
+		"update_time": {Column: "update_time", BSON: "updatetime", JSON: "update_time", Kind: QueryTime},
+	},
+	Key:            "id",
+	DefaultSort:    []SortField{{Field: "id"}},
+	DefaultLimit:   10,
+	MaxLimit:       100,
+	KeywordColumns: []string{"name", "profile::jsonb->>'email'"},
+	KeywordFields:  []string{"name", "profile.email"},
+}
+
+// RoleQuerySchema is the query schema of roles in admin console, keyword matches name
+var RoleQuerySchema = &QuerySchema{
+	Fields: map[string]QueryField{
+		"ID":        {Column: "id", JSON: "ID", Kind: QueryInt},
+		"name":      {Column: "name", JSON: "name", Kind: QueryString},
+		"create_at": {Column: "created_at", JSON: "create_at", Kind: QueryTime},
+		"update_at": {Column: "updated_at", JSON: "update_at", Kind: QueryTime},
+	},
+	Key:            "ID",
+	DefaultSort:    []SortField{{Field: "ID"}},
+	DefaultLimit:   10,
+	MaxLimit:       100,
+	KeywordColumns: []string{"name"},
+}
+
+// PermissionQuerySchema is the query schema of permissions in admin console, keyword matches name and resource
+var PermissionQuerySchema = &QuerySchema{
+	Fields: map[string]QueryField{
+		"ID":       {Column: "id", JSON: "ID", Kind: QueryInt},
+		"name":     {Column: "name", JSON: "name", Kind: QueryString},
+		"parent":   {Column: "parent", JSON: "parent", Kind: QueryInt},
+		"resource": {Column: "resource", JSON: "resource", Kind: QueryString},
+		"action":   {Column: "action", JSON: "action", Kind: QueryString},
+	},
+	Key:            "ID",
+	DefaultSort:    []SortField{{Field: "ID"}},
+	DefaultLimit:   10,
+	MaxLimit:       100,
+	KeywordColumns: []string{"name", "resource"},
+}
+
+// NewQuery create a query of schema without conditions
+func NewQuery(schema *QuerySchema, offset, limit int) *Query {
+	return &Query{Schema: schema, Sorts: schema.withKey(schema.DefaultSort), Offset: offset, Limit: limit}
+}
+
+// withKey append the key of schema to sorts unless it's sorted already
+func (s *QuerySchema) withKey(sorts []SortField) []SortField {
+	for _, sort := range sorts {
+		if sort.Field == s.Key {
+			return sorts
+		}
+	}
+	return append(append(make([]SortField, 0, len(sorts)+1), sorts...), SortField{Field: s.Key})
+}
+
+// ParseQuery parse filter, sort, fields, limit and cursor parameters, only fields of schema are accepted
+func ParseQuery(values url.Values, schema *QuerySchema) (*Query, error) {
+	q := NewQuery(schema, 0, schema.DefaultLimit)
+
+	for _, param := range values["filter"] {
+		for _, expr := range splitList(param) {
+			f, err := schema.parseFilter(expr)
+			if err != nil {
+				return nil, err
+			}
+			q.Filters = append(q.Filters, f)
+		}
+	}
+
+	if param := values.Get("sort"); param != "" {
+		q.Sorts = nil
+		for _, name := range splitList(param) {
+			s := SortField{Field: name}
+			if strings.HasPrefix(name, "-") {
+				s = SortField{Field: name[1:], Desc: true}
+			}
+			if field, ok := schema.Fields[s.Field]; !ok || field.Column == "" {
+				return nil, fmt.Errorf("field '%s' can't be sorted", s.Field)
+			}
+			q.Sorts = append(q.Sorts, s)
+		}
+		q.Sorts = schema.withKey(q.Sorts)
+	}
+
+	for _, name := range splitList(values.Get("fields")) {
+		if _, ok := schema.Fields[name]; !ok {
+			return nil, fmt.Errorf("unknown field '%s'", name)
+		}
+		q.Fields = append(q.Fields, name)
+	}
+
+	if param := values.Get("limit"); param != "" {
+		limit, err := strconv.Atoi(param)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("limit must be a positive integer")
+		}
+		q.Limit = limit
+	}
+	if schema.MaxLimit > 0 && q.Limit > schema.MaxLimit {
+		q.Limit = schema.MaxLimit
+	}
+
+	if keyword := strings.TrimSpace(values.Get("keyword")); keyword != "" {
+		if len(schema.KeywordColumns) == 0 {
+			return nil, fmt.Errorf("keyword isn't supported")
+		}
+		q.Keyword = keyword
+	}
+
+	// cursor is checked against the conditions and sorts parsed above
+	if param := values.Get("cursor"); param != "" {
+		offset, err := q.decodeCursor(param)
+		if err != nil {
+			return nil, err
+		}
+		q.Offset = offset
+	}
+	return q, nil
+}
+
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (s *QuerySchema) parseFilter(expr string) (Filter, error) {
+	// the leftmost operator splits field and value, two-char operators win at the same position
+	pos, op := -1, ""
+	for _, o := range filterOperators {
+		if i := strings.Index(expr, o); i > 0 && (pos < 0 || i < pos) {
+			pos, op = i, o
+		}
+	}
+	if pos < 0 {
+		return Filter{}, fmt.Errorf("invalid filter '%s'", expr)
+	}
+
+	name := strings.TrimSpace(expr[:pos])
+	field, ok := s.Fields[name]
+	if !ok || field.Column == "" {
+		return Filter{}, fmt.Errorf("field '%s' can't be filtered", name)
+	}
+	value, err := field.convert(strings.TrimSpace(expr[pos+len(op):]))
+	if err != nil {
+		return Filter{}, fmt.Errorf("invalid value of field '%s':%v", name, err)
+	}
+	return Filter{Field: name, Op: op, Value: value}, nil
+}
+
+func (f QueryField) convert(value string) (interface{}, error) {
+	switch f.Kind {
+	case QueryInt:
+		return strconv.ParseInt(value, 10, 64)
+	case QueryTime:
+		return time.Parse(time.RFC3339, value)
+	default:
+		return value, nil
+	}
+}
+
+// Cursor create the opaque cursor token of offset, it's bound to the filters, keyword and sorts of query
+func (q *Query) Cursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(offset) + ":" + q.fingerprint()))
+}
+
+// decodeCursor get offset from cursor token, ErrInvalidCursor is returned when it's created by another query
+func (q *Query) decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	parts := strings.SplitN(string(data), ":", 3)
+	if err != nil || len(parts) != 3 || parts[0] != "o" || parts[2] != q.fingerprint() {
+		return 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(parts[1])
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
+
+// fingerprint hash the conditions and sorts of query, offsets are meaningless for others
+func (q *Query) fingerprint() string {
+	h := sha256.New()
+	for _, f := range q.Filters {
+		fmt.Fprintf(h, "f:%s%s%T%v\n", f.Field, f.Op, f.Value, f.Value)
+	}
+	for _, s := range q.Sorts {
+		fmt.Fprintf(h, "s:%s%t\n", s.Field, s.Desc)
+	}
+	fmt.Fprintf(h, "k:%s", q.Keyword)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:9])
+}
+
+// NextCursor return cursor of next page, it's empty when current page is the last
+func (q *Query) NextCursor(total int) string {
+	if q.Offset+q.Limit >= total {
+		return ""
+	}
+	return q.Cursor(q.Offset + q.Limit)
+}
+
+// PrevCursor return cursor of previous page, it's empty when current page is the first
+func (q *Query) PrevCursor() string {
+	if q.Offset <= 0 {
+		return ""
+	}
+	offset := q.Offset - q.Limit
+	if offset < 0 {
+		offset = 0
+	}
+	return q.Cursor(offset)
+}
+
+// Where apply filters and keyword of query to gorm
+func (q *Query) Where(db *gorm.DB) *gorm.DB {
+	for _, f := range q.Filters {
+		column := q.Schema.Fields[f.Field].Column
+		db = db.Where(fmt.Sprintf("%s %s ?", column, sqlOperator(f.Op)), f.Value)
+	}
+	if q.Keyword != "" && len(q.Schema.KeywordColumns) > 0 {
+		conditions := make([]string, len(q.Schema.KeywordColumns))
+		args := make([]interface{}, len(q.Schema.KeywordColumns))
+		pattern := "%" + likeEscaper.Replace(q.Keyword) + "%"
+		for i, column := range q.Schema.KeywordColumns {
+			conditions[i] = column + " ILIKE ?"
+			args[i] = pattern
+		}
+		db = db.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+	return db
+}
+
+// likeEscaper escape the wildcards of LIKE pattern, so keyword is matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Page apply sorts, offset and limit of query to gorm
+func (q *Query) Page(db *gorm.DB) *gorm.DB {
+	for _, s := range q.Sorts {
+		column := q.Schema.Fields[s.Field].Column
+		if s.Desc {
+			column += " desc"
+		} else {
+			column += " asc"
+		}
+		db = db.Order(column)
+	}
+	return db.Offset(q.Offset).Limit(q.Limit)
+}
+
+func sqlOperator(op string) string {
+	if op == "!=" {
+		return "<>"
+	}
+	return op
+}
+
+// Project keep only the requested fields of items in response, items are returned as they are without fields
+func (q *Query) Project(items interface{}) (interface{}, error) {
+	if len(q.Fields) == 0 {
+		return items, nil
+	}
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		projected := make(map[string]interface{}, len(q.Fields))
+		for _, name := range q.Fields {
+			key := q.Schema.Fields[name].JSON
+			if v, ok := row[key]; ok {
+				projected[key] = v
+			}
+		}
+		rows[i] = projected
+	}
+	return rows, nil
+}
+
+// Match check whether the json representation of item satisfies filters, it's used by in-memory stores
+func (q *Query) Match(row map[string]interface{}) bool {
+	for _, f := range q.Filters {
+		c, ok := compareValue(row[q.Schema.Fields[f.Field].JSON], f.Value)
+		if !ok {
+			return false
+		}
+		switch f.Op {
+		case "=":
+			ok = c == 0
+		case "!=":
+			ok = c != 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply filter, sort and page json representations of items in memory,
+// the indexes of items in page and the total number of matched items are returned
+func (q *Query) Apply(rows []map[string]interface{}) ([]int, int) {
+	matched := make([]int, 0, len(rows))
+	for i, row := range rows {
+		if q.Match(row) {
+			matched = append(matched, i)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		for _, s := range q.Sorts {
+			key := q.Schema.Fields[s.Field].JSON
+			c, _ := compareValue(rows[matched[i]][key], rows[matched[j]][key])
+			if c != 0 {
+				return (c < 0) != s.Desc
+			}
+		}
+		return false
+	})
+
+	total := len(matched)
+	if q.Offset >= total {
+		return []int{}, total
+	}
+	end := q.Offset + q.Limit
+	if q.Limit <= 0 || end > total {
+		end = total
+	}
+	return matched[q.Offset:end], total
+}
+
+// compareValue compare json value with filter value, false is returned when they can't be compared
+func compareValue(a, b interface{}) (int, bool) {
+	switch bv := b.(type) {
+	case int64:
+		av, ok := a.(float64)
+		if !ok {
+			return 0, false
+		}
+		return compareFloat(av, float64(bv)), true
+	case float64:
+		av, ok := a.(float64)
+		if !ok {
+			return 0, false
+		}
+		return compareFloat(av, bv), true
+	case time.Time:
+		as, ok := a.(string)
+		if !ok {
+			return 0, false
+		}
+		at, err := time.Parse(time.RFC3339Nano, as)
+		if err != nil {
+			return 0, false
+		}
+		if at.Before(bv) {
+			return -1, true
+		} else if at.After(bv) {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, bv); err == nil {
+			return compareValue(a, t)
+		}
+		as, ok := a.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(as, bv), true
+	}
+	return 0, false
+}
+
+func compareFloat(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
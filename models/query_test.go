@@ -0,0 +1,110 @@
+package models
+
+import (
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// parseWithCursor parse query with the cursor of offset created by the same query
+func parseWithCursor(t *testing.T, query string, schema *QuerySchema, offset int) (*Query, error) {
+	values, _ := url.ParseQuery(query)
+	q, err := ParseQuery(values, schema)
+	if err != nil {
+		t.Fatalf("parse query failed:%v", err)
+	}
+	values.Set("cursor", q.Cursor(offset))
+	return ParseQuery(values, schema)
+}
+
+func TestParseQuery(t *testing.T) {
+	q, err := parseWithCursor(t, "filter=score>=100,player_name!=astaxie&filter=id=abc&sort=-score,id&fields=id,score&limit=500", ObjectQuerySchema, 40)
+	if err != nil {
+		t.Fatalf("parse query failed:%v", err)
+	}
+	if len(q.Filters) != 3 || q.Filters[0] != (Filter{"score", ">=", int64(100)}) ||
+		q.Filters[1] != (Filter{"player_name", "!=", "astaxie"}) || q.Filters[2] != (Filter{"id", "=", "abc"}) {
+		t.Errorf("unexpected filters:%v", q.Filters)
+	}
+	if len(q.Sorts) != 2 || q.Sorts[0] != (SortField{"score", true}) || q.Sorts[1] != (SortField{"id", false}) {
+		t.Errorf("unexpected sorts:%v", q.Sorts)
+	}
+	if len(q.Fields) != 2 || q.Limit != 100 || q.Offset != 40 {
+		t.Errorf("unexpected fields:%v limit:%d offset:%d", q.Fields, q.Limit, q.Offset)
+	}
+
+	for _, query := range []string{"filter=password=1", "filter=score>abc", "filter=score", "sort=password", "fields=password", "limit=-1", "cursor=xyz"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseQuery(values, ObjectQuerySchema); err == nil {
+			t.Errorf("query '%s' should be rejected", query)
+		}
+	}
+}
+
+func TestParseQueryKeyword(t *testing.T) {
+	values, _ := url.ParseQuery("keyword=+50%25_off+&filter=gender=female&sort=-create_time")
+	q, err := ParseQuery(values, AdminUserQuerySchema)
+	if err != nil {
+		t.Fatalf("parse query failed:%v", err)
+	}
+	if q.Keyword != "50%_off" || len(q.Filters) != 1 || len(q.Sorts) != 2 || !q.Sorts[0].Desc || q.Sorts[1] != (SortField{"id", false}) {
+		t.Errorf("unexpected query:%+v", q)
+	}
+	if pattern := likeEscaper.Replace(q.Keyword); pattern != `50\%\_off` {
+		t.Errorf("wildcards of keyword should be escaped, got %s", pattern)
+	}
+	if _, err := ParseQuery(values, ObjectQuerySchema); err == nil {
+		t.Error("keyword should be rejected without keyword columns")
+	}
+}
+
+func TestQueryCursor(t *testing.T) {
+	q := NewQuery(ObjectQuerySchema, 20, 10)
+	if offset, _ := q.decodeCursor(q.NextCursor(35)); offset != 30 {
+		t.Errorf("expect next offset 30 but got %d", offset)
+	}
+	if offset, _ := q.decodeCursor(q.PrevCursor()); offset != 10 {
+		t.Errorf("expect prev offset 10 but got %d", offset)
+	}
+	if q.NextCursor(30) != "" || NewQuery(ObjectQuerySchema, 0, 10).PrevCursor() != "" {
+		t.Error("first and last page should have no adjacent cursor")
+	}
+
+	values, _ := url.ParseQuery("filter=score>100&sort=-score")
+	q, _ = ParseQuery(values, ObjectQuerySchema)
+	cursor := q.Cursor(20)
+	for _, query := range []string{"filter=score>101&sort=-score", "filter=score>100&sort=score", "sort=-score"} {
+		values, _ := url.ParseQuery(query + "&cursor=" + cursor)
+		if _, err := ParseQuery(values, ObjectQuerySchema); err != ErrInvalidCursor {
+			t.Errorf("cursor of another query should be rejected by '%s', got %v", query, err)
+		}
+	}
+	values.Set("cursor", cursor)
+	if q, err := ParseQuery(values, ObjectQuerySchema); err != nil || q.Offset != 20 {
+		t.Errorf("cursor of the same query should be accepted, got %v", err)
+	}
+}
+
+func TestQueryMemoryObjects(t *testing.T) {
+	r := NewMemoryObjectRepository(
+		Object{ObjectId: "a", Score: 90, PlayerName: "astaxie"},
+		Object{ObjectId: "b", Score: 150, PlayerName: "someone"},
+		Object{ObjectId: "c", Score: 120, PlayerName: "astaxie"},
+		Object{ObjectId: "d", Score: 300, PlayerName: "astaxie"},
+	)
+	q, err := parseWithCursor(t, "filter=score>100,player_name=astaxie&sort=-score&fields=id&limit=1", ObjectQuerySchema, 1)
+	if err != nil {
+		t.Fatalf("parse query failed:%v", err)
+	}
+
+	objects, total, err := r.List(context.Background(), q)
+	if err != nil || total != 2 || len(objects) != 1 || objects[0].ObjectId != "c" {
+		t.Fatalf("unexpected page:%v total:%d err:%v", objects, total, err)
+	}
+	projected, err := q.Project(objects)
+	rows, _ := projected.([]map[string]interface{})
+	if err != nil || len(rows) != 1 || len(rows[0]) != 1 || rows[0]["ObjectId"] != "c" {
+		t.Errorf("unexpected projection:%v err:%v", projected, err)
+	}
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// RegistrationPendingVerify waits for user to verify email
+	RegistrationPendingVerify = iota
+	// RegistrationPendingApproval waits for admin to approve
+	RegistrationPendingApproval
+	// RegistrationActivated has been converted to user
+	RegistrationActivated
+	// RegistrationRejected has been rejected by admin
+	RegistrationRejected
+)
+
+var (
+	// ErrUserNameExists is returned when user name has been taken
+	ErrUserNameExists = errors.New("user name already exists")
+	// ErrInvalidRegistration is returned when verification token or registration state is invalid
+	ErrInvalidRegistration = errors.New("registration is invalid or expired")
+)
+
+// Registration represents a self registered user which hasn't been activated
+type Registration struct {
+	Model
+	Name      string    `json:"name" gorm:"not null;index"`
+	Password  string    `json:"-" gorm:"not null"`
+	Email     string    `json:"email" gorm:"not null"`
+	TokenHash string    `json:"-" gorm:"not null;unique_index"`
+	ExpiresAt time.Time `json:"expires_at"`
+	State     int       `json:"state" gorm:"index"`
+}
+
+// userNameTaken check the users and the registrations waiting for activation, the names of users in trash
+// are rejected when the user is created
+func userNameTaken(ctx context.Context, name string) bool {
+	if _, err := Users().GetByName(OnPrimary(ctx), name); err != ErrUserNotFound {
+		return true
+	}
+	var count int
+	gormDB.Model(&Registration{}).Where("name = ? AND state IN (?) AND expires_at > ?",
+		name, []int{RegistrationPendingVerify, RegistrationPendingApproval}, time.Now()).Count(&count)
+	return count > 0
+}
+
+// CreateRegistration save registration and return the token which verifies its email
+func CreateRegistration(name, password, email string, lifetime time.Duration) (*Registration, string, error) {
+	if userNameTaken(context.Background(), name) {
+		return nil, "", ErrUserNameExists
+	}
+
+	passwordhash, err := encryptPassword(password)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r := &Registration{
+		Name:      name,
+		Password:  passwordhash,
+		Email:     email,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(lifetime),
+		State:     RegistrationPendingVerify,
+	}
+	if err := gormDB.Create(r).Error; err != nil {
+		return nil, "", err
+	}
+	return r, token, nil
+}
+
+// VerifyRegistration mark email of registration verified, it waits for approval when approval is required
+func VerifyRegistration(token string, approval bool) (*Registration, error) {
+	r := &Registration{}
+	err := gormDB.Where("token_hash = ? AND state = ?", hashToken(token), RegistrationPendingVerify).First(r).Error
+	if err != nil || time.Now().After(r.ExpiresAt) {
+		return nil, ErrInvalidRegistration
+	}
+
+	if approval {
+		r.State = RegistrationPendingApproval
+		err = gormDB.Model(r).UpdateColumn("state", r.State).Error
+		return r, err
+	}
+	return r, nil
+}
+
+// GetRegistration get registration by id
+func GetRegistration(id uint) (*Registration, error) {
+	r := &Registration{}
+	err := gormDB.First(r, id).Error
+	return r, err
+}
+
+// GetRegistrations list registrations in state
+func GetRegistrations(state, offset, limit int) ([]Registration, int) {
+	var count int
+	var registrations []Registration
+	gormDB.Model(&Registration{}).Where("state = ?", state).Count(&count)
+	gormDB.Where("state = ?", state).Offset(offset).Limit(limit).Order("id asc").Find(&registrations)
+	return registrations, count
+}
+
+// ActivateRegistration create user of registration, the password hash is kept. The registration is claimed
+// by its state first, so it's activated once even if verified and approved at the same time
+func ActivateRegistration(r *Registration) (*User, error) {
+	pending := []int{RegistrationPendingVerify, RegistrationPendingApproval}
+	db := gormDB.Model(&Registration{}).Where("id = ? AND state IN (?)", r.ID, pending).
+		UpdateColumn("state", RegistrationActivated)
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return nil, ErrInvalidRegistration
+	}
+
+	user := &User{
+		Name:     r.Name,
+		Password: r.Password,
+		Profile: Profile{
+			Email: r.Email,
+		},
+	}
+	if err := Users().CreateHashed(context.Background(), user); err != nil {
+		// give the registration back, e.g. to be rejected when its name has been taken
+		gormDB.Model(&Registration{}).Where("id = ? AND state = ?", r.ID, RegistrationActivated).
+			UpdateColumn("state", r.State)
+		return nil, err
+	}
+	r.State = RegistrationActivated
+	return user, nil
+}
+
+// RejectRegistration reject a registration which waits for approval
+func RejectRegistration(id uint) error {
+	return gormDB.Model(&Registration{}).Where("id = ? AND state = ?", id, RegistrationPendingApproval).
+		UpdateColumn("state", RegistrationRejected).Error
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRememberTokenTheft is returned when an old token of a series is presented again,
+	// all persistent logins of the user are removed in that case
+	ErrRememberTokenTheft = errors.New("remember-me token has been used")
+)
+
+// RememberToken is a persistent login, the series is fixed while the token is rotated at every use
+type RememberToken struct {
+	Model
+	Series    string    `gorm:"not null;unique_index"`
+	TokenHash string    `gorm:"not null"`
+	UserID    int64     `gorm:"index"`
+	Name      string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// SessionRevocation records the time when user signed out everywhere,
+// sessions logged in before it are invalid
+type SessionRevocation struct {
+	UserID    int64 `gorm:"primary_key"`
+	RevokedAt time.Time
+}
+
+// IssueRememberToken create a persistent login for user and return the cookie value of it
+func IssueRememberToken(uid int64, name string, lifetime time.Duration) (string, error) {
+	series, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	record := &RememberToken{
+		Series:    series,
+		TokenHash: hashToken(token),
+		UserID:    uid,
+		Name:      name,
+		ExpiresAt: time.Now().Add(lifetime),
+	}
+	if err := gormDB.Create(record).Error; err != nil {
+		return "", err
+	}
+	return series + ":" + token, nil
+}
+
+// UseRememberToken verify the cookie value of persistent login and rotate its token,
+// the new cookie value is returned with the login
+func UseRememberToken(value string, lifetime time.Duration) (*RememberToken, string, error) {
+	pair := strings.SplitN(value, ":", 2)
+	if len(pair) != 2 {
+		return nil, "", ErrInvalidToken
+	}
+
+	record := &RememberToken{}
+	if err := gormDB.Where("series = ?", pair[0]).First(record).Error; err != nil {
+		return nil, "", ErrInvalidToken
+	}
+	if time.Now().After(record.ExpiresAt) {
+		gormDB.Delete(record)
+		return nil, "", ErrInvalidToken
+	}
+	if record.TokenHash != hashToken(pair[1]) {
+		// the series is known but token is stale, the cookie has been stolen and used
+		gormDB.Where("user_id = ?", record.UserID).Delete(&RememberToken{})
+		return nil, "", ErrRememberTokenTheft
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	// only one of concurrent requests can win the rotation
+	result := gormDB.Model(&RememberToken{}).Where("id = ? AND token_hash = ?", record.ID, record.TokenHash).
+		Updates(map[string]interface{}{"token_hash": hashToken(token), "expires_at": time.Now().Add(lifetime)})
+	if result.Error != nil {
+		return nil, "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, "", ErrInvalidToken
+	}
+	return record, record.Series + ":" + token, nil
+}
+
+// DeleteRememberToken remove the persistent login of cookie value
+func DeleteRememberToken(value string) error {
+	series := strings.SplitN(value, ":", 2)[0]
+	return gormDB.Where("series = ?", series).Delete(&RememberToken{}).Error
+}
+
+// SignOutEverywhere invalidate all sessions, persistent logins and refresh tokens of user
+func SignOutEverywhere(uid int64) error {
+	tx := gormDB.Begin()
+	err := tx.Where("user_id = ?", uid).Delete(&RememberToken{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Model(&RefreshToken{}).Where("user_id = ?", uid).UpdateColumn("revoked", true).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// issue times of access tokens are in seconds, so is the revocation
+	err = tx.Save(&SessionRevocation{UserID: uid, RevokedAt: time.Now().Truncate(time.Second)}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// SessionRevoked check whether session of user logged in at loginAt has been signed out everywhere
+func SessionRevoked(uid int64, loginAt time.Time) bool {
+	r := &SessionRevocation{}
+	db := gormDB.Where("user_id = ?", uid).First(r)
+	if db.RecordNotFound() {
+		return false
+	}
+	if db.Error != nil {
+		return true
+	}
+	return loggedInBefore(loginAt, r.RevokedAt)
+}
+
+// loggedInBefore compare by second, a login in the second of revocation is kept since the access tokens
+// issued in it can't be told from the ones issued before
+func loggedInBefore(loginAt, revokedAt time.Time) bool {
+	return loginAt.Unix() < revokedAt.Unix()
+}
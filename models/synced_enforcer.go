@@ -4,29 +4,41 @@ import (
 	"sync"
 	"github.com/lib/pq"
 	"github.com/jinzhu/gorm"
+	"golang.org/x/net/context"
 )
 
 // SyncedEnforcer goroutine safed enforcer
 type SyncedEnforcer struct {
 	db 				*gorm.DB
+	cluster   *Cluster
 	model     *EnforcerModel
 	lock 			sync.RWMutex	
 }
 
-// NewSyncedEnforcer create a SyncedEnforcer object 
-func NewSyncedEnforcer(db *gorm.DB, autoLoad bool) Enforcer {
-	db.SingularTable(true)
-	return &SyncedEnforcer{db: db, model:NewModel(autoLoad)}
+// NewSyncedEnforcer create a SyncedEnforcer object, policy is loaded from primary
+// and the lists of admin pages are read from replicas
+func NewSyncedEnforcer(c *Cluster, autoLoad bool) Enforcer {
+	return &SyncedEnforcer{db: c.Primary(), cluster: c, model:NewModel(autoLoad)}
 }
 
 func (e *SyncedEnforcer) LoadPolicy() error {
 	e.lock.Lock()
 	defer e.lock.Unlock()
+	return e.loadPolicy()
+}
 
+// loadPolicy rebuild the model from database, soft deleted users, roles and permissions are left out
+func (e *SyncedEnforcer) loadPolicy() error {
 	users := e.GetAllUsers()
+	for _, k := range e.getAllAPIKeys() {
+		if !k.Expired() {
+			users = append(users, k.CasbinUser())
+		}
+	}
 	roles := e.GetAllRoles()
 	permissions := e.GetAllChildPermissions()
-	return e.model.Init(users, roles, permissions)	
+	e.model.Refresh(users, roles, permissions)
+	return nil
 }
 
 func (e *SyncedEnforcer) RefreshPolicy() {
@@ -45,11 +57,17 @@ func (e *SyncedEnforcer) GetAllRoles() []CasbinRole {
 	return []CasbinRole{}
 }
 
-func (e *SyncedEnforcer) GetRoles(offset, limit int) ([]CasbinRole, int) {
-	var count int	
+// GetRoles return roles in page of query and the total number of roles matched by query
+func (e *SyncedEnforcer) GetRoles(ctx context.Context, q *Query) ([]CasbinRole, int, error) {
+	var count int
 	var roles []CasbinRole
-	e.db.Offset(offset).Limit(limit).Order("id asc").Find(&roles).Count(&count)
-	return roles, count
+	err := e.cluster.read(ctx, func(tx *gorm.DB) error {
+		if err := q.Where(tx.Model(&CasbinRole{})).Count(&count).Error; err != nil {
+			return err
+		}
+		return q.Page(q.Where(tx)).Find(&roles).Error
+	})
+	return roles, count, err
 }
 
 func (e *SyncedEnforcer) GetRole(id uint) (*CasbinRole, error) {
@@ -101,9 +119,48 @@ func (e *SyncedEnforcer) DeleteRole(id uint) error {
 	return err
 }
 
-func (e *SyncedEnforcer) GetPermissions() []CasbinPermission {
+// RestoreRole take role out of trash, users having it get its permissions again
+func (e *SyncedEnforcer) RestoreRole(id uint) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if err := restore(e.db, &CasbinRole{}, id); err != nil {
+		return err
+	}
+	return e.loadPolicy()
+}
+
+// PurgeRole remove role in trash permanently, it's removed from users and api keys too
+func (e *SyncedEnforcer) PurgeRole(id uint) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	tx := e.db.Begin()
+	if err := purge(tx, &CasbinRole{}, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Exec("DELETE FROM casbin_role_permission WHERE casbin_role_id = ?", id).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, table := range []string{"casbin_user", "api_key"} {
+		if err := tx.Exec("UPDATE "+table+" SET roles = array_remove(roles, ?) WHERE ? = ANY(roles)", id, id).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	return e.loadPolicy()
+}
+
+func (e *SyncedEnforcer) GetPermissions(ctx context.Context) []CasbinPermission {
 	var permissions []CasbinPermission
-	err := e.db.Find(&permissions).Error
+	err := e.cluster.read(ctx, func(tx *gorm.DB) error {
+		return tx.Find(&permissions).Error
+	})
 	if err != nil {
 		return permissions
 	}
@@ -126,8 +183,22 @@ func (e *SyncedEnforcer) GetPermissions() []CasbinPermission {
 	return roots
 }
 
-func (e *SyncedEnforcer) GetPermissionsWithoutEmpty() []CasbinPermission {
-	roots := e.GetPermissions()
+// SearchPermissions return permissions in page of query and the total number of permissions matched by query,
+// groups are permissions without parent
+func (e *SyncedEnforcer) SearchPermissions(ctx context.Context, q *Query) ([]CasbinPermission, int, error) {
+	var count int
+	var permissions []CasbinPermission
+	err := e.cluster.read(ctx, func(tx *gorm.DB) error {
+		if err := q.Where(tx.Model(&CasbinPermission{})).Count(&count).Error; err != nil {
+			return err
+		}
+		return q.Page(q.Where(tx)).Find(&permissions).Error
+	})
+	return permissions, count, err
+}
+
+func (e *SyncedEnforcer) GetPermissionsWithoutEmpty(ctx context.Context) []CasbinPermission {
+	roots := e.GetPermissions(ctx)
 	nonEmptyRoots := make([]CasbinPermission, 0)
 	for i := range roots {
 		if len(roots[i].Children) > 0 {
@@ -178,11 +249,66 @@ func (e *SyncedEnforcer) DeletePermission(pid uint) error	{
 	}
 	tx.Commit()
 	if tx.Error == nil {
-		e.model.RemovePermission(pid)
+		// permissions of group are deleted with it
+		e.loadPolicy()
 	}
 	return tx.Error
 }
 
+// RestorePermission take permission out of trash, the children of group are restored with it
+// and the group of permission is restored if it's in trash too
+func (e *SyncedEnforcer) RestorePermission(pid uint) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	p := &CasbinPermission{}
+	if e.db.Unscoped().Where("deleted_at IS NOT NULL").First(p, pid).RecordNotFound() {
+		return ErrNotInTrash
+	}
+
+	tx := e.db.Begin()
+	err := tx.Unscoped().Model(&CasbinPermission{}).Where("(id = ? OR parent = ?) AND deleted_at IS NOT NULL", pid, pid).UpdateColumn("deleted_at", nil).Error
+	if err == nil && p.Parent != 0 {
+		err = tx.Unscoped().Model(&CasbinPermission{}).Where("id = ? AND deleted_at IS NOT NULL", p.Parent).UpdateColumn("deleted_at", nil).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	return e.loadPolicy()
+}
+
+// PurgePermission remove permission in trash permanently, the children of group are removed with it
+func (e *SyncedEnforcer) PurgePermission(pid uint) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	tx := e.db.Begin()
+	if err := purge(tx, &CasbinPermission{}, pid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	var children []uint
+	err := tx.Unscoped().Model(&CasbinPermission{}).Where("parent = ?", pid).Pluck("id", &children).Error
+	if err == nil {
+		err = tx.Exec("DELETE FROM casbin_role_permission WHERE casbin_permission_id IN (?)", append(children, pid)).Error
+	}
+	if err == nil {
+		err = tx.Unscoped().Where("parent = ?", pid).Delete(&CasbinPermission{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	return e.loadPolicy()
+}
+
 func (e *SyncedEnforcer) GetAllUsers() []CasbinUser {
 	users := make([]CasbinUser, 0)
 	if err := e.db.Find(&users).Error; err == nil {
@@ -229,6 +355,73 @@ func (e *SyncedEnforcer) DeleteUser(id int64, name string) error {
 	return err
 }
 
+// RestoreUser take user out of trash with its roles
+func (e *SyncedEnforcer) RestoreUser(id int64) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if err := restore(e.db, &CasbinUser{}, id); err != nil {
+		return err
+	}
+	return e.loadPolicy()
+}
+
+// PurgeUser remove user in trash permanently
+func (e *SyncedEnforcer) PurgeUser(id int64) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return purge(e.db, &CasbinUser{}, id)
+}
+
+func (e *SyncedEnforcer) getAllAPIKeys() []APIKey {
+	keys := make([]APIKey, 0)
+	if err := e.db.Find(&keys).Error; err == nil {
+		return keys
+	}
+	return []APIKey{}
+}
+
+func (e *SyncedEnforcer) GetAPIKeys(offset, limit int) ([]APIKey, int) {
+	var count int
+	var keys []APIKey
+	e.db.Model(&APIKey{}).Count(&count)
+	e.db.Offset(offset).Limit(limit).Order("id asc").Find(&keys)
+	return keys, count
+}
+
+func (e *SyncedEnforcer) CreateAPIKey(k *APIKey, roles []uint) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	k.Roles = make(pq.Int64Array, len(roles))
+	for i := range roles {
+		k.Roles[i] = int64(roles[i])
+	}
+	err := e.db.Create(k).Error
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && pqErr.Constraint == "api_key_name_key" {
+		return ErrAPIKeyNameExists
+	}
+	if err == nil {
+		e.model.UpdateUser(k.Principal(), roles)
+	}
+	return err
+}
+
+func (e *SyncedEnforcer) DeleteAPIKey(id uint) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	k := &APIKey{}
+	if err := e.db.First(k, id).Error; err != nil {
+		return err
+	}
+	err := e.db.Delete(k).Error
+	if err == nil {
+		e.model.RemoveUser(k.Principal())
+	}
+	return err
+}
+
 func (e *SyncedEnforcer) Enforce(user, resource, action string) bool {
 	e.lock.RLock()
 	defer e.lock.RUnlock()
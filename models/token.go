@@ -0,0 +1,267 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/astaxie/beego"
+	jwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+)
+
+const (
+	// TokenTypeBearer is the token type returned to api clients
+	TokenTypeBearer = "Bearer"
+)
+
+var (
+	// ErrInvalidToken is returned when a token can't be verified
+	ErrInvalidToken = errors.New("token is invalid")
+	// ErrTokenRevoked is returned when a token has been revoked
+	ErrTokenRevoked = errors.New("token has been revoked")
+	// ErrTokenKeyNotSet is returned when tokens are signed before SetTokenKey
+	ErrTokenKeyNotSet = errors.New("token signing key isn't set")
+)
+
+// MinTokenKeySize is the least bytes of the key signing access tokens, it's the size of HS256 hash
+const MinTokenKeySize = 32
+
+// TokenPair represents an access token and the refresh token issued with it
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// TokenClaims is the payload of an access token
+type TokenClaims struct {
+	UserID int64  `json:"uid"`
+	Name   string `json:"name"`
+	jwt.StandardClaims
+}
+
+// RefreshToken represents an issued refresh token, only the hash of token is stored
+type RefreshToken struct {
+	Model
+	TokenHash string    `gorm:"not null;unique_index"`
+	AccessID  string    `gorm:"index"`
+	UserID    int64     `gorm:"index"`
+	Name      string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	Revoked   bool
+}
+
+// RevokedToken represents a revoked access token which is kept until it expires
+type RevokedToken struct {
+	ID        string `gorm:"primary_key"`
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+var tokenKey []byte
+
+// SetTokenKey set the key signing access tokens, it must be called at startup. The key is never shared
+// with other secrets like xsrfkey, keys shorter than MinTokenKeySize are rejected
+func SetTokenKey(key string) error {
+	if key == "" {
+		return errors.New("token signing key is empty")
+	}
+	if len(key) < MinTokenKeySize {
+		return fmt.Errorf("token signing key must be at least %d bytes", MinTokenKeySize)
+	}
+	tokenKey = []byte(key)
+	return nil
+}
+
+func accessTokenLifetime() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("jwt.accessexpire", 1800)) * time.Second
+}
+
+func refreshTokenLifetime() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("jwt.refreshexpire", 7*24*3600)) * time.Second
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SignAccessToken create a signed access token for user
+func SignAccessToken(uid int64, name string, lifetime time.Duration) (string, *TokenClaims, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &TokenClaims{
+		UserID: uid,
+		Name:   name,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   strconv.FormatInt(uid, 10),
+			Issuer:    beego.AppConfig.DefaultString("jwt.issuer", beego.BConfig.AppName),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(lifetime).Unix(),
+		},
+	}
+	if len(tokenKey) == 0 {
+		return "", nil, ErrTokenKeyNotSet
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tokenKey)
+	return token, claims, err
+}
+
+// ParseAccessToken verify signature and expiration of access token
+func ParseAccessToken(token string) (*TokenClaims, error) {
+	claims := &TokenClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok || len(tokenKey) == 0 {
+			return nil, ErrInvalidToken
+		}
+		return tokenKey, nil
+	})
+	if err != nil || !parsed.Valid {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// VerifyAccessToken parse access token and check whether it has been revoked,
+// the tokens issued before user signed out everywhere are revoked too
+func VerifyAccessToken(token string) (*TokenClaims, error) {
+	claims, err := ParseAccessToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if IsTokenRevoked(claims.Id) || SessionRevoked(claims.UserID, time.Unix(claims.IssuedAt, 0)) {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// userActive check whether user exists and isn't in trash
+func userActive(uid int64) (bool, error) {
+	_, err := Users().Get(OnPrimary(context.Background()), uid)
+	if err == ErrUserNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IssueTokenPair create an access token and a refresh token for user, ErrUserNotFound is returned
+// when user is deleted or in trash
+func IssueTokenPair(uid int64, name string) (*TokenPair, error) {
+	if active, err := userActive(uid); err != nil {
+		return nil, err
+	} else if !active {
+		return nil, ErrUserNotFound
+	}
+
+	lifetime := accessTokenLifetime()
+	accessToken, claims, err := SignAccessToken(uid, name, lifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	record := &RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		AccessID:  claims.Id,
+		UserID:    uid,
+		Name:      name,
+		ExpiresAt: time.Now().Add(refreshTokenLifetime()),
+	}
+	if err := gormDB.Create(record).Error; err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    TokenTypeBearer,
+		ExpiresIn:    int64(lifetime / time.Second),
+	}, nil
+}
+
+// RefreshTokenPair exchange a refresh token for a new token pair, the old refresh token is revoked.
+// The tokens of users which are deleted or in trash can't be refreshed
+func RefreshTokenPair(refreshToken string) (*TokenPair, error) {
+	record := &RefreshToken{}
+	err := gormDB.Where("token_hash = ?", hashToken(refreshToken)).First(record).Error
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if record.Revoked {
+		return nil, ErrTokenRevoked
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	// tokens of deleted users are useless even if they aren't revoked
+	if active, err := userActive(record.UserID); err != nil {
+		return nil, err
+	} else if !active {
+		return nil, ErrInvalidToken
+	}
+
+	// only one of concurrent refresh requests can win the rotation
+	result := gormDB.Model(&RefreshToken{}).Where("id = ? AND revoked = ?", record.ID, false).UpdateColumn("revoked", true)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrTokenRevoked
+	}
+	return IssueTokenPair(record.UserID, record.Name)
+}
+
+// RevokeAccessToken put access token into revocation list and revoke refresh tokens issued with it
+func RevokeAccessToken(claims *TokenClaims) error {
+	tx := gormDB.Begin()
+	err := tx.Create(&RevokedToken{ID: claims.Id, ExpiresAt: time.Unix(claims.ExpiresAt, 0)}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Model(&RefreshToken{}).Where("access_id = ?", claims.Id).UpdateColumn("revoked", true).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// revoked tokens are useless after they expire
+	err = tx.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// IsTokenRevoked check whether access token is in revocation list
+func IsTokenRevoked(jti string) bool {
+	var count int
+	if err := gormDB.Model(&RevokedToken{}).Where("id = ?", jti).Count(&count).Error; err != nil {
+		return true
+	}
+	return count > 0
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetTokenKey(t *testing.T) {
+	defer func(key []byte) { tokenKey = key }(tokenKey)
+	tokenKey = nil
+
+	if _, _, err := SignAccessToken(1, "admin", time.Minute); err != ErrTokenKeyNotSet {
+		t.Errorf("tokens shouldn't be signed without key, got %v", err)
+	}
+	if err := SetTokenKey(""); err == nil {
+		t.Error("empty key should be rejected")
+	}
+	if err := SetTokenKey("too-short-key"); err == nil {
+		t.Error("key shorter than 32 bytes should be rejected")
+	}
+	if err := SetTokenKey("0123456789abcdef0123456789abcdef"); err != nil {
+		t.Errorf("key of 32 bytes should be accepted, got %v", err)
+	}
+}
+
+func TestSignAndParseAccessToken(t *testing.T) {
+	defer func(key []byte) { tokenKey = key }(tokenKey)
+	tokenKey = []byte("0123456789abcdef0123456789abcdef")
+
+	token, claims, err := SignAccessToken(1, "admin", time.Minute)
+	if err != nil {
+		t.Fatalf("sign access token failed:%v", err)
+	}
+
+	parsed, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("parse access token failed:%v", err)
+	}
+	if parsed.Id != claims.Id || parsed.UserID != 1 || parsed.Name != "admin" {
+		t.Errorf("unexpected claims:%+v", parsed)
+	}
+
+	if _, err := ParseAccessToken(token + "x"); err != ErrInvalidToken {
+		t.Errorf("tampered token should be invalid, got %v", err)
+	}
+
+	expired, _, _ := SignAccessToken(1, "admin", -time.Minute)
+	if _, err := ParseAccessToken(expired); err != ErrInvalidToken {
+		t.Errorf("expired token should be invalid, got %v", err)
+	}
+}
+
+func TestLoggedInBefore(t *testing.T) {
+	revokedAt := time.Date(2026, 10, 19, 8, 0, 0, 600000000, time.UTC)
+	if !loggedInBefore(revokedAt.Add(-time.Second), revokedAt) {
+		t.Error("login of the second before revocation should be revoked")
+	}
+	// iat of token issued right after signing out is truncated to the second of revocation
+	if loggedInBefore(time.Unix(revokedAt.Unix(), 0), revokedAt) {
+		t.Error("login within the second of revocation should be kept")
+	}
+	if loggedInBefore(revokedAt.Add(time.Second), revokedAt) {
+		t.Error("login after revocation should be kept")
+	}
+}
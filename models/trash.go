@@ -0,0 +1,86 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/net/context"
+)
+
+// kinds of entities in trash
+const (
+	TrashUser       = "user"
+	TrashRole       = "role"
+	TrashPermission = "permission"
+)
+
+// ErrNotInTrash is returned when the entity to restore or purge isn't soft deleted
+var ErrNotInTrash = errors.New("entity isn't in trash")
+
+// TrashItem is a soft deleted user, role or permission
+type TrashItem struct {
+	Kind      string    `json:"kind"`
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+const trashQuery = `SELECT 'role' AS kind, id, name, deleted_at FROM casbin_role WHERE deleted_at IS NOT NULL
+UNION ALL SELECT 'permission' AS kind, id, name, deleted_at FROM casbin_permission WHERE deleted_at IS NOT NULL`
+
+// GetTrash get the soft deleted entities, the latest deleted one is the first. Users are in the user repository,
+// so the first offset+limit items of both are merged
+func GetTrash(ctx context.Context, offset, limit int) ([]TrashItem, int, error) {
+	users, userCount, err := Users().ListTrash(ctx, 0, offset+limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	var count int
+	var items []TrashItem
+	if err := gormDB.Raw("SELECT count(*) FROM (" + trashQuery + ") AS trash").Row().Scan(&count); err != nil {
+		return nil, 0, err
+	}
+	if err := gormDB.Raw(trashQuery+" ORDER BY deleted_at DESC LIMIT ?", offset+limit).Scan(&items).Error; err != nil {
+		return nil, 0, err
+	}
+
+	for _, u := range users {
+		items = append(items, TrashItem{Kind: TrashUser, ID: u.Id, Name: u.Name, DeletedAt: *u.DeletedAt})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	if offset >= len(items) {
+		return []TrashItem{}, count + userCount, nil
+	}
+	if end := offset + limit; end < len(items) {
+		items = items[:end]
+	}
+	return items[offset:], count + userCount, nil
+}
+
+// restore clear deleted_at of the soft deleted row
+func restore(db *gorm.DB, value interface{}, id interface{}) error {
+	result := db.Unscoped().Model(value).Where("id = ? AND deleted_at IS NOT NULL", id).UpdateColumn("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotInTrash
+	}
+	return nil
+}
+
+// purge delete the soft deleted row permanently
+func purge(db *gorm.DB, value interface{}, id interface{}) error {
+	result := db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Delete(value)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotInTrash
+	}
+	return nil
+}
@@ -1,24 +1,15 @@
 package models
 
 import (
-	"log"
-	"errors"
 	"fmt"
-	"strconv"
 	"time"
 	"encoding/json"
 
+	"github.com/astaxie/beego/validation"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/context"
 )
 
-var UserList map[int64]*User
-
-func init() {
-	UserList = make(map[int64]*User)
-	u := User{1111, "astaxie", "11111", time.Now(), time.Now(), Profile{"male", 20, "Singapore", "astaxie@gmail.com"}}
-	UserList[1111] = &u
-}
-
 type User struct {
 	Id         int64  `bson:"_id" gorm:"primary_key;AUTO_INCREMENT"`
 	Name       string  `bson:"name" gorm:"not null;unique;column:name;"`
@@ -26,6 +17,10 @@ type User struct {
 	CreateTime time.Time `gorm:"column:create_time"`
 	UpdateTime time.Time `gorm:"column:update_time"`
 	Profile    Profile `bson:"profile" gorm:"column:profile"`
+	DeletedAt  *time.Time `bson:"deletedat,omitempty" json:"-"`
+	// Source and ExternalID are the account of identity provider or directory which user is linked to
+	Source     string     `bson:"source,omitempty" json:",omitempty"`
+	ExternalID string     `bson:"externalid,omitempty" json:"-"`
 }
 
 type JSONTime time.Time
@@ -38,6 +33,11 @@ type User2 struct {
 	UpdateTime time.Time `json:"update_time" gorm:"column:update_time"`
 	Profile    string   `json:"-" gorm:"column:profile"`
 	Profile2   Profile  `gorm:"-" json:"profile"`
+	DeletedAt  *time.Time `json:"-" sql:"index"`
+	// Source and ExternalID are the account of identity provider or directory which user is linked to,
+	// source is the issuer of oidc users and "ldap" for directory users, both are empty for local users
+	Source     string   `json:"source" gorm:"column:source"`
+	ExternalID string   `json:"-" gorm:"column:external_id"`
 }
 
 type UserResp struct {
@@ -55,14 +55,21 @@ func (t JSONTime) MarshalJSON() ([]byte, error) {
 }
 
 type Profile struct {
-	Gender  string `bson:"gender" json:"gender"`
-	Age     int    `bson:"age" json:"age"`
-	Address string `bson:"address" json:"address"`
-	Email   string `bson:"email" json:"email"`
+	Gender  string `bson:"gender" json:"gender" valid:"Match(/^(male|female)?$/)"`
+	Age     int    `bson:"age" json:"age" valid:"Range(0,150)"`
+	Address string `bson:"address" json:"address" valid:"MaxSize(200)"`
+	Email   string `bson:"email" json:"email" valid:"MaxSize(100)"`
+}
+
+// Valid check email when it's provided, the empty profile is valid
+func (p *Profile) Valid(v *validation.Validation) {
+	if p.Email != "" {
+		v.Email(p.Email, "email")
+	}
 }
 
 func (u *User2) BeforeCreate() error {
-	now := time.Now()
+	now := Timestamp()
 	u.CreateTime = now
 	u.UpdateTime = now
 	data, err := json.Marshal(&u.Profile2)
@@ -82,7 +89,7 @@ func (u *User2) AfterUpdate() {
 }
 
 func (u *User2) BeforeUpdate() error {
-	now := time.Now()
+	now := Timestamp()
 	u.UpdateTime = now
 	data, err := json.Marshal(&u.Profile2)
 	if err != nil {
@@ -98,63 +105,6 @@ func (u *User2) AfterFind() error {
 	return err
 }
 
-func AddUser(u User) int64 {
-	u.Id = time.Now().UnixNano()
-	UserList[u.Id] = &u
-	return u.Id
-}
-
-func GetUser(uid string) (u *User, err error) {
-	int64, err := strconv.ParseInt(uid, 10, 64)
-	if u, ok := UserList[int64]; ok {
-		return u, nil
-	}
-	return nil, errors.New("User not exists")
-}
-
-func GetAllUsers() map[int64]*User {
-	return UserList
-}
-
-func UpdateUser(uid string, uu *User) (a *User, err error) {
-	int64, err := strconv.ParseInt(uid, 10, 64)
-	if u, ok := UserList[int64]; ok {
-		if uu.Name != "" {
-			u.Name = uu.Name
-		}
-		if uu.Password != "" {
-			u.Password = uu.Password
-		}
-		if uu.Profile.Age != 0 {
-			u.Profile.Age = uu.Profile.Age
-		}
-		if uu.Profile.Address != "" {
-			u.Profile.Address = uu.Profile.Address
-		}
-		if uu.Profile.Gender != "" {
-			u.Profile.Gender = uu.Profile.Gender
-		}
-		if uu.Profile.Email != "" {
-			u.Profile.Email = uu.Profile.Email
-		}
-		return u, nil
-	}
-	return nil, errors.New("User Not Exist")
-}
-
-func Login(username, password string) bool {
-	for _, u := range UserList {
-		if u.Name == username && u.Password == password {
-			return true
-		}
-	}
-	return false
-}
-
-func DeleteUser(uid string) {
-	//delete(UserList, uid)
-}
-
 const (
 	// BcryptCost is the strength of encryption
 	BcryptCost = 12
@@ -170,55 +120,72 @@ func checkPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-func GetAndVerifyUser(name, password string) (*User2, error) {	
-	user := &User2{}
-	gormDB.Where("name = ?", name).First(user)
-	if checkPasswordHash(password, user.Password) {
-		return user, nil
+// GetAndVerifyUser verify user by authenticators in order, the first succeeded one wins
+func GetAndVerifyUser(name, password string) (*User, error) {
+	for _, a := range authenticators {
+		if user, err := a.Authenticate(name, password); err == nil {
+			return user, nil
+		}
 	}
-	return nil, errors.New("user name or password is wrong")
+	return nil, ErrWrongPassword
 }
 
-func GetUsers(offset, limit int) ([]User2, int) {
-	var count int	
-	var users []User2
-	err := gormDB.Select("id, name, create_time, profile").Offset(offset).Limit(limit).Order("id asc").Find(&users).Error
-	if err != nil {
-		log.Printf("query failed:%v", err)
+// PurgeUser remove user in trash permanently with its persistent logins and refresh tokens
+func PurgeUser(ctx context.Context, id int64) error {
+	if err := Users().Purge(ctx, id); err != nil {
+		return err
 	}
-	gormDB.Table("user2").Count(&count)
 
-	return users, count
-}
-
-func GetUser2(uid int64) (*User2, error) {
-	var user User2
-	err := gormDB.Where("id = ?", uid).First(&user).Error
-	return &user, err
-}
-
-func SaveUser2(u *User2) error {
-	data, err := json.Marshal(&u.Profile2)
-	if err != nil {
+	tx := gormDB.Begin()
+	if err := tx.Unscoped().Where("user_id = ?", id).Delete(&RememberToken{}).Error; err != nil {
+		tx.Rollback()
 		return err
-	}	
-	now := time.Now()
-	return gormDB.Model(u).UpdateColumns(map[string]interface{}{"profile": data, "update_time": now}).Error
+	}
+	if err := tx.Unscoped().Where("user_id = ?", id).Delete(&RefreshToken{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
-func CreateUser2(u *User2) error {
-	passwordhash, err := encryptPassword(u.Password)
-	if err != nil {
-		return err
+// GetOrCreateExternalUser find user linked to the account externalID of source, user is created with
+// unusable password at its first login. Users are never linked by name, ErrUserNameExists is returned
+// when name is taken by a local user or another account, and ErrUserNotFound when the linked user is in trash
+func GetOrCreateExternalUser(ctx context.Context, source, externalID, name, email string) (*User, bool, error) {
+	if source == "" || externalID == "" {
+		return nil, false, ErrUserNotFound
+	}
+	// the user may have been created just now by another login
+	user, err := Users().GetByExternalID(OnPrimary(ctx), source, externalID)
+	if err == nil {
+		if user.DeletedAt != nil {
+			return nil, false, ErrUserNotFound
+		}
+		return user, false, nil
+	}
+	if err != ErrUserNotFound {
+		return nil, false, err
+	}
+	if userNameTaken(ctx, name) {
+		return nil, false, ErrUserNameExists
 	}
 
-	now := time.Now()
-	u.Password = passwordhash
-	u.CreateTime = now
-	u.UpdateTime = now
-	return gormDB.Create(u).Error
+	// external users never login with local password
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, false, err
+	}
+	user = &User{
+		Name:       name,
+		Password:   password,
+		Source:     source,
+		ExternalID: externalID,
+		Profile: Profile{
+			Email: email,
+		},
+	}
+	if err := Users().Create(ctx, user); err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
 }
-
-func DeleteUser2(id int64) error {
-	return gormDB.Delete(&User2{Id: id}).Error
-}
\ No newline at end of file
@@ -0,0 +1,379 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrUserNotFound is returned when user doesn't exist in repository
+	ErrUserNotFound = errors.New("user doesn't exist")
+)
+
+// UserRepository is the storage of users, the one set by SetUserRepository is shared by the api, admin console,
+// login, tokens, registration and external users. Roles are kept by the enforcer by user id, ids are kept
+// when users are migrated between stores
+type UserRepository interface {
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByName(ctx context.Context, name string) (*User, error)
+	// GetByExternalID get the user linked to the account externalID of source, users in trash are found too
+	GetByExternalID(ctx context.Context, source, externalID string) (*User, error)
+	// List return users in page of query and the total number of users matched by query
+	List(ctx context.Context, q *Query) ([]User, int, error)
+	// ListTrash return users in trash by page, the latest deleted one is the first, and the number of them
+	ListTrash(ctx context.Context, offset, limit int) ([]User, int, error)
+	// Create hash the password of user and assign its id, names of users in trash are taken too
+	Create(ctx context.Context, u *User) error
+	// CreateHashed create user whose password has been hashed by HashPassword, e.g. of registration
+	CreateHashed(ctx context.Context, u *User) error
+	// Update save name and profile of user, password is changed when it's not empty.
+	// The update time of u is the version which the change is based on, ErrVersionConflict is returned
+	// when the stored user has been changed since then, the new update time is set to u after saving
+	Update(ctx context.Context, u *User) error
+	// Delete move user of the version to trash, AnyVersion removes it unconditionally
+	Delete(ctx context.Context, id int64, version int64) error
+	// Restore take user out of trash, ErrNotInTrash is returned when it isn't there
+	Restore(ctx context.Context, id int64) error
+	// Purge remove user in trash permanently, ErrNotInTrash is returned when it isn't there
+	Purge(ctx context.Context, id int64) error
+	// Import save user as it is, the id and password hash are kept
+	Import(ctx context.Context, u *User) error
+}
+
+var users UserRepository
+
+// SetUserRepository set the store of users, it must be called before serving requests
+func SetUserRepository(r UserRepository) {
+	users = r
+}
+
+// Users return the store of users set by SetUserRepository
+func Users() UserRepository {
+	if users == nil {
+		panic("models: user repository isn't set, SetUserRepository must be called at startup")
+	}
+	return users
+}
+
+// HashPassword encrypt password in the way GetAndVerifyUser checks it
+func HashPassword(password string) (string, error) {
+	return encryptPassword(password)
+}
+
+// MigrateUsers copy all users including the ones in trash from one repository to another by batch,
+// return the number of copied users
+func MigrateUsers(ctx context.Context, from, to UserRepository, batch int) (int, error) {
+	if batch <= 0 {
+		batch = 100
+	}
+
+	migrated := 0
+	for offset := 0; ; offset += batch {
+		users, _, err := from.List(ctx, NewQuery(UserQuerySchema, offset, batch))
+		if err != nil {
+			return migrated, err
+		}
+		for i := range users {
+			if err := to.Import(ctx, &users[i]); err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+		if len(users) < batch {
+			break
+		}
+	}
+	for offset := 0; ; offset += batch {
+		users, _, err := from.ListTrash(ctx, offset, batch)
+		if err != nil {
+			return migrated, err
+		}
+		for i := range users {
+			if err := to.Import(ctx, &users[i]); err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+		if len(users) < batch {
+			return migrated, nil
+		}
+	}
+}
+
+// PostgresUserRepository stores users in the user2 table of postgres,
+// users are read from replicas unless ctx is marked by OnPrimary, the reasons of failed writes are checked on primary
+type PostgresUserRepository struct {
+	db *Cluster
+}
+
+// NewPostgresUserRepository create the user repository of postgres on the connection pools of db
+func NewPostgresUserRepository(db *Cluster) *PostgresUserRepository {
+	return &PostgresUserRepository{db: db}
+}
+
+func userFromUser2(u2 *User2) *User {
+	return &User{
+		Id:         u2.Id,
+		Name:       u2.Name,
+		Password:   u2.Password,
+		CreateTime: u2.CreateTime,
+		UpdateTime: u2.UpdateTime,
+		Profile:    u2.Profile2,
+		DeletedAt:  u2.DeletedAt,
+		Source:     u2.Source,
+		ExternalID: u2.ExternalID,
+	}
+}
+
+func (r *PostgresUserRepository) find(ctx context.Context, run func(context.Context, func(*gorm.DB) error) error, query string, arg interface{}) (*User, error) {
+	u2 := &User2{}
+	err := run(ctx, func(tx *gorm.DB) error {
+		return tx.Where(query, arg).First(u2).Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return userFromUser2(u2), nil
+}
+
+func (r *PostgresUserRepository) Get(ctx context.Context, id int64) (*User, error) {
+	return r.find(ctx, r.db.read, "id = ?", id)
+}
+
+func (r *PostgresUserRepository) GetByName(ctx context.Context, name string) (*User, error) {
+	return r.find(ctx, r.db.read, "name = ?", name)
+}
+
+func (r *PostgresUserRepository) GetByExternalID(ctx context.Context, source, externalID string) (*User, error) {
+	if source == "" || externalID == "" {
+		return nil, ErrUserNotFound
+	}
+	u2 := &User2{}
+	err := r.db.read(ctx, func(tx *gorm.DB) error {
+		return tx.Unscoped().Where("source = ? AND external_id = ?", source, externalID).First(u2).Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return userFromUser2(u2), nil
+}
+
+func (r *PostgresUserRepository) List(ctx context.Context, q *Query) ([]User, int, error) {
+	var count int
+	var users []User2
+	err := r.db.read(ctx, func(tx *gorm.DB) error {
+		if err := q.Where(tx.Model(&User2{})).Count(&count).Error; err != nil {
+			return err
+		}
+		return q.Page(q.Where(tx)).Find(&users).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]User, 0, len(users))
+	for i := range users {
+		result = append(result, *userFromUser2(&users[i]))
+	}
+	return result, count, nil
+}
+
+func (r *PostgresUserRepository) ListTrash(ctx context.Context, offset, limit int) ([]User, int, error) {
+	var count int
+	var users []User2
+	err := r.db.read(ctx, func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&User2{}).Where("deleted_at IS NOT NULL").Count(&count).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at desc").
+			Offset(offset).Limit(limit).Find(&users).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]User, 0, len(users))
+	for i := range users {
+		result = append(result, *userFromUser2(&users[i]))
+	}
+	return result, count, nil
+}
+
+func (r *PostgresUserRepository) Create(ctx context.Context, u *User) error {
+	if err := CheckUserName(u.Name); err != nil {
+		return err
+	}
+	passwordhash, err := encryptPassword(u.Password)
+	if err != nil {
+		return err
+	}
+	return r.create(ctx, u, passwordhash)
+}
+
+func (r *PostgresUserRepository) CreateHashed(ctx context.Context, u *User) error {
+	if err := CheckUserName(u.Name); err != nil {
+		return err
+	}
+	return r.create(ctx, u, u.Password)
+}
+
+func (r *PostgresUserRepository) create(ctx context.Context, u *User, passwordhash string) error {
+	u2 := &User2{Name: u.Name, Password: passwordhash, Profile2: u.Profile, Source: u.Source, ExternalID: u.ExternalID}
+	err := r.db.write(ctx, func(tx *gorm.DB) error {
+		// names of users in trash are taken until they are purged
+		var count int
+		if err := tx.Unscoped().Model(&User2{}).Where("name = ?", u.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUserNameExists
+		}
+		return tx.Create(u2).Error
+	})
+	if err != nil {
+		return err
+	}
+	u.Id = u2.Id
+	u.Password = u2.Password
+	u.CreateTime = u2.CreateTime
+	u.UpdateTime = u2.UpdateTime
+	return nil
+}
+
+func (r *PostgresUserRepository) Update(ctx context.Context, u *User) error {
+	if u.Id <= 0 {
+		return ErrUserNotFound
+	}
+	if err := CheckUserName(u.Name); err != nil {
+		return err
+	}
+	profile, err := json.Marshal(&u.Profile)
+	if err != nil {
+		return err
+	}
+	columns := map[string]interface{}{
+		"name":        u.Name,
+		"profile":     string(profile),
+		"update_time": Timestamp(),
+	}
+	if u.Password != "" {
+		passwordhash, err := encryptPassword(u.Password)
+		if err != nil {
+			return err
+		}
+		columns["password"] = passwordhash
+	}
+
+	var affected int64
+	err = r.db.write(ctx, func(tx *gorm.DB) error {
+		db := tx.Model(&User2{}).Where("id = ? AND update_time = ?", u.Id, u.UpdateTime).UpdateColumns(columns)
+		affected = db.RowsAffected
+		return db.Error
+	})
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.missed(ctx, u.Id)
+	}
+	u.UpdateTime = columns["update_time"].(time.Time)
+	return nil
+}
+
+func (r *PostgresUserRepository) Delete(ctx context.Context, id int64, version int64) error {
+	if id <= 0 {
+		return ErrUserNotFound
+	}
+	var affected int64
+	err := r.db.write(ctx, func(tx *gorm.DB) error {
+		// gorm drops the condition of zero primary key, so id is always an explicit condition
+		db := tx.Where("id = ?", id)
+		if version != AnyVersion {
+			db = db.Where("update_time = ?", versionTime(version))
+		}
+		db = db.Delete(&User2{})
+		affected = db.RowsAffected
+		return db.Error
+	})
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.missed(ctx, id)
+	}
+	return nil
+}
+
+func (r *PostgresUserRepository) Restore(ctx context.Context, id int64) error {
+	return r.db.write(ctx, func(tx *gorm.DB) error {
+		return restore(tx, &User2{}, id)
+	})
+}
+
+func (r *PostgresUserRepository) Purge(ctx context.Context, id int64) error {
+	return r.db.write(ctx, func(tx *gorm.DB) error {
+		return purge(tx, &User2{}, id)
+	})
+}
+
+// missed tell why no user is changed, it's either deleted or changed by others
+func (r *PostgresUserRepository) missed(ctx context.Context, id int64) error {
+	if _, err := r.find(ctx, r.db.write, "id = ?", id); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+func (r *PostgresUserRepository) Import(ctx context.Context, u *User) error {
+	if u.Id <= 0 {
+		return ErrUserNotFound
+	}
+	profile, err := json.Marshal(&u.Profile)
+	if err != nil {
+		return err
+	}
+	columns := map[string]interface{}{
+		"name":        u.Name,
+		"password":    u.Password,
+		"profile":     string(profile),
+		"create_time": u.CreateTime,
+		"update_time": u.UpdateTime,
+		"deleted_at":  u.DeletedAt,
+		"source":      u.Source,
+		"external_id": u.ExternalID,
+	}
+
+	tx := r.db.Primary().Begin()
+	db := tx.Unscoped().Model(&User2{}).Where("id = ?", u.Id).UpdateColumns(columns)
+	if db.Error != nil {
+		tx.Rollback()
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		// hooks of User2 would overwrite the imported times and profile, so insert by sql
+		err = tx.Exec(`INSERT INTO user2 (id, name, password, profile, create_time, update_time, deleted_at, source, external_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			u.Id, u.Name, u.Password, string(profile), u.CreateTime, u.UpdateTime, u.DeletedAt, u.Source, u.ExternalID).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		// keep the id sequence ahead of imported ids
+		err = tx.Exec("SELECT setval(pg_get_serial_sequence('user2', 'id'), (SELECT MAX(id) FROM user2))").Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// memoryUserRepository keeps users in a slice ordered by id
+type memoryUserRepository struct {
+	users []User
+}
+
+func (r *memoryUserRepository) Get(ctx context.Context, id int64) (*User, error) {
+	for i := range r.users {
+		if r.users[i].Id == id {
+			return &r.users[i], nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (r *memoryUserRepository) GetByName(ctx context.Context, name string) (*User, error) {
+	for i := range r.users {
+		if r.users[i].Name == name {
+			return &r.users[i], nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (r *memoryUserRepository) GetByExternalID(ctx context.Context, source, externalID string) (*User, error) {
+	for i := range r.users {
+		if r.users[i].Source == source && r.users[i].ExternalID == externalID {
+			return &r.users[i], nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// page return the users in trash or not by offset and limit
+func (r *memoryUserRepository) page(trash bool, offset, limit int) ([]User, int) {
+	var matched []User
+	for _, u := range r.users {
+		if (u.DeletedAt != nil) == trash {
+			matched = append(matched, u)
+		}
+	}
+	if offset >= len(matched) {
+		return nil, len(matched)
+	}
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return matched[offset:end], len(matched)
+}
+
+func (r *memoryUserRepository) List(ctx context.Context, q *Query) ([]User, int, error) {
+	users, total := r.page(false, q.Offset, q.Limit)
+	return users, total, nil
+}
+
+func (r *memoryUserRepository) ListTrash(ctx context.Context, offset, limit int) ([]User, int, error) {
+	users, total := r.page(true, offset, limit)
+	return users, total, nil
+}
+
+func (r *memoryUserRepository) Create(ctx context.Context, u *User) error {
+	passwordhash, err := HashPassword(u.Password)
+	if err != nil {
+		return err
+	}
+	u.Password = passwordhash
+	return r.CreateHashed(ctx, u)
+}
+
+func (r *memoryUserRepository) CreateHashed(ctx context.Context, u *User) error {
+	u.Id = int64(len(r.users) + 1)
+	return r.Import(ctx, u)
+}
+
+func (r *memoryUserRepository) Update(ctx context.Context, u *User) error {
+	return r.Import(ctx, u)
+}
+
+func (r *memoryUserRepository) Delete(ctx context.Context, id int64, version int64) error {
+	return nil
+}
+
+func (r *memoryUserRepository) Restore(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (r *memoryUserRepository) Purge(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (r *memoryUserRepository) Import(ctx context.Context, u *User) error {
+	if old, err := r.Get(ctx, u.Id); err == nil {
+		*old = *u
+		return nil
+	}
+	r.users = append(r.users, *u)
+	return nil
+}
+
+func TestMigrateUsers(t *testing.T) {
+	from := &memoryUserRepository{}
+	for i := 1; i <= 5; i++ {
+		from.users = append(from.users, User{Id: int64(i), Name: string(rune('a' + i)), Password: "hash"})
+	}
+	deletedAt := time.Now()
+	from.users[3].DeletedAt = &deletedAt
+	to := &memoryUserRepository{users: []User{{Id: 2, Name: "stale"}}}
+
+	count, err := MigrateUsers(context.Background(), from, to, 2)
+	if err != nil {
+		t.Fatalf("migrate users failed:%v", err)
+	}
+	if count != 5 || len(to.users) != 5 {
+		t.Fatalf("expect 5 users migrated but got %d, destination has %d", count, len(to.users))
+	}
+	if u, _ := to.Get(context.Background(), 2); u.Name != "c" || u.Password != "hash" {
+		t.Errorf("existing user should be overwritten with password hash kept:%+v", u)
+	}
+	if u, _ := to.Get(context.Background(), 4); u.DeletedAt == nil {
+		t.Errorf("user in trash should stay in trash:%+v", u)
+	}
+}
+
+func TestLocalAuthenticator(t *testing.T) {
+	defer SetUserRepository(users)
+	repo := &memoryUserRepository{}
+	SetUserRepository(repo)
+	if err := repo.Create(context.Background(), &User{Name: "alice", Password: "secret"}); err != nil {
+		t.Fatalf("create user failed:%v", err)
+	}
+
+	a := &LocalAuthenticator{}
+	if u, err := a.Authenticate("alice", "secret"); err != nil || u.Name != "alice" {
+		t.Errorf("alice should be verified by the repository, got %v %v", u, err)
+	}
+	if _, err := a.Authenticate("alice", "wrong"); err != ErrWrongPassword {
+		t.Errorf("wrong password should be rejected, got %v", err)
+	}
+	if _, err := a.Authenticate("bob", "secret"); err != ErrWrongPassword {
+		t.Errorf("unknown user should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// AnyVersion matches whatever version the record is, it's used when client doesn't require a version
+const AnyVersion int64 = -1
+
+var (
+	// ErrVersionConflict is returned when the record has been changed since the version which the change is based on
+	ErrVersionConflict = errors.New("record has been modified")
+)
+
+// Timestamp return current time in the precision which postgres and mongo both keep,
+// so the update time read back is the same as the written one and can be used as version
+func Timestamp() time.Time {
+	return time.Now().Truncate(time.Millisecond)
+}
+
+// Version of user is its update time
+func (u *User) Version() int64 {
+	return u.UpdateTime.UnixNano()
+}
+
+// Version of user is its update time
+func (u *User2) Version() int64 {
+	return u.UpdateTime.UnixNano()
+}
+
+// versionTime convert the version of user to its update time
+func versionTime(version int64) time.Time {
+	return time.Unix(0, version)
+}
@@ -0,0 +1,392 @@
+// Package patch applies JSON Merge Patch (RFC 7386) and JSON Patch (RFC 6902) documents to json documents.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// media types of patch documents
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var (
+	// ErrUnsupportedType is returned when the media type of patch is neither merge patch nor json patch
+	ErrUnsupportedType = errors.New("patch media type is unsupported")
+	// ErrTestFailed is returned when the value of test operation isn't the one in document
+	ErrTestFailed = errors.New("test operation failed")
+)
+
+// Error tells which operation of json patch fails
+type Error struct {
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation %d '%s %s':%v", e.Index, e.Op, e.Path, e.Err)
+}
+
+// Apply patch to doc by the media type of patch
+func Apply(contentType string, doc, patch []byte) ([]byte, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	switch mediaType {
+	case MergePatchType:
+		return MergePatch(doc, patch)
+	case JSONPatchType:
+		return JSONPatch(doc, patch)
+	}
+	return nil, ErrUnsupportedType
+}
+
+// MergePatch apply merge patch to doc, members of patch replace the ones of doc and null removes the member
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if err := decode(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := decode(patch, &p); err != nil {
+		return nil, fmt.Errorf("merge patch is invalid:%v", err)
+	}
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = merge(t[k], v)
+		}
+	}
+	return t
+}
+
+type operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// JSONPatch apply the operations of json patch to doc in order, doc is unchanged if any operation fails
+func JSONPatch(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := decode(doc, &target); err != nil {
+		return nil, err
+	}
+	var ops []operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("json patch is invalid:%v", err)
+	}
+
+	for i, op := range ops {
+		var err error
+		if target, err = op.apply(target); err != nil {
+			return nil, &Error{Index: i, Op: op.Op, Path: op.Path, Err: err}
+		}
+	}
+	return json.Marshal(target)
+}
+
+func (op *operation) value() (interface{}, error) {
+	if len(op.Value) == 0 {
+		return nil, errors.New("value is missing")
+	}
+	var v interface{}
+	err := decode(op.Value, &v)
+	return v, err
+}
+
+func (op *operation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, v)
+	case "remove":
+		return remove(doc, path)
+	case "replace":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := get(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return v, nil
+		}
+		return update(doc, path, func(parent interface{}, key string) (interface{}, error) {
+			return setChild(parent, key, v)
+		})
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			return add(doc, path, deepCopy(v))
+		}
+		if op.Path == op.From {
+			return doc, nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, errors.New("can't move a value into its child")
+		}
+		if doc, err = remove(doc, from); err != nil {
+			return nil, err
+		}
+		return add(doc, path, v)
+	case "test":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, v) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown operation '%s'", op.Op)
+}
+
+// parsePointer split json pointer into reference tokens, the empty pointer refers to the whole document
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("pointer '%s' must start with '/'", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, key := range path {
+		var err error
+		if doc, err = child(doc, key); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func add(doc interface{}, path []string, v interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	return update(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[key] = v
+			return p, nil
+		case []interface{}:
+			if key == "-" {
+				return append(p, v), nil
+			}
+			i, err := index(key, len(p)+1)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = v
+			return p, nil
+		}
+		return nil, fmt.Errorf("can't add '%s' to a value", key)
+	})
+}
+
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("can't remove the whole document")
+	}
+	return update(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := p[key]; !ok {
+				return nil, fmt.Errorf("member '%s' doesn't exist", key)
+			}
+			delete(p, key)
+			return p, nil
+		case []interface{}:
+			i, err := index(key, len(p))
+			if err != nil {
+				return nil, err
+			}
+			return append(p[:i], p[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("can't remove '%s' from a value", key)
+	})
+}
+
+// update replace the parent of the last token of path by fn, the changed containers are written back to doc
+func update(doc interface{}, path []string, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	c, err := child(doc, path[0])
+	if err != nil {
+		return nil, err
+	}
+	if c, err = update(c, path[1:], fn); err != nil {
+		return nil, err
+	}
+	return setChild(doc, path[0], c)
+}
+
+func child(doc interface{}, key string) (interface{}, error) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		v, ok := d[key]
+		if !ok {
+			return nil, fmt.Errorf("member '%s' doesn't exist", key)
+		}
+		return v, nil
+	case []interface{}:
+		i, err := index(key, len(d))
+		if err != nil {
+			return nil, err
+		}
+		return d[i], nil
+	}
+	return nil, fmt.Errorf("'%s' refers to a child of value", key)
+}
+
+func setChild(doc interface{}, key string, v interface{}) (interface{}, error) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		if _, ok := d[key]; !ok {
+			return nil, fmt.Errorf("member '%s' doesn't exist", key)
+		}
+		d[key] = v
+		return d, nil
+	case []interface{}:
+		i, err := index(key, len(d))
+		if err != nil {
+			return nil, err
+		}
+		d[i] = v
+		return d, nil
+	}
+	return nil, fmt.Errorf("'%s' refers to a child of value", key)
+}
+
+// index parse array index which must be less than size, leading zeros aren't allowed
+func index(key string, size int) (int, error) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || (len(key) > 1 && key[0] == '0') {
+		return 0, fmt.Errorf("'%s' isn't an array index", key)
+	}
+	if i >= size {
+		return 0, fmt.Errorf("index %d is out of range", i)
+	}
+	return i, nil
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = deepCopy(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, e := range t {
+			s[i] = deepCopy(e)
+		}
+		return s
+	}
+	return v
+}
+
+// equal compare json values, numbers are equal when their values are the same whatever they are written
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		i, err1 := x.Int64()
+		j, err2 := y.Int64()
+		if err1 == nil && err2 == nil {
+			return i == j
+		}
+		f, err1 := x.Float64()
+		g, err2 := y.Float64()
+		return err1 == nil && err2 == nil && f == g
+	}
+	return a == b
+}
+
+// decode unmarshal json keeping numbers as they are, so big integers like ids aren't rounded
+func decode(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,76 @@
+package patch
+
+import (
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	doc := `{"Name":"astaxie","Profile":{"age":20,"address":"Singapore","email":"astaxie@gmail.com"}}`
+	patch := `{"Profile":{"age":0,"address":null}}`
+	result, err := MergePatch([]byte(doc), []byte(patch))
+	if err != nil {
+		t.Fatalf("merge patch failed:%v", err)
+	}
+	expect := `{"Name":"astaxie","Profile":{"age":0,"email":"astaxie@gmail.com"}}`
+	if string(result) != expect {
+		t.Errorf("expect %s but got %s", expect, result)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	doc := `{"Name":"astaxie","Id":1520000000000000001,"Tags":["a","b"],"Profile":{"age":20,"address":"Singapore"}}`
+	patch := `[
+		{"op":"test","path":"/Name","value":"astaxie"},
+		{"op":"replace","path":"/Profile/age","value":0},
+		{"op":"remove","path":"/Profile/address"},
+		{"op":"add","path":"/Tags/1","value":"c"},
+		{"op":"add","path":"/Tags/-","value":"d"},
+		{"op":"copy","from":"/Name","path":"/Profile/nick"},
+		{"op":"move","from":"/Tags/0","path":"/First"}
+	]`
+	result, err := JSONPatch([]byte(doc), []byte(patch))
+	if err != nil {
+		t.Fatalf("json patch failed:%v", err)
+	}
+	expect := `{"First":"a","Id":1520000000000000001,"Name":"astaxie","Profile":{"age":0,"nick":"astaxie"},"Tags":["c","b","d"]}`
+	if string(result) != expect {
+		t.Errorf("expect %s but got %s", expect, result)
+	}
+}
+
+func TestJSONPatchFailure(t *testing.T) {
+	doc := `{"Name":"astaxie","Score":100,"Tags":["a"]}`
+	cases := map[string]string{
+		"test failed":     `[{"op":"test","path":"/Score","value":101}]`,
+		"missing member":  `[{"op":"replace","path":"/Age","value":1}]`,
+		"out of range":    `[{"op":"add","path":"/Tags/2","value":"b"}]`,
+		"leading zero":    `[{"op":"remove","path":"/Tags/00"}]`,
+		"missing value":   `[{"op":"add","path":"/Age"}]`,
+		"move into child": `[{"op":"move","from":"/Tags","path":"/Tags/0"}]`,
+		"unknown op":      `[{"op":"merge","path":"/Name","value":"a"}]`,
+		"invalid pointer": `[{"op":"remove","path":"Name"}]`,
+	}
+	for name, patch := range cases {
+		if _, err := JSONPatch([]byte(doc), []byte(patch)); err == nil {
+			t.Errorf("%s: patch should fail", name)
+		}
+	}
+
+	_, err := JSONPatch([]byte(doc), []byte(`[{"op":"test","path":"/Score","value":100.0},{"op":"test","path":"/Name","value":"x"}]`))
+	if e, ok := err.(*Error); !ok || e.Index != 1 || e.Err != ErrTestFailed {
+		t.Errorf("the second test operation should fail, got %v", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	doc := []byte(`{"Score":1}`)
+	if result, err := Apply("application/merge-patch+json; charset=utf-8", doc, []byte(`{"Score":2}`)); err != nil || string(result) != `{"Score":2}` {
+		t.Errorf("merge patch should be applied, result:%s err:%v", result, err)
+	}
+	if result, err := Apply(JSONPatchType, doc, []byte(`[{"op":"replace","path":"/Score","value":3}]`)); err != nil || string(result) != `{"Score":3}` {
+		t.Errorf("json patch should be applied, result:%s err:%v", result, err)
+	}
+	if _, err := Apply("application/json", doc, []byte(`{}`)); err != ErrUnsupportedType {
+		t.Errorf("plain json should be unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"golang.org/x/net/context"
+
+	"github.com/slover2000/beego_demo/dao"
+	"github.com/slover2000/beego_demo/models"
+	"github.com/slover2000/beego_demo/services"
+)
+
+// newUserRepository create user repository by store name, postgres or mongo
+func newUserRepository(store string, db *models.Cluster) (models.UserRepository, error) {
+	switch store {
+	case "postgres":
+		return models.NewPostgresUserRepository(db), nil
+	case "mongo":
+		return dao.NewMongoUserRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown user store '%s'", store)
+	}
+}
+
+// newObjectRepository create object repository by store name, postgres, mongo or memory
+func newObjectRepository(store string, db *models.Cluster) (models.ObjectRepository, error) {
+	switch store {
+	case "postgres":
+		return models.NewPostgresObjectRepository(db), nil
+	case "mongo":
+		return dao.NewMongoObjectRepository(), nil
+	case "memory":
+		return models.NewMemoryObjectRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown object store '%s'", store)
+	}
+}
+
+// migrateUsers copy users between stores, e.g. "migrate-users -from postgres -to mongo"
+func migrateUsers(args []string, db *models.Cluster) error {
+	flags := flag.NewFlagSet("migrate-users", flag.ContinueOnError)
+	from := flags.String("from", "postgres", "the store users are read from, postgres or mongo")
+	to := flags.String("to", "mongo", "the store users are written to, postgres or mongo")
+	batch := flags.Int("batch", 100, "the number of users read at once")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == *to {
+		return fmt.Errorf("source and destination are both '%s'", *from)
+	}
+
+	source, err := newUserRepository(*from, db)
+	if err != nil {
+		return err
+	}
+	destination, err := newUserRepository(*to, db)
+	if err != nil {
+		return err
+	}
+
+	count, err := models.MigrateUsers(context.Background(), source, destination, *batch)
+	log.Printf("%d users are migrated from %s to %s", count, *from, *to)
+	return err
+}
+
+// reindexUsers rebuild the search index from the user store, e.g. "reindex-users -batch 500"
+func reindexUsers(args []string, store string, db *models.Cluster) error {
+	flags := flag.NewFlagSet("reindex-users", flag.ContinueOnError)
+	batch := flags.Int("batch", 500, "the number of users indexed at once")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	repo, err := newUserRepository(store, db)
+	if err != nil {
+		return err
+	}
+	count, err := services.ReindexUsers(context.Background(), repo, *batch)
+	log.Printf("%d users are indexed from %s", count, store)
+	return err
+}
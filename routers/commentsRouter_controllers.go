@@ -47,6 +47,14 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:ObjectController"] = append(beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:ObjectController"],
+		beego.ControllerComments{
+			Method: "Patch",
+			Router: `/:objectId`,
+			AllowHTTPMethods: []string{"patch"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:ObjectController"] = append(beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:ObjectController"],
 		beego.ControllerComments{
 			Method: "Delete",
@@ -71,6 +79,14 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:UserController"] = append(beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:UserController"],
+		beego.ControllerComments{
+			Method: "Search",
+			Router: `/search`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:UserController"] = append(beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:UserController"],
 		beego.ControllerComments{
 			Method: "Get",
@@ -87,6 +103,14 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:UserController"] = append(beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:UserController"],
+		beego.ControllerComments{
+			Method: "Patch",
+			Router: `/:uid`,
+			AllowHTTPMethods: []string{"patch"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:UserController"] = append(beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:UserController"],
 		beego.ControllerComments{
 			Method: "Delete",
@@ -99,7 +123,15 @@ func init() {
 		beego.ControllerComments{
 			Method: "Login",
 			Router: `/login`,
-			AllowHTTPMethods: []string{"get"},
+			AllowHTTPMethods: []string{"post"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:UserController"] = append(beego.GlobalControllerRouter["github.com/slover2000/beego_demo/controllers:UserController"],
+		beego.ControllerComments{
+			Method: "Refresh",
+			Router: `/refresh`,
+			AllowHTTPMethods: []string{"post"},
 			MethodParams: param.Make(),
 			Params: nil})
 
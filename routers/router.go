@@ -32,11 +32,18 @@ func init() {
 		),
 	)
 	beego.AddNamespace(ns)
+	beego.InsertFilter("*", beego.BeforeRouter, controllers.AssignRequestID)
+	beego.InsertFilter("/v1/*", beego.BeforeRouter, controllers.AuthenticateAPI)
 
 	beego.Router("/", &controllers.LoginController{}, "*:ShowPage")
 	beego.Router("/home", &controllers.HomeController{}, "*:Index")
 	beego.Router("/login", &controllers.LoginController{}, "*:Login")
-	beego.Router("/logout", &controllers.LoginController{}, "*:Logout")	
+	beego.Router("/logout", &controllers.LoginController{}, "*:Logout")
+	beego.Router("/logout/all", &controllers.LoginController{}, "POST:LogoutEverywhere")
+	beego.Router("/sso/login", &controllers.LoginController{}, "GET:SSOLogin")
+	beego.Router("/sso/callback", &controllers.LoginController{}, "GET:SSOCallback")
+	beego.Router("/register", &controllers.LoginController{}, "GET:ShowRegister;POST:Register")
+	beego.Router("/register/verify", &controllers.LoginController{}, "GET:VerifyRegistration")	
 	beego.Router("/admin/users", &controllers.AdminController{}, "GET:UserList")
 	beego.Router("/admin/users/list", &controllers.AdminController{}, "GET:GetUsers")
 	beego.Router("/admin/user", &controllers.AdminController{}, "GET:GetUser;PUT:SaveUser;POST:CreateUser;DELETE:DeleteUser")	
@@ -46,4 +53,14 @@ func init() {
 	beego.Router("/admin/permissions", &controllers.AdminController{}, "GET:PermissionList")
 	beego.Router("/admin/permission", &controllers.AdminController{}, "GET:GetPermission;POST:CreatePermission;DELETE:DeletePermission")
 	beego.Router("/admin/group", &controllers.AdminController{}, "GET:GetGroup;POST:CreateGroup;DELETE:DeleteGroup")
+	beego.Router("/admin/apikeys", &controllers.AdminController{}, "GET:APIKeyList")
+	beego.Router("/admin/apikeys/list", &controllers.AdminController{}, "GET:GetAPIKeys")
+	beego.Router("/admin/apikey", &controllers.AdminController{}, "GET:GetAPIKey;POST:CreateAPIKey;DELETE:DeleteAPIKey")
+	beego.Router("/admin/registrations", &controllers.AdminController{}, "GET:RegistrationList")
+	beego.Router("/admin/registrations/list", &controllers.AdminController{}, "GET:GetRegistrations")
+	beego.Router("/admin/registration", &controllers.AdminController{}, "POST:ApproveRegistration;DELETE:RejectRegistration")
+	beego.Router("/admin/trash", &controllers.AdminController{}, "GET:TrashList")
+	beego.Router("/admin/trash/list", &controllers.AdminController{}, "GET:GetTrash")
+	beego.Router("/admin/trash/item", &controllers.AdminController{}, "PUT:RestoreTrash;DELETE:PurgeTrash")
+	beego.Router("/admin/search", &controllers.AdminController{}, "GET:Search")
 }
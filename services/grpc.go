@@ -0,0 +1,313 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/slover2000/prisma"
+	"github.com/slover2000/prisma/discovery"
+)
+
+// ErrUnknownService is returned when the downstream service isn't configured
+var ErrUnknownService = errors.New("grpc service isn't configured")
+
+// GRPCConfig is the settings of downstream grpc services
+type GRPCConfig struct {
+	Discovery DiscoveryConfig
+	Services  []ServiceConfig
+}
+
+// DiscoveryConfig tells how services are found. Backend etcd resolves the endpoints of services from Endpoint
+// and balances calls among them, direct dials the Target of every service. DialTimeout is in seconds
+type DiscoveryConfig struct {
+	Backend     string `default:"etcd"`
+	Endpoint    string
+	DialTimeout int `default:"5"`
+}
+
+// ServiceConfig is a downstream service. Name is the key of client in registry, Service and Env are the name and
+// environment (product, staging, test or dev) registered in discovery. DialTimeout in seconds bounds connecting
+// at startup, which fails when the service isn't reachable in time. RequestTimeout in milliseconds bounds
+// the calls without deadline, zero means unbounded
+type ServiceConfig struct {
+	Name           string
+	Service        string
+	Env            string
+	Target         string
+	DialTimeout    int
+	RequestTimeout int
+	TLS            TLSConfig
+	Retry          RetryConfig
+}
+
+// TLSConfig secures the connections of service, they are plaintext unless Enable is on.
+// CertFile and KeyFile are the client certificate when server verifies clients
+type TLSConfig struct {
+	Enable             bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// RetryConfig retries the failed unary calls whose status is one of Codes, e.g. "Unavailable".
+// Backoffs are in milliseconds and double after every attempt, MaxAttempts of 0 or 1 never retries
+type RetryConfig struct {
+	MaxAttempts    int
+	InitialBackoff int
+	MaxBackoff     int
+	Codes          []string
+}
+
+// the defaults of fields which are zero in config
+const (
+	defaultServiceDialTimeout = 10
+	defaultInitialBackoff     = 100
+	defaultMaxBackoff         = 1000
+)
+
+var environments = map[string]discovery.EnvType{
+	"product": discovery.Product,
+	"staging": discovery.Staging,
+	"test":    discovery.Test,
+	"dev":     discovery.Dev,
+}
+
+// ClientRegistry keeps a connection to every downstream service, it's safe for concurrent use
+type ClientRegistry struct {
+	lock  sync.RWMutex
+	conns map[string]*grpc.ClientConn
+}
+
+var clients = &ClientRegistry{conns: make(map[string]*grpc.ClientConn)}
+
+// InitGRPCClients dial the services of config, the connections are got by GRPCConn
+func InitGRPCClients(cfg *GRPCConfig, interceptorClient *prisma.InterceptorClient) error {
+	r, err := NewClientRegistry(cfg, interceptorClient)
+	if err != nil {
+		return err
+	}
+	clients = r
+	return nil
+}
+
+// CloseGRPCClients close the connections dialed by InitGRPCClients
+func CloseGRPCClients() {
+	clients.Close()
+}
+
+// GRPCConn return the connection of service by its name in config
+func GRPCConn(name string) (*grpc.ClientConn, error) {
+	return clients.Conn(name)
+}
+
+// NewClientRegistry dial all services of config, calls are traced and measured by interceptorClient
+func NewClientRegistry(cfg *GRPCConfig, interceptorClient *prisma.InterceptorClient) (*ClientRegistry, error) {
+	r := &ClientRegistry{conns: make(map[string]*grpc.ClientConn)}
+	for i := range cfg.Services {
+		svc := &cfg.Services[i]
+		if svc.Name == "" {
+			r.Close()
+			return nil, fmt.Errorf("grpc service %d has no name", i)
+		}
+		if _, ok := r.conns[svc.Name]; ok {
+			r.Close()
+			return nil, fmt.Errorf("grpc service '%s' is configured twice", svc.Name)
+		}
+		conn, err := dialService(&cfg.Discovery, svc, interceptorClient)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("dial grpc service '%s' failed:%v", svc.Name, err)
+		}
+		r.conns[svc.Name] = conn
+	}
+	return r, nil
+}
+
+// Conn return the connection of service
+func (r *ClientRegistry) Conn(name string) (*grpc.ClientConn, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if conn, ok := r.conns[name]; ok {
+		return conn, nil
+	}
+	return nil, ErrUnknownService
+}
+
+// Close all connections
+func (r *ClientRegistry) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for name, conn := range r.conns {
+		conn.Close()
+		delete(r.conns, name)
+	}
+}
+
+func dialService(d *DiscoveryConfig, svc *ServiceConfig, interceptorClient *prisma.InterceptorClient) (*grpc.ClientConn, error) {
+	options := make([]grpc.DialOption, 0)
+	target := svc.Target
+	switch d.Backend {
+	case "etcd", "":
+		env, ok := environments[svc.Env]
+		if !ok && svc.Env != "" {
+			return nil, fmt.Errorf("unknown environment '%s'", svc.Env)
+		}
+		if d.Endpoint == "" || svc.Service == "" {
+			return nil, errors.New("etcd endpoint and service name are required")
+		}
+		resolver := discovery.NewEtcdResolver(
+			discovery.WithResolverSystem(discovery.GRPCSystem),
+			discovery.WithResolverService(svc.Service),
+			discovery.WithEnvironment(env),
+			discovery.WithDialTimeout(time.Duration(d.DialTimeout)*time.Second))
+		options = append(options, grpc.WithBalancer(grpc.RoundRobin(resolver)))
+		target = d.Endpoint
+	case "direct":
+		if target == "" {
+			return nil, errors.New("target is required")
+		}
+	default:
+		return nil, fmt.Errorf("unknown discovery backend '%s'", d.Backend)
+	}
+
+	if svc.TLS.Enable {
+		creds, err := clientCredentials(&svc.TLS)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.WithTransportCredentials(creds))
+	} else {
+		options = append(options, grpc.WithInsecure())
+	}
+
+	retry, err := retryInterceptor(&svc.Retry)
+	if err != nil {
+		return nil, err
+	}
+	unary := []grpc.UnaryClientInterceptor{timeoutInterceptor(time.Duration(svc.RequestTimeout) * time.Millisecond), retry}
+	if interceptorClient != nil {
+		// every attempt is traced and measured
+		unary = append(unary, interceptorClient.GRPCUnaryClientInterceptor())
+		options = append(options, grpc.WithStreamInterceptor(interceptorClient.GRPCStreamClientInterceptor()))
+	}
+	options = append(options, grpc.WithUnaryInterceptor(chainUnaryInterceptors(unary...)))
+
+	dialTimeout := svc.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultServiceDialTimeout
+	}
+	// block until connected, otherwise the dial returns at once and the timeout never applies
+	options = append(options, grpc.WithBlock())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(dialTimeout)*time.Second)
+	defer cancel()
+	return grpc.DialContext(ctx, target, options...)
+}
+
+func clientCredentials(cfg *TLSConfig) (credentials.TransportCredentials, error) {
+	config := &tls.Config{ServerName: cfg.ServerName, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in '%s'", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// timeoutInterceptor bound the calls without deadline by timeout, the retries of a call are bounded together
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// retryInterceptor retry the calls failed with the codes of policy until attempts run out or ctx is done
+func retryInterceptor(policy *RetryConfig) (grpc.UnaryClientInterceptor, error) {
+	retryable := make(map[codes.Code]bool, len(policy.Codes))
+	for _, name := range policy.Codes {
+		code, ok := parseCode(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown status code '%s'", name)
+		}
+		retryable[code] = true
+	}
+	initial := time.Duration(policy.InitialBackoff) * time.Millisecond
+	if initial <= 0 {
+		initial = defaultInitialBackoff * time.Millisecond
+	}
+	max := time.Duration(policy.MaxBackoff) * time.Millisecond
+	if max <= 0 {
+		max = defaultMaxBackoff * time.Millisecond
+	}
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		backoff := initial
+		for attempt := 1; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || attempt >= policy.MaxAttempts || !retryable[status.Code(err)] {
+				return err
+			}
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return err
+			}
+			if backoff *= 2; backoff > max {
+				backoff = max
+			}
+		}
+	}, nil
+}
+
+// parseCode find status code by its name, e.g. "Unavailable" or "DeadlineExceeded"
+func parseCode(name string) (codes.Code, bool) {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if c.String() == name {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// chainUnaryInterceptors make one interceptor of several, the first one is the outermost
+func chainUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		chained := invoker
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return interceptor(ctx, method, req, reply, cc, next, opts...)
+			}
+		}
+		return chained(ctx, method, req, reply, cc, opts...)
+	}
+}
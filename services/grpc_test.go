@@ -0,0 +1,106 @@
+package services
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func failingInvoker(calls *int, failures int, code codes.Code) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*calls++
+		if *calls <= failures {
+			return status.Error(code, "failed")
+		}
+		return nil
+	}
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	retry, err := retryInterceptor(&RetryConfig{MaxAttempts: 3, InitialBackoff: 1, MaxBackoff: 2, Codes: []string{"Unavailable"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	if err := retry(context.Background(), "/m", nil, nil, nil, failingInvoker(&calls, 2, codes.Unavailable)); err != nil || calls != 3 {
+		t.Errorf("expect success after 3 calls, got %v after %d", err, calls)
+	}
+	calls = 0
+	if err := retry(context.Background(), "/m", nil, nil, nil, failingInvoker(&calls, 5, codes.Unavailable)); status.Code(err) != codes.Unavailable || calls != 3 {
+		t.Errorf("expect Unavailable after 3 calls, got %v after %d", err, calls)
+	}
+	calls = 0
+	if err := retry(context.Background(), "/m", nil, nil, nil, failingInvoker(&calls, 5, codes.InvalidArgument)); status.Code(err) != codes.InvalidArgument || calls != 1 {
+		t.Errorf("expect no retry of InvalidArgument, got %v after %d", err, calls)
+	}
+
+	if _, err := retryInterceptor(&RetryConfig{Codes: []string{"Unknownable"}}); err == nil {
+		t.Error("expect error of unknown code")
+	}
+}
+
+func TestChainUnaryInterceptors(t *testing.T) {
+	var order []string
+	named := func(name string) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			order = append(order, name)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+	chained := chainUnaryInterceptors(named("a"), named("b"), timeoutInterceptor(1000000))
+	err := chained(context.Background(), "/m", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expect deadline set by timeout interceptor")
+		}
+		order = append(order, "invoker")
+		return nil
+	})
+	if err != nil || len(order) != 3 || order[0] != "a" || order[1] != "b" || order[2] != "invoker" {
+		t.Errorf("unexpected order %v, err %v", order, err)
+	}
+}
+
+func TestNewClientRegistryValidation(t *testing.T) {
+	cases := []*GRPCConfig{
+		{Discovery: DiscoveryConfig{Backend: "consul"}, Services: []ServiceConfig{{Name: "greeter", Target: "localhost:50051"}}},
+		{Discovery: DiscoveryConfig{Backend: "etcd", Endpoint: "http://localhost:2379"}, Services: []ServiceConfig{{Name: "greeter", Service: "hello_service", Env: "prod"}}},
+		{Discovery: DiscoveryConfig{Backend: "direct"}, Services: []ServiceConfig{{Name: "greeter"}}},
+		{Discovery: DiscoveryConfig{Backend: "direct"}, Services: []ServiceConfig{{Target: "localhost:50051"}}},
+	}
+	for i, cfg := range cases {
+		if _, err := NewClientRegistry(cfg, nil); err == nil {
+			t.Errorf("case %d: expect error", i)
+		}
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// dials block, so unreachable services fail in DialTimeout
+	unreachable := &GRPCConfig{Discovery: DiscoveryConfig{Backend: "direct"}, Services: []ServiceConfig{{Name: "greeter", Target: "127.0.0.1:1", DialTimeout: 1}}}
+	if _, err := NewClientRegistry(unreachable, nil); err == nil {
+		t.Error("expect error of unreachable service")
+	}
+
+	r, err := NewClientRegistry(&GRPCConfig{Discovery: DiscoveryConfig{Backend: "direct"}, Services: []ServiceConfig{{Name: "greeter", Target: lis.Addr().String()}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Conn("greeter"); err != nil {
+		t.Error(err)
+	}
+	if _, err := r.Conn("unknown"); err != ErrUnknownService {
+		t.Errorf("expect ErrUnknownService, got %v", err)
+	}
+}
@@ -1,58 +1,35 @@
 package services
 
 import (
-	"fmt"
 	"time"
 	"strconv"
 	"golang.org/x/net/context"
 
-	"google.golang.org/grpc"
-
-	"github.com/slover2000/prisma"
-	"github.com/slover2000/prisma/discovery"
 	pb "github.com/slover2000/beego_demo/helloworld"
 )
 
-var grpcConn *grpc.ClientConn
-
-func InitHelloServiceClient(addr string, interceptorClient *prisma.InterceptorClient) error {
-    // create a resolver
-    resolver := discovery.NewEtcdResolver(
-		discovery.WithResolverSystem(discovery.GRPCSystem),
-		discovery.WithResolverService("hello_service"),
-		discovery.WithEnvironment(discovery.Product),
-		discovery.WithDialTimeout(5 * time.Second))
-	b := grpc.RoundRobin(resolver)
+// GreeterService is the name of hello service in grpc config
+const GreeterService = "greeter"
 
-	ctx, _ := context.WithTimeout(context.Background(), 10 * time.Second)
-    conn, err := grpc.DialContext(
-        ctx, 
-        addr, 
-        grpc.WithInsecure(), 
-        grpc.WithBalancer(b),
-        grpc.WithUnaryInterceptor(interceptorClient.GRPCUnaryClientInterceptor()), 
-        grpc.WithStreamInterceptor(interceptorClient.GRPCStreamClientInterceptor()))
-    if err != nil {
-        return err
+// GreeterClient return the client of hello service, ErrUnknownService is returned when it isn't configured
+func GreeterClient() (pb.GreeterClient, error) {
+	conn, err := GRPCConn(GreeterService)
+	if err != nil {
+		return nil, err
 	}
-
-	grpcConn = conn
-	return nil
+	return pb.NewGreeterClient(conn), nil
 }
 
-func CloseHelloServiceClient() {
-	if grpcConn != nil {
-		grpcConn.Close();
+// QueryGrpcDemo say hello to the greeter service and return its reply
+func QueryGrpcDemo(ctx context.Context) (string, error) {
+	client, err := GreeterClient()
+	if err != nil {
+		return "", err
 	}
-}
-
-func QueryGrpcDemo(ctx context.Context) {
 	t := time.Now().Second()
-	client := pb.NewGreeterClient(grpcConn)
-	reqCtx, cancel := context.WithTimeout(ctx, 10 * time.Second)
-	resp, err := client.SayHello(reqCtx, &pb.HelloRequest{Name: "world " + strconv.Itoa(t)})
-	cancel()
-	if err == nil {
-		fmt.Printf("%d: Reply is %s\n", t, resp.Message)
-	}	
-}
\ No newline at end of file
+	resp, err := client.SayHello(ctx, &pb.HelloRequest{Name: "world " + strconv.Itoa(t)})
+	if err != nil {
+		return "", err
+	}
+	return resp.Message, nil
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidMailHeader is returned when address or subject of mail contains line breaks,
+// they would inject headers into the mail
+var ErrInvalidMailHeader = errors.New("mail header contains line breaks")
+
+// Mailer sends plain text mails
+type Mailer interface {
+	Send(to []string, subject, body string) error
+}
+
+// MailConfig is the settings of mailer
+type MailConfig struct {
+	Adapter  string // smtp or file
+	Host     string
+	Port     int
+	User     string
+	Password string
+	From     string
+	File     string
+}
+
+// SMTPMailer sends mails through smtp server
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// FileMailer appends mails to a file instead of sending them, it's used by development and tests
+type FileMailer struct {
+	path string
+	from string
+	lock sync.Mutex
+}
+
+var mailer Mailer = NewFileMailer("")
+
+// InitMailer create the mailer used by SendMail
+func InitMailer(cfg *MailConfig) error {
+	switch cfg.Adapter {
+	case "smtp":
+		mailer = NewSMTPMailer(cfg)
+	case "file", "":
+		m := NewFileMailer(cfg.File)
+		if cfg.From != "" {
+			m.from = cfg.From
+		}
+		mailer = m
+	default:
+		return fmt.Errorf("unknown mail adapter '%s'", cfg.Adapter)
+	}
+	return nil
+}
+
+// SetMailer replace the mailer used by SendMail
+func SetMailer(m Mailer) {
+	mailer = m
+}
+
+// SendMail send mail by the configured mailer
+func SendMail(to []string, subject, body string) error {
+	return mailer.Send(to, subject, body)
+}
+
+func buildMessage(from string, to []string, subject, body string) ([]byte, error) {
+	for _, value := range append([]string{from, subject}, to...) {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, ErrInvalidMailHeader
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	buf.WriteString(body)
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil
+}
+
+// NewSMTPMailer create a smtp mailer, plain auth is used when user is set
+func NewSMTPMailer(cfg *MailConfig) *SMTPMailer {
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		from: cfg.From,
+	}
+	if cfg.User != "" {
+		m.auth = smtp.PlainAuth("", cfg.User, cfg.Password, cfg.Host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(to []string, subject, body string) error {
+	msg, err := buildMessage(m.from, to, subject, body)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, to, msg)
+}
+
+// NewFileMailer create a mailer which writes mails into file
+func NewFileMailer(path string) *FileMailer {
+	if path == "" {
+		path = "mail.log"
+	}
+	return &FileMailer{path: path, from: "noreply@localhost"}
+}
+
+func (m *FileMailer) Send(to []string, subject, body string) error {
+	msg, err := buildMessage(m.from, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(msg, '\n'))
+	return err
+}
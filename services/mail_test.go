@@ -0,0 +1,39 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	if err != nil {
+		t.Fatalf("create temp dir failed:%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mail.log")
+	m := NewFileMailer(path)
+	if err := m.Send([]string{"alice@example.com"}, "hello", "verify link"); err != nil {
+		t.Fatalf("send mail failed:%v", err)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	content := string(data)
+	if !strings.Contains(content, "To: alice@example.com") || !strings.Contains(content, "Subject: hello") || !strings.Contains(content, "verify link") {
+		t.Errorf("unexpected mail content:%s", content)
+	}
+
+	if err := m.Send([]string{"a@b\r\nBcc: eve@example.com"}, "hello", "verify link"); err != ErrInvalidMailHeader {
+		t.Errorf("address with line breaks should be rejected, got %v", err)
+	}
+	if err := m.Send([]string{"alice@example.com"}, "hello\nBcc: eve@example.com", "verify link"); err != ErrInvalidMailHeader {
+		t.Errorf("subject with line breaks should be rejected, got %v", err)
+	}
+	if data, _ := ioutil.ReadFile(path); strings.Contains(string(data), "Bcc") {
+		t.Errorf("rejected mail shouldn't be written:%s", data)
+	}
+}
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrOIDCDisabled is returned when single sign-on isn't configured
+	ErrOIDCDisabled = errors.New("oidc login is disabled")
+	// ErrInvalidIDToken is returned when ID token can't be verified
+	ErrInvalidIDToken = errors.New("id token is invalid")
+)
+
+// OIDCConfig is the settings of OpenID Connect identity provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	Timeout      time.Duration
+}
+
+// OIDCAuthRequest holds the values of one login which must be kept until callback
+type OIDCAuthRequest struct {
+	URL          string
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// OIDCIdentity is the identity verified from ID token
+type OIDCIdentity struct {
+	Issuer  string
+	Subject string
+	Name    string
+	Email   string
+	Groups  []string
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// OIDCProvider performs authorization code flow with PKCE against an identity provider
+type OIDCProvider struct {
+	config   OIDCConfig
+	metadata oidcMetadata
+	client   *http.Client
+	lock     sync.RWMutex
+	keys     map[string]*rsa.PublicKey
+	// refresh serializes fetches of key set, fetchedAt is guarded by it
+	refresh   sync.Mutex
+	fetchedAt time.Time
+}
+
+// jwksRefreshInterval limits how often unknown key ids fetch the key set again
+const jwksRefreshInterval = time.Minute
+
+var oidcProvider *OIDCProvider
+
+// InitOIDCProvider discover the identity provider used by single sign-on
+func InitOIDCProvider(cfg *OIDCConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, err := NewOIDCProvider(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	oidcProvider = provider
+	return nil
+}
+
+// OIDCEnabled check whether single sign-on is available
+func OIDCEnabled() bool {
+	return oidcProvider != nil
+}
+
+// GetOIDCProvider return the provider initialized by InitOIDCProvider
+func GetOIDCProvider() (*OIDCProvider, error) {
+	if oidcProvider == nil {
+		return nil, ErrOIDCDisabled
+	}
+	return oidcProvider, nil
+}
+
+// NewOIDCProvider fetch discovery document of issuer and create a provider
+func NewOIDCProvider(ctx context.Context, cfg *OIDCConfig) (*OIDCProvider, error) {
+	p := &OIDCProvider{
+		config: *cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+	if p.client.Timeout == 0 {
+		p.client.Timeout = 10 * time.Second
+	}
+	if len(p.config.Scopes) == 0 {
+		p.config.Scopes = []string{"openid", "profile", "email"}
+	}
+	if p.config.GroupsClaim == "" {
+		p.config.GroupsClaim = "groups"
+	}
+
+	discoveryURL := strings.TrimRight(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, &p.metadata); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(p.metadata.Issuer, "/") != strings.TrimRight(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("issuer mismatch, expect %s but got %s", cfg.Issuer, p.metadata.Issuer)
+	}
+	return p, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, u string, v interface{}) error {
+	resp, err := ctxhttp.Get(ctx, p.client, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s failed with status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func randomString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// AuthCodeURL create a login request which redirects user to identity provider
+func (p *OIDCProvider) AuthCodeURL() (*OIDCAuthRequest, error) {
+	req := &OIDCAuthRequest{}
+	var err error
+	if req.State, err = randomString(16); err != nil {
+		return nil, err
+	}
+	if req.Nonce, err = randomString(16); err != nil {
+		return nil, err
+	}
+	if req.CodeVerifier, err = randomString(32); err != nil {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(req.CodeVerifier))
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.config.ClientID)
+	v.Set("redirect_uri", p.config.RedirectURL)
+	v.Set("scope", strings.Join(p.config.Scopes, " "))
+	v.Set("state", req.State)
+	v.Set("nonce", req.Nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	req.URL = p.metadata.AuthorizationEndpoint + sep + v.Encode()
+	return req, nil
+}
+
+// Exchange redeem authorization code and verify the returned ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, req *OIDCAuthRequest) (*OIDCIdentity, error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.config.RedirectURL)
+	v.Set("client_id", p.config.ClientID)
+	v.Set("client_secret", p.config.ClientSecret)
+	v.Set("code_verifier", req.CodeVerifier)
+
+	resp, err := ctxhttp.PostForm(ctx, p.client, p.metadata.TokenEndpoint, v)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	token := &tokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(token); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("exchange code failed:%s %s", token.Error, token.Description)
+	}
+	if token.IDToken == "" {
+		return nil, ErrInvalidIDToken
+	}
+	return p.VerifyIDToken(ctx, token.IDToken, req.Nonce)
+}
+
+// VerifyIDToken check signature, issuer, audience, expiration and nonce of ID token
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (*OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, ErrInvalidIDToken
+		}
+		kid, _ := t.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	})
+	if err != nil || !parsed.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	if !claims.VerifyIssuer(p.metadata.Issuer, true) {
+		return nil, ErrInvalidIDToken
+	}
+	if !hasAudience(claims["aud"], p.config.ClientID) {
+		return nil, ErrInvalidIDToken
+	}
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return nil, ErrInvalidIDToken
+	}
+
+	identity := &OIDCIdentity{Issuer: p.metadata.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["preferred_username"].(string)
+	if identity.Name == "" {
+		identity.Name = identity.Email
+	}
+	if identity.Name == "" {
+		identity.Name = identity.Subject
+	}
+	if groups, ok := claims[p.config.GroupsClaim].([]interface{}); ok {
+		for _, g := range groups {
+			if name, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	}
+	return identity, nil
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// publicKey find signing key by id, the key set is fetched again when key is unknown,
+// at most once per jwksRefreshInterval, so forged key ids can't flood the identity provider
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	if key, ok := p.cachedKey(kid); ok {
+		return key, nil
+	}
+
+	p.refresh.Lock()
+	defer p.refresh.Unlock()
+	// the key set may have been fetched while waiting
+	if key, ok := p.cachedKey(kid); ok {
+		return key, nil
+	}
+	if !p.fetchedAt.IsZero() && time.Since(p.fetchedAt) < jwksRefreshInterval {
+		return nil, ErrInvalidIDToken
+	}
+	p.fetchedAt = time.Now()
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.lock.Lock()
+	p.keys = keys
+	p.lock.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrInvalidIDToken
+}
+
+func (p *OIDCProvider) cachedKey(kid string) (*rsa.PublicKey, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	key, ok := p.keys[kid]
+	return key, ok
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// stubIdP is a minimal identity provider which issues one ID token per authorization request
+type stubIdP struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	groups    []string
+	fetches   int
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key failed:%v", err)
+	}
+	idp := &stubIdP{key: key, groups: []string{"developers", "ops"}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		idp.fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken(t, idp.nonce), "access_token": "at"})
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *stubIdP) idToken(t *testing.T, nonce string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                idp.server.URL,
+		"aud":                []string{"demo"},
+		"sub":                "1001",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"groups":             idp.groups,
+	})
+	token.Header["kid"] = "test"
+	raw, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatalf("sign id token failed:%v", err)
+	}
+	return raw
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	idp := newStubIdP(t)
+	defer idp.server.Close()
+
+	ctx := context.Background()
+	provider, err := NewOIDCProvider(ctx, &OIDCConfig{
+		Issuer:      idp.server.URL,
+		ClientID:    "demo",
+		RedirectURL: "http://localhost:8080/sso/callback",
+	})
+	if err != nil {
+		t.Fatalf("discover provider failed:%v", err)
+	}
+
+	req, err := provider.AuthCodeURL()
+	if err != nil {
+		t.Fatalf("create auth request failed:%v", err)
+	}
+	u, _ := url.Parse(req.URL)
+	q := u.Query()
+	if q.Get("state") != req.State || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization url:%s", req.URL)
+	}
+	idp.challenge = q.Get("code_challenge")
+	idp.nonce = q.Get("nonce")
+
+	identity, err := provider.Exchange(ctx, "good-code", req)
+	if err != nil {
+		t.Fatalf("exchange code failed:%v", err)
+	}
+	if identity.Name != "alice" || identity.Email != "alice@example.com" || len(identity.Groups) != 2 {
+		t.Errorf("unexpected identity:%+v", identity)
+	}
+
+	if _, err := provider.Exchange(ctx, "bad-code", req); err == nil {
+		t.Errorf("exchange with bad code should fail")
+	}
+
+	wrongVerifier := *req
+	wrongVerifier.CodeVerifier = "wrong"
+	if _, err := provider.Exchange(ctx, "good-code", &wrongVerifier); err == nil {
+		t.Errorf("exchange with wrong code verifier should fail")
+	}
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	idp := newStubIdP(t)
+	defer idp.server.Close()
+
+	ctx := context.Background()
+	provider, err := NewOIDCProvider(ctx, &OIDCConfig{Issuer: idp.server.URL, ClientID: "demo"})
+	if err != nil {
+		t.Fatalf("discover provider failed:%v", err)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, idp.idToken(t, "n1"), "n1"); err != nil {
+		t.Errorf("verify id token failed:%v", err)
+	}
+	if _, err := provider.VerifyIDToken(ctx, idp.idToken(t, "n1"), "n2"); err != ErrInvalidIDToken {
+		t.Errorf("id token with wrong nonce should be invalid, got %v", err)
+	}
+
+	other, _ := NewOIDCProvider(ctx, &OIDCConfig{Issuer: idp.server.URL, ClientID: "other"})
+	if _, err := other.VerifyIDToken(ctx, idp.idToken(t, "n1"), "n1"); err != ErrInvalidIDToken {
+		t.Errorf("id token for other audience should be invalid, got %v", err)
+	}
+}
+
+func TestOIDCKeySetRefreshIsLimited(t *testing.T) {
+	idp := newStubIdP(t)
+	defer idp.server.Close()
+
+	ctx := context.Background()
+	provider, err := NewOIDCProvider(ctx, &OIDCConfig{Issuer: idp.server.URL, ClientID: "demo"})
+	if err != nil {
+		t.Fatalf("discover provider failed:%v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := provider.publicKey(ctx, "forged"); err != ErrInvalidIDToken {
+			t.Errorf("unknown key should be rejected, got %v", err)
+		}
+	}
+	if _, err := provider.publicKey(ctx, "test"); err != nil {
+		t.Errorf("known key should be found, got %v", err)
+	}
+	if idp.fetches != 1 {
+		t.Errorf("key set should be fetched once within refresh interval, got %d", idp.fetches)
+	}
+}
@@ -1,47 +1,213 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 
-	"github.com/olivere/elastic"
+	"github.com/slover2000/beego_demo/events"
+	"github.com/slover2000/beego_demo/models"
 )
 
-func SearchUser(id string) {
-	client, err := elastic.NewClient(elastic.SetURL("http://192.168.2.10:9201"))
-	if err != nil {
-		// Handle error
+var (
+	// ErrSearchUnavailable is returned when search isn't enabled
+	ErrSearchUnavailable = errors.New("search is unavailable")
+	// ErrInvalidSort is returned when users are sorted by a field which isn't sortable
+	ErrInvalidSort = errors.New("sort field is invalid")
+)
+
+// sortFields map the sort fields of api to the fields of elasticsearch index
+var sortFields = map[string]string{
+	"name":        "name.keyword",
+	"email":       "email.keyword",
+	"age":         "age",
+	"create_time": "create_time",
+	"update_time": "update_time",
+}
+
+// SearchConfig is the settings of user search. Engine is elastic or memory, the memory engine keeps the index
+// in process so it works without elasticsearch. Index is the alias of user index and Timeout is in seconds
+type SearchConfig struct {
+	Enable   bool
+	Engine   string `default:"elastic"`
+	URLs     []string
+	Username string
+	Password string
+	Sniff    bool
+	Index    string `default:"users"`
+	Shards   int    `default:"1"`
+	Replicas int    `default:"1"`
+	Timeout  int    `default:"5"`
+}
+
+// UserDocument is the document of user in index, password is never indexed
+type UserDocument struct {
+	Id         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	Gender     string    `json:"gender"`
+	Age        int       `json:"age"`
+	Address    string    `json:"address"`
+	CreateTime time.Time `json:"create_time"`
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// NewUserDocument create the document of user
+func NewUserDocument(u *models.User) *UserDocument {
+	return &UserDocument{
+		Id:         u.Id,
+		Name:       u.Name,
+		Email:      u.Profile.Email,
+		Gender:     u.Profile.Gender,
+		Age:        u.Profile.Age,
+		Address:    u.Profile.Address,
+		CreateTime: u.CreateTime,
+		UpdateTime: u.UpdateTime,
 	}
+}
+
+// UserSearchQuery searches users whose name or email matches Text, the other fields filter users when they're set.
+// Users are sorted by relevance unless Sort is given, a '-' prefix sorts descending
+type UserSearchQuery struct {
+	Text          string
+	Gender        string
+	MinAge        int
+	MaxAge        int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Sort          string
+	Offset        int
+	Limit         int
+}
+
+// UserHit is a matched user, Highlights are the matched fragments of name and email with <em> tags
+type UserHit struct {
+	UserDocument
+	Score      float64             `json:"score"`
+	Highlights map[string][]string `json:"highlights,omitempty"`
+}
+
+// UserSearchResult is a page of matched users and the total number of them
+type UserSearchResult struct {
+	Total int64     `json:"total"`
+	Hits  []UserHit `json:"hits"`
+}
+
+// Searcher indexes users and searches them. Text matches the words of name and email with typos allowed,
+// one typo for words of 3 to 5 letters and two for longer words, and matches in name weigh double
+type Searcher interface {
+	// Index add or replace the document of user
+	Index(ctx context.Context, u *models.User) error
+	// Delete remove the document of user, it's fine that the document doesn't exist
+	Delete(ctx context.Context, id int64) error
+	Search(ctx context.Context, q *UserSearchQuery) (*UserSearchResult, error)
+	// Reindex replace the index by all users of repo, searches keep working on the old index until the new one
+	// is complete. Return the number of indexed users
+	Reindex(ctx context.Context, repo models.UserRepository, batch int) (int, error)
+	Close()
+}
+
+var searcher Searcher
 
-	get, err := client.Get().Index("users").Type("doc").Id(id).Do(context.Background())
+// InitSearchClient create the searcher of engine in config
+func InitSearchClient(cfg *SearchConfig) error {
+	s, err := NewSearcher(cfg)
 	if err != nil {
-		// Handle error
-		panic(err)
+		return err
 	}
+	searcher = s
+	return nil
+}
 
-	if get.Found {
-		fmt.Printf("Got document %s in version %d from index %s, type %s\n", get.Id, get.Version, get.Index, get.Type)
-	}	
+// NewSearcher create searcher by the engine of config
+func NewSearcher(cfg *SearchConfig) (Searcher, error) {
+	switch cfg.Engine {
+	case "elastic", "":
+		return NewElasticSearcher(cfg)
+	case "memory":
+		return NewMemorySearcher(), nil
+	}
+	return nil, fmt.Errorf("unknown search engine '%s'", cfg.Engine)
 }
 
-func SearchUserByName(name string) {
-	client, err := elastic.NewClient(elastic.SetURL("http://192.168.2.10:9201", "http://127.0.0.1:9201"))
-	if err != nil {
-		// Handle error
-	}	
-	// Search with a term query
-	termQuery := elastic.NewTermQuery("name", name)
-	searchResult, err := client.Search().
-		Index("users").        // search in index "users"
-		Query(termQuery).        // specify the query
-		Sort("user", true).      // sort by "user" field, ascending
-		From(0).Size(10).        // take documents 0-9
-		Pretty(true).            // pretty print request and response JSON
-		Do(context.Background()) // execute
-	if err != nil {
-		// Handle error
-		panic(err)
+// CloseSearchClient release the searcher created by InitSearchClient
+func CloseSearchClient() {
+	if searcher != nil {
+		searcher.Close()
+	}
+}
+
+// SearchUsers search users by the searcher created by InitSearchClient
+func SearchUsers(ctx context.Context, q *UserSearchQuery) (*UserSearchResult, error) {
+	if searcher == nil {
+		return nil, ErrSearchUnavailable
 	}
+	return searcher.Search(ctx, q)
+}
 
-	fmt.Printf("Query took %d milliseconds\n", searchResult.TookInMillis)
-}
\ No newline at end of file
+// ReindexUsers rebuild the index of searcher created by InitSearchClient from repo
+func ReindexUsers(ctx context.Context, repo models.UserRepository, batch int) (int, error) {
+	if searcher == nil {
+		return 0, ErrSearchUnavailable
+	}
+	return searcher.Reindex(ctx, repo, batch)
+}
+
+// IndexUserChanges keep the index of searcher created by InitSearchClient in sync with the user changes on bus.
+// The returned function unsubscribes
+func IndexUserChanges(bus *events.Bus) func() {
+	if searcher == nil {
+		return func() {}
+	}
+	return FollowUserChanges(bus, searcher)
+}
+
+// FollowUserChanges index the user changes published on bus by s, the returned function unsubscribes.
+// Failures are logged, run reindex to repair the index
+func FollowUserChanges(bus *events.Bus, s Searcher) func() {
+	return bus.Subscribe(events.UserTopic, 1024, func(e events.Event) {
+		changed, ok := e.(*events.UserChanged)
+		if !ok {
+			return
+		}
+		var err error
+		if changed.Removed() {
+			err = s.Delete(context.Background(), changed.ID)
+		} else {
+			err = s.Index(context.Background(), changed.User)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"user": changed.ID, "op": changed.Op}).Warnf("index user failed:%s", err.Error())
+		}
+	})
+}
+
+// eachUserBatch read all users of repo by batch in order of id, return the number of users handled by fn.
+// Batches start after the last id rather than at an offset, so users deleted meanwhile never shift others out
+func eachUserBatch(ctx context.Context, repo models.UserRepository, batch int, fn func(users []models.User) error) (int, error) {
+	if batch <= 0 {
+		batch = 500
+	}
+	count := 0
+	for last := int64(0); ; {
+		q := models.NewQuery(models.UserQuerySchema, 0, batch)
+		q.Filters = []models.Filter{{Field: "id", Op: ">", Value: last}}
+		users, _, err := repo.List(ctx, q)
+		if err != nil {
+			return count, err
+		}
+		if len(users) > 0 {
+			if err := fn(users); err != nil {
+				return count, err
+			}
+			count += len(users)
+			last = users[len(users)-1].Id
+		}
+		if len(users) < batch {
+			return count, nil
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/slover2000/beego_demo/models"
+)
+
+const userDocType = "doc"
+
+// userMapping is the mapping of user index, names and emails are analyzed for full-text search
+// and their keyword fields are sorted on
+const userMapping = `{
+	"settings": {"number_of_shards": %d, "number_of_replicas": %d},
+	"mappings": {
+		"doc": {
+			"properties": {
+				"id":          {"type": "long"},
+				"name":        {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+				"email":       {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+				"gender":      {"type": "keyword"},
+				"age":         {"type": "integer"},
+				"address":     {"type": "text"},
+				"create_time": {"type": "date"},
+				"update_time": {"type": "date"}
+			}
+		}
+	}
+}`
+
+// ElasticSearcher keeps users in elasticsearch, it's safe for concurrent use
+type ElasticSearcher struct {
+	client  *elastic.Client
+	alias   string
+	shards  int
+	replica int
+	timeout time.Duration
+}
+
+// NewElasticSearcher connect elasticsearch, the index behind alias of config is created if it doesn't exist
+func NewElasticSearcher(cfg *SearchConfig) (*ElasticSearcher, error) {
+	options := []elastic.ClientOptionFunc{
+		elastic.SetURL(cfg.URLs...),
+		elastic.SetSniff(cfg.Sniff),
+	}
+	if cfg.Username != "" {
+		options = append(options, elastic.SetBasicAuth(cfg.Username, cfg.Password))
+	}
+	client, err := elastic.NewClient(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ElasticSearcher{
+		client:  client,
+		alias:   cfg.Index,
+		shards:  cfg.Shards,
+		replica: cfg.Replicas,
+		timeout: time.Duration(cfg.Timeout) * time.Second,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	exists, err := client.IndexExists(s.alias).Do(ctx)
+	if err == nil && !exists {
+		var name string
+		if name, err = s.createIndex(ctx); err == nil {
+			_, err = client.Alias().Add(name, s.alias).Do(ctx)
+		}
+	}
+	if err != nil {
+		client.Stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close stop the background routines of client
+func (s *ElasticSearcher) Close() {
+	s.client.Stop()
+}
+
+// createIndex create an index named after the alias and current time
+func (s *ElasticSearcher) createIndex(ctx context.Context) (string, error) {
+	name := fmt.Sprintf("%s_%d", s.alias, time.Now().UnixNano())
+	_, err := s.client.CreateIndex(name).BodyString(fmt.Sprintf(userMapping, s.shards, s.replica)).Do(ctx)
+	return name, err
+}
+
+func (s *ElasticSearcher) Index(ctx context.Context, u *models.User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.client.Index().Index(s.alias).Type(userDocType).Id(fmt.Sprint(u.Id)).BodyJson(NewUserDocument(u)).Do(ctx)
+	return err
+}
+
+func (s *ElasticSearcher) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.client.Delete().Index(s.alias).Type(userDocType).Id(fmt.Sprint(id)).Do(ctx)
+	if elastic.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *ElasticSearcher) Search(ctx context.Context, q *UserSearchQuery) (*UserSearchResult, error) {
+	query := elastic.NewBoolQuery()
+	if text := strings.TrimSpace(q.Text); text != "" {
+		query.Must(elastic.NewMultiMatchQuery(text, "name^2", "email").Fuzziness("AUTO"))
+	} else {
+		query.Must(elastic.NewMatchAllQuery())
+	}
+	if q.Gender != "" {
+		query.Filter(elastic.NewTermQuery("gender", q.Gender))
+	}
+	if q.MinAge > 0 || q.MaxAge > 0 {
+		ages := elastic.NewRangeQuery("age")
+		if q.MinAge > 0 {
+			ages.Gte(q.MinAge)
+		}
+		if q.MaxAge > 0 {
+			ages.Lte(q.MaxAge)
+		}
+		query.Filter(ages)
+	}
+	if !q.CreatedAfter.IsZero() || !q.CreatedBefore.IsZero() {
+		created := elastic.NewRangeQuery("create_time")
+		if !q.CreatedAfter.IsZero() {
+			created.Gte(q.CreatedAfter)
+		}
+		if !q.CreatedBefore.IsZero() {
+			created.Lt(q.CreatedBefore)
+		}
+		query.Filter(created)
+	}
+
+	search := s.client.Search(s.alias).Type(userDocType).
+		Query(query).
+		Highlight(elastic.NewHighlight().Fields(elastic.NewHighlighterField("name"), elastic.NewHighlighterField("email"))).
+		From(q.Offset).
+		Size(q.Limit)
+	if q.Sort != "" {
+		field, ascending := strings.TrimPrefix(q.Sort, "-"), !strings.HasPrefix(q.Sort, "-")
+		indexed, ok := sortFields[field]
+		if !ok {
+			return nil, ErrInvalidSort
+		}
+		search = search.Sort(indexed, ascending)
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UserSearchResult{Total: resp.Hits.TotalHits, Hits: make([]UserHit, 0, len(resp.Hits.Hits))}
+	for _, hit := range resp.Hits.Hits {
+		var h UserHit
+		if err := json.Unmarshal(*hit.Source, &h.UserDocument); err != nil {
+			return nil, err
+		}
+		if hit.Score != nil {
+			h.Score = *hit.Score
+		}
+		h.Highlights = hit.Highlight
+		result.Hits = append(result.Hits, h)
+	}
+	return result, nil
+}
+
+// Reindex copy users to a new index and switch the alias to it, the old indexes are removed. Changes made during
+// the copy go to the old index, so users are read again after switching, the ones changed since the copy started
+// are indexed again and the copied ones which are gone are deleted. The new index is deleted when copy fails
+func (s *ElasticSearcher) Reindex(ctx context.Context, repo models.UserRepository, batch int) (int, error) {
+	name, err := s.createIndex(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// update times are stamped by other servers whose clocks may be a little apart
+	since := time.Now().Add(-reindexClockSkew)
+	copied := make(map[int64]bool)
+	indexed, err := eachUserBatch(ctx, repo, batch, func(users []models.User) error {
+		for i := range users {
+			copied[users[i].Id] = true
+		}
+		return s.bulkIndex(ctx, name, users)
+	})
+	if err == nil {
+		err = s.switchAlias(ctx, name)
+	}
+	if err != nil {
+		cleanup, cancel := s.withTimeout(context.Background())
+		defer cancel()
+		if _, e := s.client.DeleteIndex(name).Do(cleanup); e != nil {
+			logrus.Warnf("delete user index %s failed:%s", name, e.Error())
+		}
+		return indexed, err
+	}
+
+	if err := s.catchUp(ctx, repo, batch, name, since, copied); err != nil {
+		return indexed, fmt.Errorf("index users changed during reindex failed, reindex again:%v", err)
+	}
+	return indexed, nil
+}
+
+// reindexClockSkew is how far clocks of servers may be apart
+const reindexClockSkew = time.Minute
+
+func (s *ElasticSearcher) bulkIndex(ctx context.Context, name string, users []models.User) error {
+	bulk := s.client.Bulk().Index(name).Type(userDocType)
+	for i := range users {
+		bulk.Add(elastic.NewBulkIndexRequest().Id(fmt.Sprint(users[i].Id)).Doc(NewUserDocument(&users[i])))
+	}
+	resp, err := bulk.Do(ctx)
+	if err != nil {
+		return err
+	}
+	if failed := resp.Failed(); len(failed) > 0 {
+		return fmt.Errorf("index user %s failed:%s", failed[0].Id, failed[0].Error.Reason)
+	}
+	return nil
+}
+
+// switchAlias point the alias to index name only and remove the indexes it pointed to
+func (s *ElasticSearcher) switchAlias(ctx context.Context, name string) error {
+	aliases, err := s.client.Aliases().Index(s.alias).Do(ctx)
+	if err != nil && !elastic.IsNotFound(err) {
+		return err
+	}
+	var old []string
+	if aliases != nil {
+		old = aliases.IndicesByAlias(s.alias)
+	}
+	alias := s.client.Alias().Add(name, s.alias)
+	for _, o := range old {
+		alias = alias.Remove(o, s.alias)
+	}
+	if _, err := alias.Do(ctx); err != nil {
+		return err
+	}
+	if len(old) > 0 {
+		if _, err := s.client.DeleteIndex(old...).Do(ctx); err != nil {
+			logrus.Warnf("delete old user indexes %v failed:%s", old, err.Error())
+		}
+	}
+	return nil
+}
+
+// catchUp index again the users changed since the copy started, and delete the copied users which are gone.
+// The alias takes live changes by now, so they aren't lost
+func (s *ElasticSearcher) catchUp(ctx context.Context, repo models.UserRepository, batch int, name string, since time.Time, copied map[int64]bool) error {
+	_, err := eachUserBatch(ctx, repo, batch, func(users []models.User) error {
+		changed := make([]models.User, 0)
+		for i := range users {
+			if !copied[users[i].Id] || !users[i].UpdateTime.Before(since) {
+				changed = append(changed, users[i])
+			}
+			delete(copied, users[i].Id)
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		return s.bulkIndex(ctx, name, changed)
+	})
+	if err != nil || len(copied) == 0 {
+		return err
+	}
+
+	bulk := s.client.Bulk().Index(name).Type(userDocType)
+	for id := range copied {
+		bulk.Add(elastic.NewBulkDeleteRequest().Id(fmt.Sprint(id)))
+	}
+	resp, err := bulk.Do(ctx)
+	if err != nil {
+		return err
+	}
+	for _, failed := range resp.Failed() {
+		if failed.Status != http.StatusNotFound {
+			return fmt.Errorf("delete user %s failed:%s", failed.Id, failed.Error.Reason)
+		}
+	}
+	return nil
+}
+
+// withTimeout bound ctx by the timeout of config
+func (s *ElasticSearcher) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.timeout)
+}
@@ -0,0 +1,383 @@
+package services
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+
+	"github.com/slover2000/beego_demo/models"
+)
+
+// token is a word of text, start and end are its byte offsets in text
+type token struct {
+	term       string
+	start, end int
+}
+
+type memoryDocument struct {
+	UserDocument
+	name  []token
+	email []token
+}
+
+// MemorySearcher keeps an inverted index of users in process, it's used by development and tests which
+// run without elasticsearch. Text is tokenized like the standard analyzer of elasticsearch and the same
+// fuzziness applies, scores differ from elasticsearch but exact matches still rank above typos
+type MemorySearcher struct {
+	lock  sync.RWMutex
+	docs  map[int64]*memoryDocument
+	terms map[string]map[int64]bool
+}
+
+// NewMemorySearcher create an empty in-process searcher
+func NewMemorySearcher() *MemorySearcher {
+	return &MemorySearcher{
+		docs:  make(map[int64]*memoryDocument),
+		terms: make(map[string]map[int64]bool),
+	}
+}
+
+func (s *MemorySearcher) Index(ctx context.Context, u *models.User) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.remove(u.Id)
+	s.add(NewUserDocument(u))
+	return nil
+}
+
+func (s *MemorySearcher) Delete(ctx context.Context, id int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.remove(id)
+	return nil
+}
+
+func (s *MemorySearcher) add(doc *UserDocument) {
+	d := &memoryDocument{UserDocument: *doc, name: tokenize(doc.Name), email: tokenize(doc.Email)}
+	s.docs[doc.Id] = d
+	for _, tokens := range [][]token{d.name, d.email} {
+		for _, t := range tokens {
+			if s.terms[t.term] == nil {
+				s.terms[t.term] = make(map[int64]bool)
+			}
+			s.terms[t.term][doc.Id] = true
+		}
+	}
+}
+
+func (s *MemorySearcher) remove(id int64) {
+	d, ok := s.docs[id]
+	if !ok {
+		return
+	}
+	delete(s.docs, id)
+	for _, tokens := range [][]token{d.name, d.email} {
+		for _, t := range tokens {
+			delete(s.terms[t.term], id)
+			if len(s.terms[t.term]) == 0 {
+				delete(s.terms, t.term)
+			}
+		}
+	}
+}
+
+func (s *MemorySearcher) Search(ctx context.Context, q *UserSearchQuery) (*UserSearchResult, error) {
+	var less func(a, b *UserHit) bool
+	if q.Sort != "" {
+		field, ascending := strings.TrimPrefix(q.Sort, "-"), !strings.HasPrefix(q.Sort, "-")
+		if _, ok := sortFields[field]; !ok {
+			return nil, ErrInvalidSort
+		}
+		less = fieldLess(field, ascending)
+	} else {
+		less = func(a, b *UserHit) bool { return a.Score > b.Score }
+	}
+
+	s.lock.RLock()
+	hits := make([]UserHit, 0)
+	queryTerms := uniqueTerms(tokenize(strings.TrimSpace(q.Text)))
+	if len(queryTerms) == 0 {
+		for _, d := range s.docs {
+			if d.matches(q) {
+				hits = append(hits, UserHit{UserDocument: d.UserDocument, Score: 1})
+			}
+		}
+	} else {
+		weights := s.expand(queryTerms)
+		candidates := make(map[int64]bool)
+		for _, expanded := range weights {
+			for term := range expanded {
+				for id := range s.terms[term] {
+					candidates[id] = true
+				}
+			}
+		}
+		for id := range candidates {
+			d := s.docs[id]
+			if !d.matches(q) {
+				continue
+			}
+			nameScore, nameMatched := fieldScore(d.name, weights)
+			emailScore, emailMatched := fieldScore(d.email, weights)
+			h := UserHit{UserDocument: d.UserDocument, Score: nameScore * 2, Highlights: make(map[string][]string)}
+			if emailScore > h.Score {
+				h.Score = emailScore
+			}
+			if len(nameMatched) > 0 {
+				h.Highlights["name"] = []string{highlight(d.Name, d.name, nameMatched)}
+			}
+			if len(emailMatched) > 0 {
+				h.Highlights["email"] = []string{highlight(d.Email, d.email, emailMatched)}
+			}
+			hits = append(hits, h)
+		}
+	}
+	s.lock.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		if less(&hits[i], &hits[j]) {
+			return true
+		}
+		if less(&hits[j], &hits[i]) {
+			return false
+		}
+		return hits[i].Id < hits[j].Id
+	})
+
+	result := &UserSearchResult{Total: int64(len(hits))}
+	if q.Offset < len(hits) {
+		hits = hits[q.Offset:]
+	} else {
+		hits = hits[:0]
+	}
+	if q.Limit < len(hits) {
+		hits = hits[:q.Limit]
+	}
+	result.Hits = hits
+	return result, nil
+}
+
+// expand find the terms of index matched by every query term, the weight of a term with typos is lower
+func (s *MemorySearcher) expand(queryTerms []string) map[string]map[string]float64 {
+	weights := make(map[string]map[string]float64, len(queryTerms))
+	for _, q := range queryTerms {
+		expanded := make(map[string]float64)
+		length := utf8.RuneCountInString(q)
+		max := maxEdits(length)
+		for term := range s.terms {
+			if d := editDistance(q, term, max); d <= max {
+				expanded[term] = 1 - float64(d)/float64(length)
+			}
+		}
+		weights[q] = expanded
+	}
+	return weights
+}
+
+// Reindex build a new index from repo and replace the current one with it
+func (s *MemorySearcher) Reindex(ctx context.Context, repo models.UserRepository, batch int) (int, error) {
+	fresh := NewMemorySearcher()
+	count, err := eachUserBatch(ctx, repo, batch, func(users []models.User) error {
+		for i := range users {
+			fresh.add(NewUserDocument(&users[i]))
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	s.lock.Lock()
+	s.docs, s.terms = fresh.docs, fresh.terms
+	s.lock.Unlock()
+	return count, nil
+}
+
+func (s *MemorySearcher) Close() {}
+
+// matches check the filters of query
+func (d *memoryDocument) matches(q *UserSearchQuery) bool {
+	if q.Gender != "" && d.Gender != q.Gender {
+		return false
+	}
+	if (q.MinAge > 0 && d.Age < q.MinAge) || (q.MaxAge > 0 && d.Age > q.MaxAge) {
+		return false
+	}
+	if !q.CreatedAfter.IsZero() && d.CreateTime.Before(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !d.CreateTime.Before(q.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// fieldScore sum the best weight of every query term in tokens of field, matched are the indexes of matched tokens
+func fieldScore(tokens []token, weights map[string]map[string]float64) (score float64, matched map[int]bool) {
+	matched = make(map[int]bool)
+	for _, expanded := range weights {
+		best := 0.0
+		for i, t := range tokens {
+			if w, ok := expanded[t.term]; ok {
+				matched[i] = true
+				if w > best {
+					best = w
+				}
+			}
+		}
+		score += best
+	}
+	return score, matched
+}
+
+// highlight wrap the matched tokens of text with <em> tags
+func highlight(text string, tokens []token, matched map[int]bool) string {
+	var b bytes.Buffer
+	last := 0
+	for i, t := range tokens {
+		if !matched[i] {
+			continue
+		}
+		b.WriteString(text[last:t.start])
+		b.WriteString("<em>")
+		b.WriteString(text[t.start:t.end])
+		b.WriteString("</em>")
+		last = t.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func fieldLess(field string, ascending bool) func(a, b *UserHit) bool {
+	var less func(a, b *UserHit) bool
+	switch field {
+	case "name":
+		less = func(a, b *UserHit) bool { return a.Name < b.Name }
+	case "email":
+		less = func(a, b *UserHit) bool { return a.Email < b.Email }
+	case "age":
+		less = func(a, b *UserHit) bool { return a.Age < b.Age }
+	case "create_time":
+		less = func(a, b *UserHit) bool { return a.CreateTime.Before(b.CreateTime) }
+	case "update_time":
+		less = func(a, b *UserHit) bool { return a.UpdateTime.Before(b.UpdateTime) }
+	}
+	if ascending {
+		return less
+	}
+	return func(a, b *UserHit) bool { return less(b, a) }
+}
+
+// tokenize split text into lower case words like the standard tokenizer, letters and digits make words,
+// dots and apostrophes between them don't break words and every han character is a word
+func tokenize(text string) []token {
+	tokens := make([]token, 0)
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, token{term: strings.ToLower(text[start:end]), start: start, end: end})
+			start = -1
+		}
+	}
+	for i, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush(i)
+			tokens = append(tokens, token{term: string(r), start: i, end: i + utf8.RuneLen(r)})
+		case isWordRune(r):
+			if start < 0 {
+				start = i
+			}
+		case (r == '.' || r == '\'') && start >= 0:
+			next, _ := utf8.DecodeRuneInString(text[i+utf8.RuneLen(r):])
+			if !isWordRune(next) {
+				flush(i)
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(text))
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') && !unicode.Is(unicode.Han, r)
+}
+
+func uniqueTerms(tokens []token) []string {
+	seen := make(map[string]bool, len(tokens))
+	terms := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		if !seen[t.term] {
+			seen[t.term] = true
+			terms = append(terms, t.term)
+		}
+	}
+	return terms
+}
+
+// maxEdits is the AUTO fuzziness of elasticsearch
+func maxEdits(length int) int {
+	switch {
+	case length <= 2:
+		return 0
+	case length <= 5:
+		return 1
+	}
+	return 2
+}
+
+// editDistance count the insertions, deletions, substitutions and transpositions which turn a into b,
+// max+1 is returned once the distance exceeds max
+func editDistance(a, b string, max int) int {
+	s, t := []rune(a), []rune(b)
+	if d := len(s) - len(t); d > max || -d > max {
+		return max + 1
+	}
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	if prev[len(t)] > max {
+		return max + 1
+	}
+	return prev[len(t)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/slover2000/beego_demo/models"
+)
+
+func newTestSearcher() *MemorySearcher {
+	s := NewMemorySearcher()
+	created := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := []models.User{
+		{Id: 1, Name: "astaxie", CreateTime: created, Profile: models.Profile{Gender: "male", Age: 20, Email: "astaxie@gmail.com"}},
+		{Id: 2, Name: "alice smith", CreateTime: created.AddDate(0, 1, 0), Profile: models.Profile{Gender: "female", Age: 30, Email: "alice@example.com"}},
+		{Id: 3, Name: "bob", CreateTime: created.AddDate(0, 2, 0), Profile: models.Profile{Gender: "male", Age: 40, Email: "bob.astaxie@example.com"}},
+	}
+	for i := range users {
+		s.Index(context.Background(), &users[i])
+	}
+	return s
+}
+
+func hitIDs(result *UserSearchResult) []int64 {
+	ids := make([]int64, len(result.Hits))
+	for i, h := range result.Hits {
+		ids[i] = h.Id
+	}
+	return ids
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := tokenize("Bob.Astaxie@gmail.com 张三 o'neil-x")
+	expect := []string{"bob.astaxie", "gmail.com", "张", "三", "o'neil", "x"}
+	if len(tokens) != len(expect) {
+		t.Fatalf("expect %v but got %v", expect, tokens)
+	}
+	for i, tok := range tokens {
+		if tok.term != expect[i] {
+			t.Errorf("token %d: expect %s but got %s", i, expect[i], tok.term)
+		}
+	}
+}
+
+func TestMemorySearch(t *testing.T) {
+	s := newTestSearcher()
+	ctx := context.Background()
+
+	result, err := s.Search(ctx, &UserSearchQuery{Text: "astaxie", Limit: 10})
+	if err != nil {
+		t.Fatalf("search failed:%v", err)
+	}
+	// matches in name weigh double
+	if ids := hitIDs(result); result.Total != 1 || len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("only the user named astaxie should match, got %v", ids)
+	}
+	if h := result.Hits[0].Highlights; h["name"][0] != "<em>astaxie</em>" || h["email"][0] != "<em>astaxie</em>@gmail.com" {
+		t.Errorf("unexpected highlights %v", h)
+	}
+
+	result, _ = s.Search(ctx, &UserSearchQuery{Text: "alcie", Limit: 10})
+	if ids := hitIDs(result); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("one typo should be allowed, got %v", ids)
+	}
+	result, _ = s.Search(ctx, &UserSearchQuery{Text: "bb", Limit: 10})
+	if result.Total != 0 {
+		t.Errorf("typos of short words aren't allowed, got %v", hitIDs(result))
+	}
+
+	result, _ = s.Search(ctx, &UserSearchQuery{Gender: "male", MinAge: 30, Limit: 10})
+	if ids := hitIDs(result); len(ids) != 1 || ids[0] != 3 {
+		t.Errorf("users should be filtered, got %v", ids)
+	}
+	result, _ = s.Search(ctx, &UserSearchQuery{CreatedAfter: time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC), Sort: "-age", Limit: 10})
+	if ids := hitIDs(result); len(ids) != 2 || ids[0] != 3 || ids[1] != 2 {
+		t.Errorf("users should be sorted by age descending, got %v", ids)
+	}
+	result, _ = s.Search(ctx, &UserSearchQuery{Sort: "name", Offset: 1, Limit: 1})
+	if ids := hitIDs(result); result.Total != 3 || len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("the second page should be astaxie, got %v of %d", ids, result.Total)
+	}
+	if _, err := s.Search(ctx, &UserSearchQuery{Sort: "password"}); err != ErrInvalidSort {
+		t.Errorf("sort by password should be invalid, got %v", err)
+	}
+
+	// "bob.astaxie" is a single word like it's in elasticsearch
+	s.Delete(ctx, 1)
+	result, _ = s.Search(ctx, &UserSearchQuery{Text: "astaxie", Limit: 10})
+	if result.Total != 0 {
+		t.Errorf("deleted user shouldn't match, got %v", hitIDs(result))
+	}
+}
+
+// sliceUserRepository lists users in order of id after the id filter, onList is called before every list
+type sliceUserRepository struct {
+	models.UserRepository
+	users  []models.User
+	onList func()
+}
+
+func (r *sliceUserRepository) List(ctx context.Context, q *models.Query) ([]models.User, int, error) {
+	if r.onList != nil {
+		r.onList()
+	}
+	var after int64
+	for _, f := range q.Filters {
+		if f.Field == "id" && f.Op == ">" {
+			after = f.Value.(int64)
+		}
+	}
+	page := make([]models.User, 0, q.Limit)
+	for _, u := range r.users {
+		if u.Id > after && len(page) < q.Limit {
+			page = append(page, u)
+		}
+	}
+	return page, len(r.users), nil
+}
+
+func TestReindexByID(t *testing.T) {
+	repo := &sliceUserRepository{}
+	for id := int64(1); id <= 5; id++ {
+		repo.users = append(repo.users, models.User{Id: id, Name: fmt.Sprintf("user%d", id)})
+	}
+	lists := 0
+	repo.onList = func() {
+		// user 1 is deleted after the first batch
+		if lists++; lists == 2 {
+			repo.users = repo.users[1:]
+		}
+	}
+
+	s := NewMemorySearcher()
+	count, err := s.Reindex(context.Background(), repo, 2)
+	if err != nil || count != 5 {
+		t.Fatalf("expect 5 users indexed, got %d err:%v", count, err)
+	}
+	result, _ := s.Search(context.Background(), &UserSearchQuery{Limit: 10})
+	if len(result.Hits) != 5 {
+		t.Errorf("users after the deleted one shouldn't be skipped, got %v", hitIDs(result))
+	}
+}
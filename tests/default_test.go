@@ -27,8 +27,8 @@ func TestGet(t *testing.T) {
 	beego.Trace("testing", "TestGet", "Code[%d]\n%s", w.Code, w.Body.String())
 
 	Convey("Subject: Test Station Endpoint\n", t, func() {
-	        Convey("Status Code Should Be 200", func() {
-	                So(w.Code, ShouldEqual, 200)
+	        Convey("Status Code Should Be 401 Without Access Token", func() {
+	                So(w.Code, ShouldEqual, 401)
 	        })
 	        Convey("The Result Should Not Be Empty", func() {
 	                So(w.Body.Len(), ShouldBeGreaterThan, 0)